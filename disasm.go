@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/disasm"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// disassemble runs the disasm subcommand, which prints a disassembly listing of one bank of a rom file.
+func disassemble(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	bank := flags.Int("bank", 0, "")
+	from := flags.String("from", "", "")
+	to := flags.String("to", "", "")
+	symFile := flags.String("sym", "", "")
+	flags.Usage = usage
+
+	// flags can come before or after the rom file
+	flags.Parse(args)
+	romFile := flags.Arg(0)
+	if flags.NArg() > 1 {
+		flags.Parse(flags.Args()[1:])
+	}
+
+	if romFile == "" {
+		flags.Usage()
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(romFile)
+	if err != nil {
+		return err
+	}
+
+	rom := disasm.NewROM(contents, *bank)
+	if *bank < 0 || *bank >= rom.Banks() {
+		return fmt.Errorf("Bank %d doesn't exist, %s only has %d banks", *bank, romFile, rom.Banks())
+	}
+
+	// default to the whole bank
+	start, end := uint16(0x4000), uint16(0x7FFF)
+	if *bank == 0 {
+		start, end = 0x0000, 0x3FFF
+	}
+
+	if *from != "" {
+		if start, err = parseAddress(*from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if end, err = parseAddress(*to); err != nil {
+			return err
+		}
+	}
+
+	// use the symbol file next to the rom unless another one is given
+	var table *symbols.Table
+	if *symFile != "" {
+		table, err = symbols.Load(*symFile)
+	} else {
+		table, err = symbols.LoadForRom(romFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	var syms disasm.Symbols
+	if table != nil {
+		syms = table
+	}
+
+	for _, inst := range disasm.DisassembleWithSymbols(rom, syms, start, end) {
+		if inst.Label != "" {
+			fmt.Println()
+			fmt.Println(inst.Label + ":")
+		}
+		fmt.Println(inst)
+	}
+
+	return nil
+}
+
+// parseAddress parses a hex address, optionally prefixed with $ or 0x.
+func parseAddress(address string) (uint16, error) {
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "$"), "0x")
+
+	parsed, err := strconv.ParseUint(address, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid address", address)
+	}
+	return uint16(parsed), nil
+}
@@ -4,7 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"github.com/robmerrell/gmboy/system"
+	"github.com/robmerrell/gmboy/system/config"
+	"github.com/robmerrell/gmboy/system/ui"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
 )
 
 func init() {
@@ -12,8 +17,36 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		if err := disassemble(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	debug := flag.String("debug", "", "")
+	debugREPL := flag.Bool("debug-repl", false, "")
+	gdbAddress := flag.String("gdb", "", "")
+	dapAddress := flag.String("dap", "", "")
 	bootstrap := flag.String("bootstrap", "", "")
+	trace := flag.String("trace", "", "")
+	traceRange := flag.String("trace-range", "", "")
+	traceBank := flag.Int("trace-bank", -1, "")
+	traceLabels := flag.Bool("trace-labels", false, "")
+	mcycle := flag.Bool("mcycle", false, "")
+	loadState := flag.String("load-state", "", "")
+	rewindInterval := flag.Int("rewind", 0, "")
+	rewindBudget := flag.Int("rewind-budget", 0, "")
+	record := flag.String("record", "", "")
+	play := flag.String("play", "", "")
+	headless := flag.Bool("headless", false, "")
+	speed := flag.String("speed", "", "")
+	scale := flag.Int("scale", 0, "")
+	palette := flag.String("palette", "", "")
+	configFile := flag.String("config", "", "")
+	var breaks stringList
+	flag.Var(&breaks, "break", "")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -23,9 +56,51 @@ func main() {
 		return
 	}
 
-	sys, err := system.NewSystem()
+	cfg, err := loadConfig(*configFile)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		return
+	}
+
+	// flags override the config file
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["scale"] {
+		if cfg.Scale = *scale; cfg.Scale < 1 {
+			fmt.Println("The scale should be at least 1")
+			return
+		}
+	}
+	if set["palette"] {
+		if cfg.Palette, err = ui.ParsePalette(*palette); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if set["speed"] {
+		if cfg.Speed, err = config.ParseSpeed(*speed); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if set["rewind"] {
+		cfg.Rewind = *rewindInterval
+	}
+	if set["rewind-budget"] {
+		cfg.RewindBudget = *rewindBudget
+	}
+
+	var sys *system.System
+	if *headless {
+		sys = system.NewHeadlessSystem()
+	} else {
+		if sys, err = system.NewSystem(cfg.Scale, cfg.Palette, cfg.Bindings); err != nil {
+			panic(err)
+		}
+	}
+
+	if *mcycle {
+		sys.EnableMCycleStepping()
 	}
 
 	if *bootstrap != "" {
@@ -35,7 +110,57 @@ func main() {
 		}
 	}
 
-	if *debug != "" {
+	// the rom is loaded first so its symbols can be used by breakpoints and traces
+	if err := sys.LoadRom(romFile); err != nil {
+		fmt.Printf("Error loading %s\n", romFile)
+		return
+	}
+
+	if *loadState != "" {
+		if err := sys.LoadStateFile(*loadState); err != nil {
+			fmt.Printf("Error loading state from %s: %v\n", *loadState, err)
+			return
+		}
+	}
+
+	// a headless system runs as fast as it can unless it's told otherwise on the command line
+	if !*headless || set["speed"] {
+		if err := sys.SetSpeed(cfg.Speed); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if err := sys.SetTurboRate(cfg.TurboRate); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for name, m := range cfg.Macros {
+		sys.AddMacro(name, m)
+	}
+
+	if *play != "" {
+		if err := sys.PlayMovie(*play); err != nil {
+			fmt.Printf("Error playing %s: %v\n", *play, err)
+			return
+		}
+	}
+
+	if *record != "" {
+		if err := sys.RecordMovie(*record); err != nil {
+			fmt.Printf("Error recording %s: %v\n", *record, err)
+			return
+		}
+	}
+
+	if cfg.Rewind > 0 {
+		if err := sys.EnableRewind(cfg.Rewind, cfg.RewindBudget<<20); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if *debug != "" || *debugREPL || *gdbAddress != "" || *dapAddress != "" || len(breaks) > 0 {
 		err := sys.StartDebugger(*debug)
 		if err != nil {
 			fmt.Printf("Error loading %s\n", *debug)
@@ -43,14 +168,144 @@ func main() {
 		}
 	}
 
-	sys.LoadRom(romFile)
+	for _, spec := range breaks {
+		if err := sys.AddBreakpoint(spec); err != nil {
+			fmt.Printf("Invalid breakpoint %q: %v\n", spec, err)
+			return
+		}
+	}
+
+	if *trace != "" {
+		from, to := uint16(0x0000), uint16(0xFFFF)
+		if *traceRange != "" {
+			var err error
+			if from, to, err = parseRange(*traceRange); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		if err := sys.StartTrace(*trace, from, to, *traceBank, *traceLabels); err != nil {
+			fmt.Printf("Error creating %s\n", *trace)
+			return
+		}
+	}
+
+	if *gdbAddress != "" {
+		if err := sys.StartGDBServer(*gdbAddress); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if *dapAddress != "" {
+		if err := sys.StartDAPServer(*dapAddress); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if *debugREPL {
+		if err := sys.StartREPL(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	// stop cleanly on ctrl-c so everything gets flushed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		sys.Stop()
+	}()
+
 	sys.Run()
 }
 
 func usage() {
 	fmt.Println("Usage:")
-	fmt.Println("  gmbody file.gb\n")
+	fmt.Println("  gmbody file.gb")
+	fmt.Println("  gmbody disasm file.gb [--bank=N] [--from=ADDR] [--to=ADDR] [--sym=file.sym]")
+	fmt.Println()
 	fmt.Println("  --bootstrap=file.bin   Run the bootstrap process using the specified file. Default is to not bootstrap.")
+	fmt.Println("  --break=SPEC           Stop at a breakpoint: ADDR, BANK:ADDR, LABEL, ADDR if COND or if COND, e.g.")
+	fmt.Println("                         --break=Main.loop or --break='if LY == 144'. Can be repeated.")
+	fmt.Println("  --config=file.toml     Load settings and key bindings from the file instead of ~/.config/gmboy/config.toml.")
+	fmt.Println("                         Flags override the file.")
+	fmt.Println("  --dap=stdio|:PORT      Serve the Debug Adapter Protocol for editors over stdio or on a local port.")
 	fmt.Println("  --debug=file.js        Start the debugger and evaluate the specified file.")
+	fmt.Println("  --debug-repl           Start the debugger with an interactive console in the terminal.")
+	fmt.Println("  --gdb=:PORT            Let gdb connect over the GDB remote protocol on a local port, e.g. --gdb=:2345.")
+	fmt.Println("  --headless             Run without a window or keyboard. Stops when the movie given to --play ends.")
+	fmt.Println("  --load-state=file.ss1  Start from a save state. Shift+1-9 save to a numbered slot and 1-9 load it.")
+	fmt.Println("  --mcycle               Step the CPU one M-cycle at a time. Slower, but memory accesses are cycle accurate.")
+	fmt.Println("  --palette=NAME         Draw the screen in the grey, green or pocket palette, or four colors like")
+	fmt.Println("                         #E0F8D0,#88C070,#346856,#081820 from lightest to darkest. Defaults to grey.")
+	fmt.Println("  --play=file.gmv        Play back a movie recorded with --record. The keyboard takes over when it ends.")
+	fmt.Println("  --record=file.gmv      Record the buttons held on every frame to a movie, starting at power on or from")
+	fmt.Println("                         the state given to --load-state.")
+	fmt.Println("  --rewind=N             Save a state every N frames so holding backspace runs the game backwards and")
+	fmt.Println("                         the debugger can step back, reverse-step and reverse-continue.")
+	fmt.Println("  --rewind-budget=MB     Memory to keep rewind states in. Defaults to 32MB.")
+	fmt.Println("  --scale=N              Draw each pixel N pixels wide and high. Defaults to 1.")
+	fmt.Println("  --speed=X|unlimited    Run at X times the hardware's speed, from 0.25 up. Hold tab to fast forward.")
+	fmt.Println("                         Defaults to 1, or unlimited with --headless.")
+	fmt.Println("  --trace=file.log       Write a gameboy-doctor compatible trace of every instruction to the file.")
+	fmt.Println("  --trace-range=FROM-TO  Only trace instructions between the two hex addresses, e.g. 0150-01FF.")
+	fmt.Println("  --trace-bank=N         Only trace instructions in ROM bank N.")
+	fmt.Println("  --trace-labels         End each trace line with the label the instruction is in. Not gameboy-doctor compatible.")
 	fmt.Println("  --help                 Show this help text.")
+	fmt.Println()
+	fmt.Println("disasm options:")
+	fmt.Println("  --bank=N               The ROM bank to disassemble. Defaults to bank 0.")
+	fmt.Println("  --from=ADDR            Hex address to start disassembling from. Defaults to the start of the bank.")
+	fmt.Println("  --to=ADDR              Hex address to stop disassembling at. Defaults to the end of the bank.")
+	fmt.Println("  --sym=file.sym         Symbol file to label the listing with. Defaults to the .sym file next to the rom.")
+}
+
+// loadConfig loads the config file, or the one in the default place if file is empty. It's fine for there not to be
+// one in the default place.
+func loadConfig(file string) (*config.Config, error) {
+	if file != "" {
+		return config.Load(file)
+	}
+
+	file = config.DefaultFile()
+	if _, err := os.Stat(file); file == "" || os.IsNotExist(err) {
+		return config.Default(), nil
+	}
+	return config.Load(file)
+}
+
+// parseRange parses a range of hex addresses in the form FROM-TO.
+func parseRange(addressRange string) (uint16, uint16, error) {
+	parts := strings.SplitN(addressRange, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("%s is not a valid address range", addressRange)
+	}
+
+	from, err := parseAddress(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	to, err := parseAddress(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return from, to, nil
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
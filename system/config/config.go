@@ -0,0 +1,299 @@
+// Package config loads the settings kept in the config file, by default ~/.config/gmboy/config.toml. A config
+// file looks like:
+//
+//	scale = 3
+//	palette = "green"      # grey, green, pocket or ["#E0F8D0", "#88C070", "#346856", "#081820"]
+//	speed = 1              # or "unlimited"
+//	rewind = 0             # save a rewind state every N frames
+//	rewind_budget = 32     # megabytes
+//	turbo_rate = 15        # turbo presses a second, up to 30
+//
+//	[keys]
+//	a = "X"
+//	b = "Z"
+//	start = "Enter"
+//	select = "RightShift"
+//	turbo_a = "S"
+//	turbo_b = "A"
+//
+//	[hotkeys]
+//	next = "N"
+//	continue = "K"
+//	pause = "P"
+//	frame_advance = "M"
+//	reset = "Ctrl+R"
+//	power_cycle = "Ctrl+Shift+R"
+//	fast_forward = "Tab"
+//	rewind = "Backspace"
+//	save_state = "Shift"   # held with 1-9 to save to that slot
+//	load_state = ""        # held with 1-9 to load that slot
+//
+//	[joystick]              # buttons and axes are numbered from 0, -1 is none
+//	a = 1
+//	b = 0
+//	select = 6
+//	start = 7
+//	up = -1                 # also down, left and right, for d-pads that are buttons
+//	x_axis = 0
+//	y_axis = 1
+//	dpad_x_axis = 6
+//	dpad_y_axis = 7
+//	deadzone = 0.25
+//	turbo_a = 3
+//	turbo_b = 2
+//
+//	[macros]                # the buttons held and for how many frames, "none" to wait
+//	spin_jump = "Down 2, Down+Right 2, Right+A 4"
+//
+//	[macro_keys]
+//	spin_jump = "F1"
+//
+// Everything is optional. Anything that isn't set keeps its default.
+package config
+
+import (
+	"fmt"
+	"github.com/robmerrell/gmboy/system/joystick"
+	"github.com/robmerrell/gmboy/system/macro"
+	"github.com/robmerrell/gmboy/system/ui"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the settings.
+type Config struct {
+	// Scale is how many pixels wide and high each pixel of the screen is drawn, and Palette its shades
+	Scale   int
+	Palette ui.Palette
+
+	// Speed is how fast the system runs as a multiple of the hardware's speed, or 0 for as fast as possible
+	Speed float64
+
+	// Rewind is how many frames apart rewind states are saved, or 0 to not save them, and RewindBudget is how much
+	// memory they're kept in, in megabytes
+	Rewind       int
+	RewindBudget int
+
+	// TurboRate is how many times a second the turbo buttons press A and B
+	TurboRate int
+
+	// Bindings are the keys bound to the buttons and hotkeys
+	Bindings ui.Bindings
+
+	// Macros are the macros that can be played by name
+	Macros map[string]macro.Macro
+}
+
+// Default returns the settings used when there's no config file.
+func Default() *Config {
+	palette, _ := ui.ParsePalette(ui.DefaultPalette)
+	return &Config{
+		Scale: 1, Palette: palette, Speed: 1, RewindBudget: 32, TurboRate: 15,
+		Bindings: ui.DefaultBindings(), Macros: make(map[string]macro.Macro),
+	}
+}
+
+// DefaultFile returns where the config file is kept, or an empty string if there's no config directory.
+func DefaultFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gmboy", "config.toml")
+}
+
+// Load loads a config file.
+func Load(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return c, nil
+}
+
+// Parse reads a config file from r. Settings that aren't in it keep their defaults.
+func Parse(r io.Reader) (*Config, error) {
+	tables, err := parseTOML(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	for name, values := range tables {
+		var set func(key string, value interface{}) error
+		switch name {
+		case "":
+			set = c.set
+		case "keys":
+			set = func(key string, value interface{}) error {
+				return bindString(key, value, c.Bindings.BindButton)
+			}
+		case "hotkeys":
+			set = func(key string, value interface{}) error {
+				return bindString(key, value, c.Bindings.BindHotkey)
+			}
+		case "joystick":
+			set = c.setJoystick
+		case "macros":
+			set = c.setMacro
+		case "macro_keys":
+			set = func(key string, value interface{}) error {
+				return bindString(key, value, c.Bindings.BindMacro)
+			}
+		default:
+			return nil, fmt.Errorf("[%s] isn't a section of the config", name)
+		}
+
+		for key, value := range values {
+			if err := set(key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name := range c.Bindings.Macros {
+		if _, ok := c.Macros[name]; !ok {
+			return nil, fmt.Errorf("there's a key for the macro %s, but no macro", name)
+		}
+	}
+	return c, nil
+}
+
+// set changes one of the top level settings.
+func (c *Config) set(key string, value interface{}) error {
+	var err error
+	switch key {
+	case "scale":
+		if c.Scale, err = positiveInt(key, value); err == nil && c.Scale == 0 {
+			err = fmt.Errorf("scale should be at least 1")
+		}
+	case "rewind":
+		c.Rewind, err = positiveInt(key, value)
+	case "rewind_budget":
+		c.RewindBudget, err = positiveInt(key, value)
+	case "turbo_rate":
+		if c.TurboRate, err = positiveInt(key, value); err == nil && (c.TurboRate < 1 || c.TurboRate > 30) {
+			err = fmt.Errorf("turbo_rate should be from 1 up to 30 presses a second")
+		}
+	case "speed":
+		switch v := value.(type) {
+		case int64:
+			c.Speed = float64(v)
+		case float64:
+			c.Speed = v
+		case string:
+			c.Speed, err = ParseSpeed(v)
+		default:
+			err = fmt.Errorf("speed should be a number or \"unlimited\"")
+		}
+	case "palette":
+		switch v := value.(type) {
+		case string:
+			c.Palette, err = ui.ParsePalette(v)
+		case []interface{}:
+			colors := make([]string, len(v))
+			for i, color := range v {
+				colors[i] = fmt.Sprint(color)
+			}
+			c.Palette, err = ui.ParsePalette(strings.Join(colors, ","))
+		default:
+			err = fmt.Errorf("palette should be a name or a list of colors")
+		}
+	default:
+		err = fmt.Errorf("%s isn't a setting", key)
+	}
+	return err
+}
+
+// setJoystick maps a joystick button or axis, by its index, onto a Gameboy button, or sets the deadzone.
+func (c *Config) setJoystick(key string, value interface{}) error {
+	m := &c.Bindings.Joystick
+	if key == "deadzone" {
+		var deadzone float64
+		switch v := value.(type) {
+		case float64:
+			deadzone = v
+		case int64:
+			deadzone = float64(v)
+		default:
+			return fmt.Errorf("the joystick deadzone should be a number")
+		}
+		if deadzone < 0 || deadzone >= 1 {
+			return fmt.Errorf("the joystick deadzone should be from 0 up to 1")
+		}
+		m.Deadzone = float32(deadzone)
+		return nil
+	}
+
+	indexes := map[string]*int{
+		"a": &m.A, "b": &m.B, "select": &m.Select, "start": &m.Start,
+		"up": &m.Up, "down": &m.Down, "left": &m.Left, "right": &m.Right, "turbo_a": &m.TurboA, "turbo_b": &m.TurboB,
+		"x_axis": &m.XAxis, "y_axis": &m.YAxis, "dpad_x_axis": &m.DPadXAxis, "dpad_y_axis": &m.DPadYAxis,
+	}
+	index, ok := indexes[key]
+	if !ok {
+		return fmt.Errorf("%s isn't a joystick setting", key)
+	}
+
+	n, ok := value.(int64)
+	if !ok || n < joystick.None {
+		return fmt.Errorf("joystick %s should be a button or axis number, or -1 for none", key)
+	}
+	*index = int(n)
+	return nil
+}
+
+// setMacro adds a macro, written as steps like "Down 2, Down+Right 2, Right+A 4".
+func (c *Config) setMacro(name string, value interface{}) error {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("the macro %s should be a string", name)
+	}
+
+	m, err := macro.Parse(text)
+	if err != nil {
+		return fmt.Errorf("macro %s: %v", name, err)
+	}
+	c.Macros[name] = m
+	return nil
+}
+
+// ParseSpeed parses a speed, like "2", "0.5x" or "unlimited", which is returned as 0.
+func ParseSpeed(speed string) (float64, error) {
+	if speed == "unlimited" {
+		return 0, nil
+	}
+
+	multiplier, err := strconv.ParseFloat(strings.TrimSuffix(speed, "x"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid speed", speed)
+	}
+	return multiplier, nil
+}
+
+// positiveInt returns a setting that has to be a whole number of at least 0.
+func positiveInt(key string, value interface{}) (int, error) {
+	n, ok := value.(int64)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%s should be a whole number", key)
+	}
+	return int(n), nil
+}
+
+// bindString binds a key set to a string, like a = "X".
+func bindString(key string, value interface{}, bind func(name, keys string) error) error {
+	keys, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s should be a key name in quotes", key)
+	}
+	return bind(key, keys)
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/robmerrell/gmboy/system/ui"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(`
+# settings
+scale = 3
+palette = ["#E0F8D0", "#88C070", "#346856", "#081820"] # from lightest to darkest
+speed = "unlimited"
+turbo_rate = 10
+
+[keys]
+a = "J"
+start = 'Space'
+turbo_a = "Q"
+
+[hotkeys]
+reset = "Ctrl+Shift+F5"
+save_state = "Alt"
+load_state = ""
+
+[joystick]
+a = 2
+up = 11
+dpad_x_axis = -1
+deadzone = 0.5
+
+[macros]
+jump = "A 4, none 2"
+
+[macro_keys]
+jump = "Ctrl+J"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Scale != 3 || c.Speed != 0 || c.RewindBudget != 32 || c.TurboRate != 10 {
+		t.Errorf("Expected the settings in the file to replace the defaults, but was %+v", c)
+	}
+	if c.Palette[1] != (ui.Color{R: 0x88, G: 0xC0, B: 0x70}) {
+		t.Errorf("Expected the second shade to be #88C070, but was %v", c.Palette[1])
+	}
+
+	b := c.Bindings
+	if b.A != glfw.KeyJ || b.Start != glfw.KeySpace || b.B != glfw.KeyZ || b.TurboA != glfw.KeyQ {
+		t.Errorf("Expected A and start to be rebound and B to keep its key, but was %+v", b)
+	}
+	if b.Reset != (ui.Hotkey{Key: glfw.KeyF5, Mods: glfw.ModControl | glfw.ModShift}) {
+		t.Errorf("Expected reset to be bound to ctrl+shift+F5, but was %+v", b.Reset)
+	}
+	if b.SaveState != glfw.ModAlt || b.LoadState != 0 {
+		t.Errorf("Expected saving to need alt and loading nothing, but was %v and %v", b.SaveState, b.LoadState)
+	}
+
+	j := b.Joystick
+	if j.A != 2 || j.B != 0 || j.Up != 11 || j.DPadXAxis != -1 || j.Deadzone != 0.5 {
+		t.Errorf("Expected the joystick mapping to be changed, but was %+v", j)
+	}
+
+	if m := c.Macros["jump"]; m.Frames() != 6 {
+		t.Errorf("Expected the jump macro to last 6 frames, but was %v", m)
+	}
+	if b.Macros["jump"] != (ui.Hotkey{Key: glfw.KeyJ, Mods: glfw.ModControl}) {
+		t.Errorf("Expected the jump macro to be bound to ctrl+J, but was %+v", b.Macros["jump"])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"scale = ",
+		"scale = 0",
+		"scale = \"big\"",
+		"sclae = 2",
+		"palette = \"purple\"",
+		"[keys]\na = \"Nope\"",
+		"[keys]\njump = \"Space\"",
+		"[hotkeys]\nreset = \"Hyper+R\"",
+		"[display]",
+		"[keys]\n[keys]",
+		"speed = 1\nspeed = 2",
+		"palette = [\"#FFFFFF\"",
+		"[joystick]\na = -2",
+		"[joystick]\nturbo = 1",
+		"[joystick]\ndeadzone = 1.5",
+		"turbo_rate = 31",
+		"[macros]\njump = \"A\"",
+		"[macro_keys]\njump = \"F1\"",
+	}
+
+	for _, test := range tests {
+		if _, err := Parse(strings.NewReader(test)); err == nil {
+			t.Errorf("Expected %q to fail to parse", test)
+		}
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected interface{}
+	}{
+		{`"a # b"`, "a # b"},
+		{`'C:\gmboy'`, `C:\gmboy`},
+		{"42", int64(42)},
+		{"010", nil},
+		{"-1_000", int64(-1000)},
+		{"+0", int64(0)},
+		{"0x1F", int64(0x1F)},
+		{"0o17", int64(15)},
+		{"0b101", int64(5)},
+		{"1__0", nil},
+		{"0x_1", nil},
+		{"-0x10", nil},
+		{"0.5", 0.5},
+		{"true", true},
+	}
+
+	for _, test := range tests {
+		value, err := parseValue(test.text)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("Expected %s to fail to parse but was %v", test.text, value)
+			}
+			continue
+		}
+		if err != nil || value != test.expected {
+			t.Errorf("Expected %s to be %v but was %v (%v)", test.text, test.expected, value, err)
+		}
+	}
+
+	if line := stripComment(`a = "# not a comment" # a comment`); line != `a = "# not a comment" ` {
+		t.Errorf("Expected only the comment to be stripped, but was %q", line)
+	}
+}
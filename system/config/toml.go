@@ -0,0 +1,218 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// table is a TOML table: the values set in it by key.
+type table map[string]interface{}
+
+// parseTOML parses the subset of TOML the config file needs: comments, [tables] and key = value pairs, where a value
+// is a string, an integer, a float, a boolean or an array of them on one line. Keys before the first table are in
+// the table named "".
+func parseTOML(r io.Reader) (map[string]table, error) {
+	tables := map[string]table{"": {}}
+	current := tables[""]
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: expected ] at the end of the table name", line)
+			}
+
+			name := strings.TrimSpace(text[1 : len(text)-1])
+			if !isBareKey(name) {
+				return nil, fmt.Errorf("line %d: %q isn't a valid table name", line, name)
+			}
+			if _, exists := tables[name]; exists {
+				return nil, fmt.Errorf("line %d: table %s is defined twice", line, name)
+			}
+			current = table{}
+			tables[name] = current
+			continue
+		}
+
+		eq := strings.Index(text, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+
+		key := strings.TrimSpace(text[:eq])
+		if !isBareKey(key) {
+			return nil, fmt.Errorf("line %d: %q isn't a valid key", line, key)
+		}
+		if _, exists := current[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set twice", line, key)
+		}
+
+		value, err := parseValue(strings.TrimSpace(text[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		current[key] = value
+	}
+
+	return tables, scanner.Err()
+}
+
+// stripComment removes a comment from the end of a line, leaving # inside strings alone.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == 0 && c == '#':
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
+
+// isBareKey returns true if key is made up of letters, digits, underscores and dashes.
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue parses a value: "basic" and 'literal' strings, integers, floats, true, false and arrays.
+func parseValue(text string) (interface{}, error) {
+	switch {
+	case text == "":
+		return nil, fmt.Errorf("expected a value")
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case text[0] == '"':
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't a valid string", text)
+		}
+		return value, nil
+	case text[0] == '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' || strings.Contains(text[1:len(text)-1], "'") {
+			return nil, fmt.Errorf("%s isn't a valid string", text)
+		}
+		return text[1 : len(text)-1], nil
+	case text[0] == '[':
+		return parseArray(text)
+	}
+
+	if isInteger(text) {
+		return parseInteger(text)
+	}
+	if value, err := strconv.ParseFloat(strings.Replace(text, "_", "", -1), 64); err == nil {
+		return value, nil
+	}
+	return nil, fmt.Errorf("%s isn't a valid value", text)
+}
+
+// isInteger returns true if text is written as an integer rather than a float: digits with an optional sign, or
+// digits after a 0x, 0o or 0b prefix. Underscores can be mixed in with the digits.
+func isInteger(text string) bool {
+	if integerBase(text) != 10 {
+		return true
+	}
+
+	digits := strings.TrimLeft(text[:1], "+-") + text[1:]
+	return digits != "" && strings.Trim(digits, "0123456789_") == ""
+}
+
+// integerBase returns the base an integer is written in from its prefix.
+func integerBase(text string) int {
+	switch {
+	case strings.HasPrefix(text, "0x"):
+		return 16
+	case strings.HasPrefix(text, "0o"):
+		return 8
+	case strings.HasPrefix(text, "0b"):
+		return 2
+	}
+	return 10
+}
+
+// parseInteger parses an integer. Decimal integers can't have leading zeros, so 010 isn't mistaken for octal,
+// and every underscore has to be between two digits.
+func parseInteger(text string) (int64, error) {
+	base := integerBase(text)
+	sign, digits := "", text
+	if base != 10 {
+		digits = text[2:]
+	} else if text[0] == '+' || text[0] == '-' {
+		sign, digits = text[:1], text[1:]
+	}
+
+	if base == 10 && len(digits) > 1 && digits[0] == '0' {
+		return 0, fmt.Errorf("%s can't have leading zeros", text)
+	}
+	if digits == "" || digits[0] == '_' || digits[len(digits)-1] == '_' || strings.Contains(digits, "__") {
+		return 0, fmt.Errorf("%s isn't a valid integer", text)
+	}
+
+	value, err := strconv.ParseInt(sign+strings.Replace(digits, "_", "", -1), base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s isn't a valid integer", text)
+	}
+	return value, nil
+}
+
+// parseArray parses an array of values on one line, like ["#E0F8D0", "#88C070"].
+func parseArray(text string) ([]interface{}, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("expected ] at the end of the array")
+	}
+
+	var values []interface{}
+	var quote byte
+	start := 1
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == ',' || i == len(text)-1:
+			element := strings.TrimSpace(text[start:i])
+			start = i + 1
+
+			// a trailing comma is allowed
+			if element == "" && (c == ']' || i == len(text)-1) {
+				continue
+			}
+			value, err := parseValue(element)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,155 @@
+package system
+
+import (
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"log"
+	"time"
+)
+
+// Pause stops the system before the next instruction. When the debugger is running it's the one that stops, so it
+// can be stepped from there.
+func (s *System) Pause() {
+	if s.debugger != nil {
+		if err := s.debugger.Pause(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	s.paused = true
+	s.advancing = false
+}
+
+// Resume continues running after Pause or FrameAdvance.
+func (s *System) Resume() {
+	if s.debugger != nil {
+		s.debugger.Continue()
+		return
+	}
+
+	s.paused = false
+	s.advancing = false
+}
+
+// Paused returns true while the system is paused.
+func (s *System) Paused() bool {
+	if s.debugger != nil {
+		return s.debugger.BreakpointActive
+	}
+	return s.paused
+}
+
+// TogglePause pauses the system when it's running and resumes it when it's paused.
+func (s *System) TogglePause() {
+	if s.Paused() {
+		s.Resume()
+	} else {
+		s.Pause()
+	}
+}
+
+// FrameAdvance runs until VBlank next starts and pauses there. While the LCD is off it runs a frame's worth of
+// cycles instead.
+func (s *System) FrameAdvance() {
+	if s.debugger != nil {
+		if err := s.debugger.RunToNextFrame(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	s.paused = false
+	s.advancing = true
+}
+
+// waitPaused handles commands and window events while the system is paused.
+func (s *System) waitPaused() {
+	select {
+	case cmd := <-s.commands:
+		cmd()
+		s.resetIfRequested()
+	case <-time.After(frameDuration):
+	}
+	s.pollEvents()
+}
+
+// Reset presses the reset button: the CPU starts again from the beginning with the rest of the hardware back the
+// way it is at power on, but RAM keeps its contents.
+func (s *System) Reset() {
+	s.reset(false)
+	log.Println("Reset")
+}
+
+// PowerCycle turns the system off and on again, clearing RAM as well. The cycle and frame counts keep going so
+// rewinding carries on across it.
+func (s *System) PowerCycle() {
+	s.reset(true)
+	log.Println("Power cycled")
+}
+
+// reset puts the hardware back to how it is at power on, clearing RAM when powerCycle is set.
+func (s *System) reset(powerCycle bool) {
+	s.cpu.Reset()
+	s.mmu.Reset(powerCycle)
+	s.ppu.Reset()
+	s.timer.Reset()
+	s.joypad.Reset()
+
+	// the input log and any movie being recorded can't lead to the reset, so they start again from it
+	s.restartInputs()
+
+	if s.debugger != nil {
+		s.debugger.RunCallbacks("reset", map[string]interface{}{"powerCycle": powerCycle})
+	}
+}
+
+// requestReset resets the system once the instruction being executed finishes. Scripts reset this way because they
+// can run partway through an instruction, from an event like before_execute, and resetting there would leave the
+// rest of the instruction to run against the reset CPU.
+func (s *System) requestReset(powerCycle bool) {
+	s.resetRequested = true
+	s.powerCycleRequested = s.powerCycleRequested || powerCycle
+}
+
+// resetIfRequested carries out a reset requested by a script. It's called between instructions.
+func (s *System) resetIfRequested() {
+	if !s.resetRequested {
+		return
+	}
+
+	powerCycle := s.powerCycleRequested
+	s.resetRequested, s.powerCycleRequested = false, false
+	if powerCycle {
+		s.PowerCycle()
+	} else {
+		s.Reset()
+	}
+}
+
+// attachControls adds the pause, frame advance and reset controls to the debugger's console and javascript vm.
+func (s *System) attachControls(dbg *debugger.Debugger) {
+	control := func(fn func()) func(otto.FunctionCall) otto.Value {
+		return func(call otto.FunctionCall) otto.Value {
+			fn()
+			return otto.Value{}
+		}
+	}
+
+	// pause(), resume() and frameAdvance() stop and start the system, and reset() and powerCycle() start it over once
+	// the current instruction finishes
+	dbg.AttachFunction("pause", control(s.Pause))
+	dbg.AttachFunction("resume", control(s.Resume))
+	dbg.AttachFunction("frameAdvance", control(s.FrameAdvance))
+	dbg.AttachFunction("reset", control(func() { s.requestReset(false) }))
+	dbg.AttachFunction("powerCycle", control(func() { s.requestReset(true) }))
+
+	dbg.AttachCommand("reset", "", "reset", "reset the system, keeping the contents of RAM", func(args []string) (string, error) {
+		s.Reset()
+		return "", nil
+	})
+	dbg.AttachCommand("power-cycle", "", "power-cycle", "turn the system off and on again", func(args []string) (string, error) {
+		s.PowerCycle()
+		return "", nil
+	})
+}
@@ -0,0 +1,102 @@
+package system
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/ppu"
+	"testing"
+)
+
+func TestFrameAdvance(t *testing.T) {
+	s := newStateSystem()
+	runFrames(t, s, 1)
+	frames := s.ppu.Frames()
+
+	// with the LCD on frame advance stops when VBlank starts
+	s.Pause()
+	s.FrameAdvance()
+	for i := 0; !s.Paused() && i < 100000; i++ {
+		s.step()
+	}
+
+	if !s.Paused() || s.ppu.Frames() != frames+1 || s.ppu.LY() != ppu.VBlankLine {
+		t.Errorf("Expected to pause when VBlank started, but was on line %d after %d frames", s.ppu.LY(), s.ppu.Frames())
+	}
+}
+
+func TestFrameAdvanceWithLCDOff(t *testing.T) {
+	s := newLCDOffSystem()
+
+	s.Pause()
+	s.FrameAdvance()
+	for i := 0; !s.Paused() && i < 100000; i++ {
+		s.step()
+	}
+	if !s.Paused() || frameAt(s.cycles) != 1 {
+		t.Fatalf("Expected to pause at the start of frame 1, but was at frame %d", frameAt(s.cycles))
+	}
+
+	// the debugger advances the same way
+	dbg := debugger.NewDebugger()
+	s.cpu.AttachDebugger(dbg)
+	s.mmu.AttachDebugger(dbg)
+	dbg.AttachClock(s.Cycles, ppu.CyclesPerFrame)
+	s.debugger = dbg
+
+	s.Pause()
+	s.FrameAdvance()
+	<-dbg.Cont
+	dbg.Resume()
+	for i := 0; !dbg.BreakpointActive && i < 100000; i++ {
+		s.step()
+	}
+	if !dbg.BreakpointActive || frameAt(s.cycles) != 2 {
+		t.Errorf("Expected the debugger to stop at the start of frame 2, but was at frame %d", frameAt(s.cycles))
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := newStateSystem()
+	runFrames(t, s, 2)
+	s.mmu.PokeByte(0xD000, 0x42)
+
+	s.Reset()
+	if s.mmu.PeekByte(0xD000) != 0x42 {
+		t.Error("Expected RAM to keep its contents across a reset")
+	}
+	if s.mmu.PeekByte(0xFF40) != 0 {
+		t.Error("Expected a reset to turn the LCD off")
+	}
+
+	s.PowerCycle()
+	if s.mmu.PeekByte(0xD000) != 0 {
+		t.Error("Expected a power cycle to clear RAM")
+	}
+
+	// after a power cycle the program runs the same way it did from power on
+	fresh := newStateSystem()
+	runFrames(t, s, 1)
+	runFrames(t, fresh, 1)
+	for address := 0xC000; address < 0xC100; address++ {
+		if s.mmu.PeekByte(uint16(address)) != fresh.mmu.PeekByte(uint16(address)) {
+			t.Fatalf("Expected %04X to be the same as it is after power on", address)
+		}
+	}
+}
+
+func TestResetFromCallback(t *testing.T) {
+	s := newStateSystem()
+	runFrames(t, s, 1)
+
+	dbg := debugger.NewDebugger()
+	s.cpu.AttachDebugger(dbg)
+	s.debugger = dbg
+	s.attachControls(dbg)
+
+	// resetting before an instruction executes lets it finish first, rather than running the rest of it against the
+	// reset CPU
+	dbg.Execute(`var resets = 0; on('before_execute', function() { if (resets++ == 0) reset(); });`)
+	s.stepCPU()
+	if pc := dbg.Execute("evaluate('PC')"); pc != "0\n" {
+		t.Errorf("Expected the system to be reset to 0000 after the instruction, but PC was %q", pc)
+	}
+}
@@ -0,0 +1,63 @@
+package cpu
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+)
+
+// maxCallStack is the deepest the shadow call stack gets. Code that never returns from its calls would otherwise
+// grow it forever, so the oldest frames are dropped past this.
+const maxCallStack = 256
+
+// pushFrame adds a frame to the shadow call stack. It's called once the return address has been pushed.
+func (c *CPU) pushFrame(kind string, caller, target, returnAddress uint16) {
+	if len(c.callStack) == maxCallStack {
+		c.callStack = append(c.callStack[:0], c.callStack[1:]...)
+	}
+
+	c.callStack = append(c.callStack, debugger.Frame{
+		Kind:          kind,
+		Caller:        caller,
+		CallerBank:    c.mmu.Bank(caller),
+		Target:        target,
+		TargetBank:    c.mmu.Bank(target),
+		ReturnAddress: returnAddress,
+		StackPointer:  c.stackPointer,
+	})
+}
+
+// popFrame removes the frame being returned from. It's called before the return address is popped.
+//
+// Not every RET is a return from a call: pushing an address and returning is a common way to jump through a table.
+// So a frame is only popped when its return address is the one on top of the stack, both where it was pushed and
+// what was pushed, since code can swap another address in for the return address without moving the stack pointer.
+func (c *CPU) popFrame() {
+	c.unwindCallStack()
+
+	n := len(c.callStack)
+	if n == 0 || c.callStack[n-1].StackPointer != c.stackPointer {
+		return
+	}
+
+	top := uint16(c.mmu.PeekByte(c.stackPointer+1))<<8 | uint16(c.mmu.PeekByte(c.stackPointer))
+	if top == c.callStack[n-1].ReturnAddress {
+		c.callStack = c.callStack[:n-1]
+	}
+}
+
+// unwindCallStack drops frames whose return addresses are no longer on the stack, which happens when code pops
+// a return address itself or moves the stack pointer somewhere else.
+func (c *CPU) unwindCallStack() {
+	for n := len(c.callStack); n > 0 && c.callStack[n-1].StackPointer < c.stackPointer; n-- {
+		c.callStack = c.callStack[:n-1]
+	}
+}
+
+// CallDepth returns the number of calls that haven't returned yet.
+func (c *CPU) CallDepth() int {
+	return len(c.callStack)
+}
+
+// CallStack returns the shadow call stack, outermost call first.
+func (c *CPU) CallStack() []debugger.Frame {
+	return append([]debugger.Frame(nil), c.callStack...)
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/binary"
 	"github.com/robertkrimen/otto"
 	"github.com/robmerrell/gmboy/system/debugger"
-	"github.com/robmerrell/gmboy/system/mmu"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
 	"log"
 	"strings"
 )
@@ -61,6 +61,11 @@ func (r *registers) setFlag(flag byte) {
 	r.AF.high |= flag
 }
 
+// flagSet returns true if the given flag is set on the Flag register (F)
+func (r *registers) flagSet(flag byte) bool {
+	return r.AF.high&flag != 0
+}
+
 // flagToString returns the flags in an easy to read format where the flags occupy one of
 // four spaces in the string: ZNHC. If a flag is set, it's letter will be present, if not
 // it will be zero.
@@ -86,6 +91,28 @@ func (r *registers) flagToString() string {
 	return strings.Join(flagStates, "")
 }
 
+// Bus is the memory the CPU reads from and writes to. In the emulator this is the MMU, but anything that can read
+// and write bytes will do. This lets tests run instructions against a plain block of RAM.
+type Bus interface {
+	ReadByte(location uint16) byte
+	ReadWord(location uint16) uint16
+	WriteBytes(content []byte, location uint16)
+
+	// FetchByte reads a byte of the instruction being executed. It's a read like any other, but it doesn't
+	// trigger read watchpoints, which are for the data a program reads.
+	FetchByte(location uint16) byte
+
+	// PeekByte reads a byte without side effects like triggering watchpoints. It's used for reads
+	// that aren't part of executing an instruction, like tracing.
+	PeekByte(location uint16) byte
+
+	// Bank returns the ROM bank mapped in at the given location.
+	Bank(location uint16) int
+
+	// AcknowledgeInterrupt clears an interrupt's bit in IF as the interrupt is serviced.
+	AcknowledgeInterrupt(interrupt byte)
+}
+
 // CPU holds the current state of the CPU
 type CPU struct {
 	// registers are all of the working CPU registers
@@ -98,15 +125,36 @@ type CPU struct {
 	programCounter uint16
 
 	// reference to the MMU
-	mmu *mmu.MMU
+	mmu Bus
 
 	// debugger
 	debugger       *debugger.Debugger
 	debuggerActive bool
+
+	// tracer, when set, logs the CPU state before every instruction
+	tracer *Tracer
+
+	// branchTaken is set by conditional instructions when their condition is met, which makes the
+	// instruction take its longer cycle count.
+	branchTaken bool
+
+	// cycleHook, when set, switches the CPU to M-cycle stepping: it is called with 4 cycles for every memory
+	// access and internal delay as it happens, instead of the caller ticking the rest of the system once the
+	// whole instruction has executed. tickedCycles counts the cycles reported during the current instruction.
+	cycleHook    func(cycles int)
+	tickedCycles int
+
+	// callStack is a shadow call stack of the calls, RSTs and interrupts that haven't returned yet, so the
+	// debugger can show where execution came from and step over and out of calls
+	callStack []debugger.Frame
+
+	// ime is the interrupt master enable flag. imeDelay counts down the instructions until an EI takes effect.
+	ime      bool
+	imeDelay int
 }
 
 // NewCPU returns a new CPU instance
-func NewCPU(memoryManager *mmu.MMU) *CPU {
+func NewCPU(memoryManager Bus) *CPU {
 	return &CPU{registers: &registers{}, mmu: memoryManager}
 }
 
@@ -115,6 +163,8 @@ func (c *CPU) AttachDebugger(dbg *debugger.Debugger) {
 	log.Println("Attaching debugger to CPU")
 	c.debugger = dbg
 	c.debuggerActive = true
+	c.debugger.AttachEnv(exprEnv{c})
+	c.debugger.AttachCallStack(c)
 
 	// create the cpuState() function for the js debugger that returns the current state of the CPU
 	c.debugger.AttachFunction("cpuState", func(call otto.FunctionCall) otto.Value {
@@ -142,6 +192,116 @@ func (c *CPU) AttachDebugger(dbg *debugger.Debugger) {
 		val, _ := call.Otto.ToValue(registers)
 		return val
 	})
+
+	// create the callStack() function for the js debugger that returns the shadow call stack, outermost call first
+	c.debugger.AttachFunction("callStack", func(call otto.FunctionCall) otto.Value {
+		frames := make([]map[string]interface{}, len(c.callStack))
+		for i, frame := range c.callStack {
+			frames[i] = map[string]interface{}{
+				"kind":          frame.Kind,
+				"caller":        frame.Caller,
+				"callerBank":    frame.CallerBank,
+				"target":        frame.Target,
+				"targetBank":    frame.TargetBank,
+				"returnAddress": frame.ReturnAddress,
+				"stackPointer":  frame.StackPointer,
+			}
+		}
+
+		val, _ := call.Otto.ToValue(frames)
+		return val
+	})
+}
+
+// exprEnv lets breakpoint conditions read the CPU registers and memory. Memory is peeked so evaluating a condition
+// never trips a watchpoint.
+type exprEnv struct {
+	c *CPU
+}
+
+func (e exprEnv) Register(reg expr.Register) int {
+	r := e.c.registers
+	switch reg {
+	case expr.RegA:
+		return int(r.AF.low)
+	case expr.RegF:
+		return int(r.AF.high)
+	case expr.RegB:
+		return int(r.BC.low)
+	case expr.RegC:
+		return int(r.BC.high)
+	case expr.RegD:
+		return int(r.DE.low)
+	case expr.RegE:
+		return int(r.DE.high)
+	case expr.RegH:
+		return int(r.HL.low)
+	case expr.RegL:
+		return int(r.HL.high)
+	case expr.RegAF:
+		return int(r.AF.word())
+	case expr.RegBC:
+		return int(r.BC.word())
+	case expr.RegDE:
+		return int(r.DE.word())
+	case expr.RegHL:
+		return int(r.HL.word())
+	case expr.RegSP:
+		return int(e.c.stackPointer)
+	}
+	return int(e.c.programCounter)
+}
+
+func (e exprEnv) ReadByte(address uint16) byte {
+	return e.c.mmu.PeekByte(address)
+}
+
+// SetRegister lets the debugger change a register. The low 4 bits of F are always 0 on hardware, so they're kept
+// clear.
+func (e exprEnv) SetRegister(reg expr.Register, value int) {
+	r := e.c.registers
+	switch reg {
+	case expr.RegA:
+		r.AF.low = byte(value)
+	case expr.RegF:
+		r.AF.high = byte(value) & 0xF0
+	case expr.RegB:
+		r.BC.low = byte(value)
+	case expr.RegC:
+		r.BC.high = byte(value)
+	case expr.RegD:
+		r.DE.low = byte(value)
+	case expr.RegE:
+		r.DE.high = byte(value)
+	case expr.RegH:
+		r.HL.low = byte(value)
+	case expr.RegL:
+		r.HL.high = byte(value)
+	case expr.RegAF:
+		r.AF.setWord(uint16(value) & 0xFFF0)
+	case expr.RegBC:
+		r.BC.setWord(uint16(value))
+	case expr.RegDE:
+		r.DE.setWord(uint16(value))
+	case expr.RegHL:
+		r.HL.setWord(uint16(value))
+	case expr.RegSP:
+		e.c.stackPointer = uint16(value)
+	case expr.RegPC:
+		e.c.programCounter = uint16(value)
+	}
+}
+
+// SetCycleHook switches the CPU to M-cycle stepping, where hook is called as each M-cycle of an instruction
+// happens. Every memory access and internal delay takes one M-cycle, so the rest of the system sees reads and writes
+// on the same cycle they happen on hardware. Passing nil switches back to stepping a whole instruction at a time.
+func (c *CPU) SetCycleHook(hook func(cycles int)) {
+	c.cycleHook = hook
+}
+
+// AttachTracer starts logging the CPU state before every instruction to the tracer.
+func (c *CPU) AttachTracer(t *Tracer) {
+	c.tracer = t
 }
 
 // InitWithBoot initializes the CPU assuming we are executing the bootrom. The only absolute known of
@@ -151,17 +311,42 @@ func (c *CPU) InitWithBoot() {
 	c.programCounter = 0x0000
 }
 
-// Step processes an instruction
-func (c *CPU) Step() {
+// Reset puts the registers back to how they are at power on and clears the call stack.
+func (c *CPU) Reset() {
+	c.registers = &registers{}
+	c.stackPointer = 0
+	c.programCounter = 0
+	c.ime = false
+	c.imeDelay = 0
+	c.callStack = nil
+}
+
+// Step processes an instruction, or services an interrupt, and returns the number of cycles it took
+func (c *CPU) Step() int {
+	c.tickedCycles = 0
+	if c.serviceInterrupt() {
+		return interruptCycles
+	}
+
+	if c.debuggerActive && c.debugger.CheckBreakpoint(c.programCounter, c.mmu.Bank(c.programCounter)) {
+		return 0
+	}
+
+	if c.tracer != nil {
+		c.tracer.trace(c)
+	}
+
 	// get the instruction of the opcode
-	opcode := c.mmu.ReadByte(c.programCounter)
+	opcode := c.fetchByte(c.programCounter)
+	fetchCycles := 4
 
 	var inst *instruction
 	var exists bool
 	if opcode == 0xCB { // extended instruction
 		// since the extended opcode follows the 0xCB opcode increment the programCounter and read again
 		c.programCounter++
-		opcode = c.mmu.ReadByte(c.programCounter)
+		opcode = c.fetchByte(c.programCounter)
+		fetchCycles += 4
 
 		inst, exists = extendedInstructions[opcode]
 	} else {
@@ -172,7 +357,9 @@ func (c *CPU) Step() {
 		if c.debuggerActive {
 			c.debugger.RunCallbacks("unimplemented_opcode", opcode)
 		}
-		return
+
+		// fetching the opcode still took time, the same whether M-cycle stepping or not
+		return fetchCycles
 	}
 
 	if c.debuggerActive {
@@ -180,26 +367,91 @@ func (c *CPU) Step() {
 	}
 
 	// execute the instruction
+	c.branchTaken = false
 	inst.fn(c)
 
+	cycles := inst.cycles
+	if c.branchTaken {
+		cycles = inst.takenCycles
+	}
+
+	// when M-cycle stepping, make up any cycles that weren't accounted for by memory accesses and internal delays
+	if c.cycleHook != nil && c.tickedCycles < cycles {
+		c.cycleHook(cycles - c.tickedCycles)
+	}
+
 	// advance the program counter
 	if !inst.changesProgramCounter {
 		c.programCounter += inst.len
 	}
 
+	c.updateIME()
+
 	if c.debuggerActive {
 		c.debugger.RunCallbacks("after_execute", inst.Debug())
 	}
+
+	return cycles
 }
 
 // operandByte reads and returns the current instructions operand as a byte
 func (c *CPU) operandByte() byte {
-	return c.mmu.ReadByte(c.programCounter + 1)
+	return c.fetchByte(c.programCounter + 1)
 }
 
 // operandWord reads and returns the current instructions operands as a word
 func (c *CPU) operandWord() uint16 {
-	return c.mmu.ReadWord(c.programCounter + 1)
+	low := c.fetchByte(c.programCounter + 1)
+	high := c.fetchByte(c.programCounter + 2)
+	return uint16(high)<<8 | uint16(low)
+}
+
+// fetchByte reads a byte of the instruction being executed, which takes an M-cycle like any other read.
+func (c *CPU) fetchByte(location uint16) byte {
+	value := c.mmu.FetchByte(location)
+	c.mcycle()
+	return value
+}
+
+// readByte reads a byte from memory. All of the CPU's memory accesses go through fetchByte, readByte, readWord
+// and writeByte so each one can take an M-cycle when M-cycle stepping.
+func (c *CPU) readByte(location uint16) byte {
+	value := c.mmu.ReadByte(location)
+	c.mcycle()
+	return value
+}
+
+// readWord reads a word from memory as two byte reads, low byte first.
+func (c *CPU) readWord(location uint16) uint16 {
+	low := c.readByte(location)
+	high := c.readByte(location + 1)
+	return uint16(high)<<8 | uint16(low)
+}
+
+// writeByte writes a byte to memory.
+func (c *CPU) writeByte(location uint16, value byte) {
+	c.mmu.WriteBytes([]byte{value}, location)
+	c.mcycle()
+}
+
+// internalDelay is an M-cycle where the CPU is busy but doesn't touch memory, like when adding to a
+// 16-bit register or deciding whether to branch.
+func (c *CPU) internalDelay() {
+	c.mcycle()
+}
+
+// mcycle lets the rest of the system run for an M-cycle when M-cycle stepping.
+func (c *CPU) mcycle() {
+	if c.cycleHook != nil {
+		c.tickedCycles += 4
+		c.cycleHook(4)
+	}
+}
+
+// incrementRegisterPair increments the value in a register pair by 1. No flags are affected.
+func (c *CPU) incrementRegisterPair(pair *register) {
+	pair.setWord(pair.word() + 1)
+	c.internalDelay()
 }
 
 // incrementRegister increments the value in the register by 1 and sets flags
@@ -262,7 +514,7 @@ func (c *CPU) xorRegisters(sourceRegister *byte, operandRegister byte) {
 // ldIntoRegisterPairAddress loads the value of the valueRegister into the memory address pointed at by the locationRegister
 func (c *CPU) ldIntoRegisterPairAddress(locationRegister *register, valueRegister byte) {
 	address := locationRegister.word()
-	c.mmu.WriteBytes([]byte{valueRegister}, address)
+	c.writeByte(address, valueRegister)
 }
 
 // ldIntoRegisterPairAddressAndInc loads the value of the valueRegister into the memory address pointed at by the locationRegister
@@ -300,23 +552,73 @@ func (c *CPU) jumpOnCondition(offset byte, condition bool) {
 	if condition {
 		signedOffset := int8(offset)
 		c.programCounter += uint16(signedOffset)
+		c.branchTaken = true
+		c.internalDelay()
+	}
+}
+
+// jumpToOnCondition will jump to an absolute address if the condition is true and continue if not
+func (c *CPU) jumpToOnCondition(address uint16, condition bool) {
+	if condition {
+		c.programCounter = address
+		c.branchTaken = true
+		c.internalDelay()
+	} else {
+		c.programCounter += 3
+	}
+}
+
+// callOnCondition will call an address if the condition is true and continue if not
+func (c *CPU) callOnCondition(address uint16, condition bool) {
+	if condition {
+		c.call(address)
+		c.branchTaken = true
+	} else {
+		c.programCounter += 3
+	}
+}
+
+// retOnCondition will return if the condition is true and continue if not
+func (c *CPU) retOnCondition(condition bool) {
+	// checking the condition takes an M-cycle of its own
+	c.internalDelay()
+
+	if condition {
+		c.ret()
+		c.branchTaken = true
+	} else {
+		c.programCounter++
 	}
 }
 
 // call stores the next opcode's position as the return point and jumps an offset.
 func (c *CPU) call(offset uint16) {
 	c.pushWordOntoStack(c.programCounter + 3)
+	c.pushFrame(debugger.FrameCall, c.programCounter, offset, c.programCounter+3)
 	c.programCounter = offset
 }
 
+// rst calls one of the fixed restart addresses.
+func (c *CPU) rst(vector uint16) {
+	c.pushWordOntoStack(c.programCounter + 1)
+	c.pushFrame(debugger.FrameRST, c.programCounter, vector, c.programCounter+1)
+	c.programCounter = vector
+}
+
 // ret pops a word from the stack and jumps to that address
 func (c *CPU) ret() {
-	c.programCounter = c.mmu.ReadWord(c.stackPointer)
+	c.popFrame()
+
+	c.programCounter = c.readWord(c.stackPointer)
 	c.stackPointer += 2
+	c.internalDelay()
 }
 
 // pushWordOntoStack pushes a word onto the stack
 func (c *CPU) pushWordOntoStack(word uint16) {
+	// the stack pointer is decremented before the first write
+	c.internalDelay()
+
 	parts := make([]byte, 2)
 	binary.LittleEndian.PutUint16(parts, word)
 
@@ -327,13 +629,14 @@ func (c *CPU) pushWordOntoStack(word uint16) {
 // pushByteOntoStack decrements the stack pointer and pushes a byte onto the stack.
 func (c *CPU) pushByteOntoStack(value byte) {
 	c.stackPointer--
-	c.mmu.WriteBytes([]byte{value}, c.stackPointer)
+	c.writeByte(c.stackPointer, value)
 }
 
 // popStackIntoRegisterPair pops the word in the stack currently pointed at and places it in a register pair.
 func (c *CPU) popStackIntoRegisterPair(pair *register) {
-	pair.setWord(c.mmu.ReadWord(c.stackPointer))
+	pair.setWord(c.readWord(c.stackPointer))
 	c.stackPointer += 2
+	c.unwindCallStack()
 }
 
 // rotateRegisterLeft rotates a register left through the carry flag. Bit #7 is stored in carry flag, carry flag is moved to bit #0
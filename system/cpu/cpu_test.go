@@ -1,10 +1,36 @@
 package cpu
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/mmu"
 	"github.com/robmerrell/gmboy/testhelpers"
+	"strings"
 	"testing"
 )
 
+func mockCPU() *CPU {
+	m := mmu.NewMMU()
+	c := NewCPU(m)
+	c.programCounter = 0x00
+
+	return c
+}
+
+// This is much easier and more human readable than checking the bits for our tests like I was doing before.
+func assertFlagState(t *testing.T, expectedFlagString string, actualFlagString string) {
+	if actualFlagString != expectedFlagString {
+		t.Errorf("Expected the flag state to be %s, but is was %s", expectedFlagString, actualFlagString)
+	}
+}
+
+func assertCycles(t *testing.T, expected, actual int) {
+	if expected != actual {
+		t.Errorf("Expected the instruction to take %d cycles, but it took %d", expected, actual)
+	}
+}
+
 func TestRegisterWord(t *testing.T) {
 	r := &register{0x32, 0x11}
 	testhelpers.AssertWord(t, 0x3211, r.word())
@@ -192,3 +218,308 @@ func TestRotateRegisterLeft(t *testing.T) {
 	c.rotateRegisterLeft(&c.registers.BC.high)
 	assertFlagState(t, "Z---", c.registers.flagToString())
 }
+
+func TestStepStopsAtBreakpoint(t *testing.T) {
+	c := mockCPU()
+	dbg := debugger.NewDebugger()
+	c.AttachDebugger(dbg)
+	dbg.AddBreakpoint(0x0001, -1)
+	c.mmu.WriteBytes([]byte{0x00, 0x3E, 0x04}, 0)
+
+	c.Step()
+	c.Step()
+	testhelpers.AssertWord(t, 0x0001, c.programCounter)
+	if !dbg.BreakpointActive {
+		t.Error("Expected execution to be stopped at the breakpoint")
+	}
+
+	// stepping from the breakpoint executes the instruction
+	c.Step()
+	testhelpers.AssertByte(t, 0x04, c.registers.AF.low)
+}
+
+func TestFetchesDontTriggerReadWatchpoints(t *testing.T) {
+	m := mmu.NewMMU()
+	c := NewCPU(m)
+	dbg := debugger.NewDebugger()
+	c.AttachDebugger(dbg)
+	m.AttachDebugger(dbg)
+	m.WriteBytes([]byte{0x3E, 0x04, 0x11, 0x00, 0x00, 0x1A}, 0) // LD A,$04; LD DE,$0000; LD A,(DE)
+	dbg.AddWatchpoint(0x0000, debugger.WatchRead)
+	dbg.AddWatchpoint(0x0001, debugger.WatchRead)
+
+	c.Step()
+	c.Step()
+	if dbg.BreakpointActive {
+		t.Error("Expected executing the watched bytes not to hit the watchpoints")
+	}
+
+	// reading the same byte as data does
+	c.Step()
+	if !dbg.BreakpointActive {
+		t.Error("Expected reading the watched byte to hit the watchpoint")
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.mmu.WriteBytes([]byte{0xCD, 0x00, 0x02}, 0x0100)
+	c.mmu.WriteBytes([]byte{0xC9}, 0x0200)
+	c.programCounter = 0x0100
+
+	c.Step()
+	if c.CallDepth() != 1 {
+		t.Errorf("Expected the call depth to be 1 after a call but was %d", c.CallDepth())
+	}
+
+	c.Step()
+	if c.CallDepth() != 0 {
+		t.Errorf("Expected the call depth to be 0 after returning but was %d", c.CallDepth())
+	}
+}
+
+func TestCallStackIgnoresReturnUsedAsJump(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0xCD, 0x00, 0x02}, 0x0100)
+	c.mmu.WriteBytes([]byte{0xC5, 0xC9}, 0x0200) // PUSH BC, RET
+	c.registers.BC.setWord(0x0300)
+
+	c.Step()
+	c.Step()
+	c.Step()
+	testhelpers.AssertWord(t, 0x0300, c.programCounter)
+	if c.CallDepth() != 1 {
+		t.Errorf("Expected returning to a pushed address to leave the call on the stack, but the depth was %d", c.CallDepth())
+	}
+}
+
+func TestCallStackUnwindsPoppedReturnAddress(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0xCD, 0x00, 0x02}, 0x0100)
+	c.mmu.WriteBytes([]byte{0xC1}, 0x0200) // POP BC
+
+	c.Step()
+	c.Step()
+	if c.CallDepth() != 0 {
+		t.Errorf("Expected popping the return address to drop the call, but the depth was %d", c.CallDepth())
+	}
+}
+
+func TestCallStackIgnoresReplacedReturnAddress(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0xCD, 0x00, 0x02}, 0x0100)
+	c.mmu.WriteBytes([]byte{0xC9}, 0x0200)
+
+	c.Step()
+	c.mmu.WriteBytes([]byte{0x00, 0x03}, c.stackPointer) // the called code writes another address over the return address
+	c.Step()
+	testhelpers.AssertWord(t, 0x0300, c.programCounter)
+	if c.CallDepth() != 1 {
+		t.Errorf("Expected returning to a replaced return address to leave the call on the stack, but the depth was %d", c.CallDepth())
+	}
+}
+
+func TestCallStackInState(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0xCD, 0x00, 0x02}, 0x0100)
+	c.mmu.WriteBytes([]byte{0xFF}, 0x0200) // RST $38
+	c.Step()
+	c.Step()
+
+	var state bytes.Buffer
+	if err := c.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	// going back to the state brings back the calls made before it
+	restored := mockCPU()
+	if err := restored.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	expected, frames := c.CallStack(), restored.CallStack()
+	if len(frames) != len(expected) || frames[0] != expected[0] || frames[1] != expected[1] {
+		t.Errorf("Expected the call stack %+v to be restored, but was %+v", expected, frames)
+	}
+}
+
+func TestInterruptDispatch(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0xFB, 0x00, 0x00}, 0x0100) // EI, NOP, NOP
+	c.mmu.WriteBytes([]byte{0x05}, interruptEnableRegister)
+	c.mmu.WriteBytes([]byte{0x04}, interruptFlagRegister)
+
+	// EI only takes effect after the instruction that follows it
+	c.Step()
+	c.Step()
+	testhelpers.AssertWord(t, 0x0102, c.programCounter)
+
+	cycles := c.Step()
+	assertCycles(t, 20, cycles)
+	testhelpers.AssertWord(t, 0x0050, c.programCounter)
+	testhelpers.AssertWord(t, 0x0102, c.readWord(0xFFFC))
+	testhelpers.AssertByte(t, 0x00, c.mmu.PeekByte(interruptFlagRegister))
+
+	frames := c.CallStack()
+	if len(frames) != 1 || frames[0].Kind != debugger.FrameInterrupt || frames[0].Caller != 0x0102 {
+		t.Errorf("Expected the interrupt to be on the call stack, but was %+v", frames)
+	}
+
+	// interrupts are disabled until RETI
+	c.mmu.WriteBytes([]byte{0xD9}, 0x0050)
+	c.mmu.WriteBytes([]byte{0x01}, interruptFlagRegister)
+	c.Step()
+	testhelpers.AssertWord(t, 0x0102, c.programCounter)
+	if c.CallDepth() != 0 {
+		t.Errorf("Expected RETI to return from the interrupt, but the depth was %d", c.CallDepth())
+	}
+
+	c.Step()
+	testhelpers.AssertWord(t, 0x0040, c.programCounter)
+}
+
+func TestInterruptDispatchIsntAWrite(t *testing.T) {
+	m := mmu.NewMMU()
+	c := NewCPU(m)
+	dbg := debugger.NewDebugger()
+	c.AttachDebugger(dbg)
+	m.AttachDebugger(dbg)
+	c.ime = true
+	c.stackPointer = 0xFFFE
+	m.WriteBytes([]byte{0x01}, interruptEnableRegister)
+	m.WriteBytes([]byte{0x01}, interruptFlagRegister)
+	dbg.AddWatchpoint(interruptFlagRegister, debugger.WatchWrite)
+	dbg.Execute(`var writes = 0; on('io_write', function(e) { writes++; });`)
+
+	// clearing the interrupt's bit in IF is done by the hardware, not the program
+	c.Step()
+	testhelpers.AssertWord(t, 0x0040, c.programCounter)
+	testhelpers.AssertByte(t, 0x00, m.PeekByte(interruptFlagRegister))
+	if output := dbg.Execute("writes"); output != "0\n" {
+		t.Errorf("Expected servicing the interrupt not to fire io_write, but it fired %q times", output)
+	}
+	if dbg.BreakpointActive {
+		t.Error("Expected servicing the interrupt not to hit a watchpoint on IF")
+	}
+}
+
+func TestEIDelay(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0xFB, 0xF3, 0x00, 0xFB, 0x00, 0x00}, 0x0100) // EI, DI, NOP, EI, NOP, NOP
+	c.mmu.WriteBytes([]byte{0x01}, interruptEnableRegister)
+	c.mmu.WriteBytes([]byte{0x01}, interruptFlagRegister)
+
+	// a DI straight after an EI cancels it before it takes effect
+	c.Step()
+	c.Step()
+	c.Step()
+	testhelpers.AssertWord(t, 0x0103, c.programCounter)
+
+	// interrupts aren't enabled until the instruction after the EI has executed
+	c.Step()
+	if c.ime {
+		t.Error("Expected interrupts to stay disabled until after the next instruction")
+	}
+	c.Step()
+	if !c.ime {
+		t.Error("Expected interrupts to be enabled after the instruction following EI")
+	}
+	testhelpers.AssertWord(t, 0x0105, c.programCounter)
+
+	c.Step()
+	testhelpers.AssertWord(t, 0x0040, c.programCounter)
+}
+
+func TestInterruptPriority(t *testing.T) {
+	c := mockCPU()
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0100
+	c.mmu.WriteBytes([]byte{0x1F}, interruptFlagRegister)
+
+	// interrupts that aren't enabled are left requested
+	c.mmu.WriteBytes([]byte{0x1E}, interruptEnableRegister)
+
+	expected := []struct {
+		vector uint16
+		flags  byte
+	}{{0x0048, 0x1D}, {0x0050, 0x19}, {0x0058, 0x11}, {0x0060, 0x01}}
+	for _, e := range expected {
+		c.ime = true
+		c.Step()
+		testhelpers.AssertWord(t, e.vector, c.programCounter)
+		testhelpers.AssertByte(t, e.flags, c.mmu.PeekByte(interruptFlagRegister))
+		if c.ime {
+			t.Errorf("Expected servicing the interrupt at %04X to disable interrupts", e.vector)
+		}
+	}
+
+	// the vblank interrupt has the highest priority once it's enabled
+	c.mmu.WriteBytes([]byte{0x1F}, interruptFlagRegister)
+	c.mmu.WriteBytes([]byte{0x1F}, interruptEnableRegister)
+	c.ime = true
+	c.Step()
+	testhelpers.AssertWord(t, 0x0040, c.programCounter)
+	testhelpers.AssertByte(t, 0x1E, c.mmu.PeekByte(interruptFlagRegister))
+}
+
+func TestInterruptDispatchTiming(t *testing.T) {
+	bus := &flatBus{}
+	c := NewCPU(bus)
+	c.stackPointer = 0xFFFE
+	c.programCounter = 0x0102
+	c.ime = true
+	bus[interruptEnableRegister] = 0x04
+	bus[interruptFlagRegister] = 0x04
+
+	// the stack as it is after each M-cycle
+	var stack []string
+	c.SetCycleHook(func(cycles int) {
+		for i := 0; i < cycles; i += 4 {
+			stack = append(stack, fmt.Sprintf("%02X %02X", bus[0xFFFD], bus[0xFFFC]))
+		}
+	})
+
+	// two M-cycles go by, the program counter is pushed high byte first and then the jump takes one more
+	cycles := c.Step()
+	assertCycles(t, 20, cycles)
+	testhelpers.AssertWord(t, 0x0050, c.programCounter)
+
+	expected := []string{"00 00", "00 00", "01 00", "01 02", "01 02"}
+	if strings.Join(stack, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Expected the stack to be %v after each M-cycle, but was %v", expected, stack)
+	}
+}
+
+func TestUnimplementedOpcodeCycles(t *testing.T) {
+	for _, mcycle := range []bool{false, true} {
+		bus := &flatBus{}
+		bus.WriteBytes([]byte{0xD3, 0xCB, 0x00}, 0x0100) // there's no D3 and RLC B isn't implemented yet
+		c := NewCPU(bus)
+		c.programCounter = 0x0100
+
+		ticked := 0
+		if mcycle {
+			c.SetCycleHook(func(cycles int) { ticked += cycles })
+		}
+
+		// the opcodes are still fetched, so time goes on the same in both modes
+		assertCycles(t, 4, c.Step())
+		c.programCounter = 0x0101
+		assertCycles(t, 8, c.Step())
+		if mcycle && ticked != 12 {
+			t.Errorf("Expected the fetches to tick 12 cycles when M-cycle stepping, but %d were ticked", ticked)
+		}
+	}
+}
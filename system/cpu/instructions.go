@@ -16,6 +16,10 @@ type instruction struct {
 	// take 8 cycles, while 16-bit register loads take 12 cycles.
 	cycles int
 
+	// Conditional jumps, calls and returns take longer when the condition is met and the branch is taken. This
+	// is the number of cycles consumed in that case, and is 0 for instructions that never branch.
+	takenCycles int
+
 	// The length the operands + instruction. Some instructions require more bytes for their parameters
 	// than others. For example compare NoOp (0x00) with loading a value in the stack pointer (0x31). The
 	// NoOp instruction takes 0 operands and so the entire instruction consumes 1 byte, just for the instruction itself.
@@ -33,11 +37,12 @@ type instruction struct {
 
 func (i *instruction) Debug() map[string]interface{} {
 	return map[string]interface{}{
-		"opcode":    i.opcode,
-		"opcodeHex": fmt.Sprintf("0x%2x", i.opcode),
-		"mnemonic":  i.mnemonic,
-		"cycles":    i.cycles,
-		"len":       i.len,
+		"opcode":      i.opcode,
+		"opcodeHex":   fmt.Sprintf("0x%2x", i.opcode),
+		"mnemonic":    i.mnemonic,
+		"cycles":      i.cycles,
+		"takenCycles": i.takenCycles,
+		"len":         i.len,
 	}
 
 }
@@ -45,42 +50,66 @@ func (i *instruction) Debug() map[string]interface{} {
 // I'm going off of this list for the info about the opcodes including the mnemonic: http://www.pastraiser.com/cpu/gameboy/gameboy_opcodes.html
 
 var baseInstructions = map[byte]*instruction{
-	0x00: &instruction{0x00, "NOP", 4, 1, false, func(c *CPU) {}},
-	0x01: &instruction{0x01, "LD BC,d16", 12, 3, false, func(c *CPU) { c.registers.BC.setWord(c.operandWord()) }},
-	0x05: &instruction{0x05, "DEC B", 4, 1, false, func(c *CPU) { c.decrementRegister(&c.registers.BC.low) }},
-	0x06: &instruction{0x06, "LD B,d8", 8, 2, false, func(c *CPU) { c.registers.BC.low = c.operandByte() }},
-	0x0C: &instruction{0x0C, "INC C", 4, 1, false, func(c *CPU) { c.incrementRegister(&c.registers.BC.high) }},
-	0x0E: &instruction{0x0E, "LD C,d8", 8, 2, false, func(c *CPU) { c.registers.BC.high = c.operandByte() }},
-	0x11: &instruction{0x11, "LD DE,d16", 12, 3, false, func(c *CPU) { c.registers.DE.setWord(c.operandWord()) }},
-	0x13: &instruction{0x13, "INC DE", 8, 1, false, func(c *CPU) { c.registers.DE.setWord(c.registers.DE.word() + 1) }},
-	0x1A: &instruction{0x1A, "LD A,(DE)", 8, 1, false, func(c *CPU) { c.registers.AF.low = c.mmu.ReadByte(c.registers.DE.word()) }},
-	// TODO: I'm not entirely sure how to handle cycles for these jump calls. It looks like they can vary in some way...
-	0x20: &instruction{0x20, "JR NZ,r8", 8, 2, true, func(c *CPU) {
-		cond := *c.registers.flag()&flagZ == 0 // Z flag is unset
-		c.jumpOnCondition(c.operandByte(), cond)
-	}},
-	0x21: &instruction{0x21, "LD HL,d16", 12, 3, false, func(c *CPU) { c.registers.HL.setWord(c.operandWord()) }},
-	0x22: &instruction{0x22, "LD (HL+),A", 8, 1, false, func(c *CPU) { c.ldIntoRegisterPairAddressAndInc(&c.registers.HL, c.registers.AF.low) }},
-	0x23: &instruction{0x23, "INC HL", 8, 1, false, func(c *CPU) { c.registers.HL.setWord(c.registers.HL.word() + 1) }},
-	0x31: &instruction{0x31, "LD SP,d16", 12, 3, false, func(c *CPU) { c.stackPointer = c.operandWord() }},
-	0x32: &instruction{0x32, "LD (HL-),A", 8, 1, false, func(c *CPU) { c.ldIntoRegisterPairAddressAndDec(&c.registers.HL, c.registers.AF.low) }},
-	0x3E: &instruction{0x3E, "LD A,d8", 8, 2, false, func(c *CPU) { c.registers.AF.low = c.operandByte() }},
-	0x4F: &instruction{0x4F, "LD C,A", 4, 1, false, func(c *CPU) { c.registers.BC.high = c.registers.AF.low }},
-	0x77: &instruction{0x77, "LD (HL),A", 8, 1, false, func(c *CPU) { c.ldIntoRegisterPairAddress(&c.registers.HL, c.registers.AF.low) }},
-	0x7B: &instruction{0x7B, "LD A,E", 4, 1, false, func(c *CPU) { c.registers.AF.low = c.registers.DE.high }},
-	0xAF: &instruction{0xAF, "XOR A", 4, 1, false, func(c *CPU) { c.xorRegisters(&c.registers.AF.low, c.registers.AF.low) }},
-	0xC1: &instruction{0xC1, "POP BC", 12, 1, false, func(c *CPU) { c.popStackIntoRegisterPair(&c.registers.BC) }},
-	0xC5: &instruction{0xC5, "PUSH BC", 16, 1, false, func(c *CPU) { c.pushWordOntoStack(c.registers.BC.word()) }},
-	0xC9: &instruction{0xC9, "RET", 16, 1, true, func(c *CPU) { c.ret() }},
-	0xCD: &instruction{0xCD, "CALL a16", 24, 3, true, func(c *CPU) { c.call(c.operandWord()) }},
-	0xE0: &instruction{0xE0, "LDH (a8),A", 12, 2, false, func(c *CPU) { c.mmu.WriteBytes([]byte{c.registers.AF.low}, 0xFF00+uint16(c.operandByte())) }},
-	0xE2: &instruction{0xE2, "LD A,(C)", 8, 1, false, func(c *CPU) { c.mmu.WriteBytes([]byte{c.registers.AF.low}, 0xFF00+uint16(c.registers.BC.high)) }},
-	0xFE: &instruction{0xFE, "CP d8", 8, 2, false, func(c *CPU) { c.compareA(c.operandByte()) }},
+	0x00: &instruction{0x00, "NOP", 4, 0, 1, false, func(c *CPU) {}},
+	0x01: &instruction{0x01, "LD BC,d16", 12, 0, 3, false, func(c *CPU) { c.registers.BC.setWord(c.operandWord()) }},
+	0x05: &instruction{0x05, "DEC B", 4, 0, 1, false, func(c *CPU) { c.decrementRegister(&c.registers.BC.low) }},
+	0x06: &instruction{0x06, "LD B,d8", 8, 0, 2, false, func(c *CPU) { c.registers.BC.low = c.operandByte() }},
+	0x0C: &instruction{0x0C, "INC C", 4, 0, 1, false, func(c *CPU) { c.incrementRegister(&c.registers.BC.high) }},
+	0x0E: &instruction{0x0E, "LD C,d8", 8, 0, 2, false, func(c *CPU) { c.registers.BC.high = c.operandByte() }},
+	0x11: &instruction{0x11, "LD DE,d16", 12, 0, 3, false, func(c *CPU) { c.registers.DE.setWord(c.operandWord()) }},
+	0x13: &instruction{0x13, "INC DE", 8, 0, 1, false, func(c *CPU) { c.incrementRegisterPair(&c.registers.DE) }},
+	0x18: &instruction{0x18, "JR r8", 12, 12, 2, true, func(c *CPU) { c.jumpOnCondition(c.operandByte(), true) }},
+	0x1A: &instruction{0x1A, "LD A,(DE)", 8, 0, 1, false, func(c *CPU) { c.registers.AF.low = c.readByte(c.registers.DE.word()) }},
+	0x20: &instruction{0x20, "JR NZ,r8", 8, 12, 2, true, func(c *CPU) { c.jumpOnCondition(c.operandByte(), !c.registers.flagSet(flagZ)) }},
+	0x21: &instruction{0x21, "LD HL,d16", 12, 0, 3, false, func(c *CPU) { c.registers.HL.setWord(c.operandWord()) }},
+	0x22: &instruction{0x22, "LD (HL+),A", 8, 0, 1, false, func(c *CPU) { c.ldIntoRegisterPairAddressAndInc(&c.registers.HL, c.registers.AF.low) }},
+	0x23: &instruction{0x23, "INC HL", 8, 0, 1, false, func(c *CPU) { c.incrementRegisterPair(&c.registers.HL) }},
+	0x28: &instruction{0x28, "JR Z,r8", 8, 12, 2, true, func(c *CPU) { c.jumpOnCondition(c.operandByte(), c.registers.flagSet(flagZ)) }},
+	0x30: &instruction{0x30, "JR NC,r8", 8, 12, 2, true, func(c *CPU) { c.jumpOnCondition(c.operandByte(), !c.registers.flagSet(flagC)) }},
+	0x31: &instruction{0x31, "LD SP,d16", 12, 0, 3, false, func(c *CPU) { c.stackPointer = c.operandWord(); c.unwindCallStack() }},
+	0x32: &instruction{0x32, "LD (HL-),A", 8, 0, 1, false, func(c *CPU) { c.ldIntoRegisterPairAddressAndDec(&c.registers.HL, c.registers.AF.low) }},
+	0x38: &instruction{0x38, "JR C,r8", 8, 12, 2, true, func(c *CPU) { c.jumpOnCondition(c.operandByte(), c.registers.flagSet(flagC)) }},
+	0x3E: &instruction{0x3E, "LD A,d8", 8, 0, 2, false, func(c *CPU) { c.registers.AF.low = c.operandByte() }},
+	0x4F: &instruction{0x4F, "LD C,A", 4, 0, 1, false, func(c *CPU) { c.registers.BC.high = c.registers.AF.low }},
+	0x77: &instruction{0x77, "LD (HL),A", 8, 0, 1, false, func(c *CPU) { c.ldIntoRegisterPairAddress(&c.registers.HL, c.registers.AF.low) }},
+	0x7B: &instruction{0x7B, "LD A,E", 4, 0, 1, false, func(c *CPU) { c.registers.AF.low = c.registers.DE.high }},
+	0xAF: &instruction{0xAF, "XOR A", 4, 0, 1, false, func(c *CPU) { c.xorRegisters(&c.registers.AF.low, c.registers.AF.low) }},
+	0xC0: &instruction{0xC0, "RET NZ", 8, 20, 1, true, func(c *CPU) { c.retOnCondition(!c.registers.flagSet(flagZ)) }},
+	0xC1: &instruction{0xC1, "POP BC", 12, 0, 1, false, func(c *CPU) { c.popStackIntoRegisterPair(&c.registers.BC) }},
+	0xC2: &instruction{0xC2, "JP NZ,a16", 12, 16, 3, true, func(c *CPU) { c.jumpToOnCondition(c.operandWord(), !c.registers.flagSet(flagZ)) }},
+	0xC3: &instruction{0xC3, "JP a16", 16, 16, 3, true, func(c *CPU) { c.jumpToOnCondition(c.operandWord(), true) }},
+	0xC4: &instruction{0xC4, "CALL NZ,a16", 12, 24, 3, true, func(c *CPU) { c.callOnCondition(c.operandWord(), !c.registers.flagSet(flagZ)) }},
+	0xC5: &instruction{0xC5, "PUSH BC", 16, 0, 1, false, func(c *CPU) { c.pushWordOntoStack(c.registers.BC.word()) }},
+	0xC7: &instruction{0xC7, "RST 00H", 16, 0, 1, true, func(c *CPU) { c.rst(0x00) }},
+	0xC8: &instruction{0xC8, "RET Z", 8, 20, 1, true, func(c *CPU) { c.retOnCondition(c.registers.flagSet(flagZ)) }},
+	0xC9: &instruction{0xC9, "RET", 16, 0, 1, true, func(c *CPU) { c.ret() }},
+	0xCA: &instruction{0xCA, "JP Z,a16", 12, 16, 3, true, func(c *CPU) { c.jumpToOnCondition(c.operandWord(), c.registers.flagSet(flagZ)) }},
+	0xCC: &instruction{0xCC, "CALL Z,a16", 12, 24, 3, true, func(c *CPU) { c.callOnCondition(c.operandWord(), c.registers.flagSet(flagZ)) }},
+	0xCD: &instruction{0xCD, "CALL a16", 24, 0, 3, true, func(c *CPU) { c.call(c.operandWord()) }},
+	0xCF: &instruction{0xCF, "RST 08H", 16, 0, 1, true, func(c *CPU) { c.rst(0x08) }},
+	0xD0: &instruction{0xD0, "RET NC", 8, 20, 1, true, func(c *CPU) { c.retOnCondition(!c.registers.flagSet(flagC)) }},
+	0xD2: &instruction{0xD2, "JP NC,a16", 12, 16, 3, true, func(c *CPU) { c.jumpToOnCondition(c.operandWord(), !c.registers.flagSet(flagC)) }},
+	0xD4: &instruction{0xD4, "CALL NC,a16", 12, 24, 3, true, func(c *CPU) { c.callOnCondition(c.operandWord(), !c.registers.flagSet(flagC)) }},
+	0xD7: &instruction{0xD7, "RST 10H", 16, 0, 1, true, func(c *CPU) { c.rst(0x10) }},
+	0xD8: &instruction{0xD8, "RET C", 8, 20, 1, true, func(c *CPU) { c.retOnCondition(c.registers.flagSet(flagC)) }},
+	0xD9: &instruction{0xD9, "RETI", 16, 0, 1, true, func(c *CPU) { c.ret(); c.ime = true }},
+	0xDA: &instruction{0xDA, "JP C,a16", 12, 16, 3, true, func(c *CPU) { c.jumpToOnCondition(c.operandWord(), c.registers.flagSet(flagC)) }},
+	0xDC: &instruction{0xDC, "CALL C,a16", 12, 24, 3, true, func(c *CPU) { c.callOnCondition(c.operandWord(), c.registers.flagSet(flagC)) }},
+	0xDF: &instruction{0xDF, "RST 18H", 16, 0, 1, true, func(c *CPU) { c.rst(0x18) }},
+	0xE0: &instruction{0xE0, "LDH (a8),A", 12, 0, 2, false, func(c *CPU) { c.writeByte(0xFF00+uint16(c.operandByte()), c.registers.AF.low) }},
+	0xE2: &instruction{0xE2, "LD A,(C)", 8, 0, 1, false, func(c *CPU) { c.writeByte(0xFF00+uint16(c.registers.BC.high), c.registers.AF.low) }},
+	0xE7: &instruction{0xE7, "RST 20H", 16, 0, 1, true, func(c *CPU) { c.rst(0x20) }},
+	0xEF: &instruction{0xEF, "RST 28H", 16, 0, 1, true, func(c *CPU) { c.rst(0x28) }},
+	0xF3: &instruction{0xF3, "DI", 4, 0, 1, false, func(c *CPU) { c.disableInterrupts() }},
+	0xF7: &instruction{0xF7, "RST 30H", 16, 0, 1, true, func(c *CPU) { c.rst(0x30) }},
+	0xFB: &instruction{0xFB, "EI", 4, 0, 1, false, func(c *CPU) { c.enableInterrupts() }},
+	0xFE: &instruction{0xFE, "CP d8", 8, 0, 2, false, func(c *CPU) { c.compareA(c.operandByte()) }},
+	0xFF: &instruction{0xFF, "RST 38H", 16, 0, 1, true, func(c *CPU) { c.rst(0x38) }},
 }
 
 // The instruction length for the extended instructions is going to be what is in the above link-1. I believe that in the link above when they
 // say the instruction length is 2 it's because they are counting both the 0xCB byte + the instruction.
 var extendedInstructions = map[byte]*instruction{
-	0x11: &instruction{0x11, "RL C", 8, 2, false, func(c *CPU) { c.rotateRegisterLeft(&c.registers.BC.high) }},
-	0x7C: &instruction{0x7C, "BIT 7,H", 8, 1, false, func(c *CPU) { c.testRegisterBit(c.registers.HL.low, 7) }},
+	0x11: &instruction{0x11, "RL C", 8, 0, 1, false, func(c *CPU) { c.rotateRegisterLeft(&c.registers.BC.high) }},
+	0x7C: &instruction{0x7C, "BIT 7,H", 8, 0, 1, false, func(c *CPU) { c.testRegisterBit(c.registers.HL.low, 7) }},
 }
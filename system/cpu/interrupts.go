@@ -0,0 +1,77 @@
+package cpu
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+)
+
+const (
+	// the interrupt flag register holds the requested interrupts, and the interrupt enable register the
+	// interrupts that can be serviced
+	interruptFlagRegister   = 0xFF0F
+	interruptEnableRegister = 0xFFFF
+
+	// servicing an interrupt takes 5 M-cycles
+	interruptCycles = 20
+)
+
+// interruptVectors are the addresses jumped to for each interrupt bit, in priority order
+var interruptVectors = [5]uint16{0x0040, 0x0048, 0x0050, 0x0058, 0x0060}
+
+// interruptNames are the names of each interrupt bit, in priority order
+var interruptNames = [5]string{"vblank", "stat", "timer", "serial", "joypad"}
+
+// serviceInterrupt jumps to the highest priority interrupt that is both requested and enabled, if interrupts are
+// enabled. It returns true if an interrupt was serviced.
+func (c *CPU) serviceInterrupt() bool {
+	if !c.ime {
+		return false
+	}
+
+	pending := c.mmu.PeekByte(interruptFlagRegister) & c.mmu.PeekByte(interruptEnableRegister) & 0x1F
+	if pending == 0 {
+		return false
+	}
+
+	var bit uint
+	for pending&(1<<bit) == 0 {
+		bit++
+	}
+
+	c.ime = false
+	c.mmu.AcknowledgeInterrupt(1 << bit)
+
+	// two M-cycles go by before the program counter is pushed, and one more to jump
+	c.internalDelay()
+	c.pushWordOntoStack(c.programCounter)
+	c.internalDelay()
+
+	c.pushFrame(debugger.FrameInterrupt, c.programCounter, interruptVectors[bit], c.programCounter)
+	c.programCounter = interruptVectors[bit]
+
+	if c.debuggerActive {
+		c.debugger.RunCallbacks("interrupt", map[string]interface{}{"name": interruptNames[bit], "vector": interruptVectors[bit]})
+	}
+
+	return true
+}
+
+// enableInterrupts enables interrupts after the next instruction executes, which is how EI behaves.
+func (c *CPU) enableInterrupts() {
+	c.imeDelay = 2
+}
+
+// disableInterrupts disables interrupts right away, canceling an EI that hasn't taken effect yet.
+func (c *CPU) disableInterrupts() {
+	c.ime = false
+	c.imeDelay = 0
+}
+
+// updateIME counts down to enabling interrupts after an EI. It's called after every instruction.
+func (c *CPU) updateIME() {
+	if c.imeDelay > 0 {
+		c.imeDelay--
+		if c.imeDelay == 0 {
+			c.ime = true
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package cpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// These tests run the per-opcode JSON test vectors from the SingleStepTests sm83 suite
+// (https://github.com/SingleStepTests/sm83). Each file in testdata/sm83 is named after the opcode it covers
+// ("1a.json", or "cb 7c.json" for extended opcodes) and holds a list of cases giving the state of the CPU and
+// RAM before and after executing a single instruction, along with the bus activity for every M-cycle.
+//
+// Every case is run twice: once a whole instruction at a time, and once M-cycle stepping where the memory access
+// made on each M-cycle is checked against the bus activity in the test case.
+//
+// These are the tests for individual instructions: when an opcode is implemented, its file from the full suite
+// goes into testdata/sm83 as is. Opcodes we haven't implemented yet are skipped.
+
+// sm83State is the CPU and RAM state of a test case. IE is the interrupt enable register, which lives at $FFFF.
+type sm83State struct {
+	PC  uint16      `json:"pc"`
+	SP  uint16      `json:"sp"`
+	A   byte        `json:"a"`
+	B   byte        `json:"b"`
+	C   byte        `json:"c"`
+	D   byte        `json:"d"`
+	E   byte        `json:"e"`
+	F   byte        `json:"f"`
+	H   byte        `json:"h"`
+	L   byte        `json:"l"`
+	IME byte        `json:"ime"`
+	IE  byte        `json:"ie"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+// sm83Case is a single test case. Cycles has an entry for each M-cycle the instruction takes.
+type sm83Case struct {
+	Name    string          `json:"name"`
+	Initial sm83State       `json:"initial"`
+	Final   sm83State       `json:"final"`
+	Cycles  json.RawMessage `json:"cycles"`
+}
+
+// flatBus is 64K of flat RAM with no memory mapped I/O, ROM or banking, which is what the test vectors assume.
+type flatBus [0x10000]byte
+
+func (b *flatBus) ReadByte(location uint16) byte {
+	return b[location]
+}
+
+func (b *flatBus) ReadWord(location uint16) uint16 {
+	return uint16(b[location]) | uint16(b[location+1])<<8
+}
+
+func (b *flatBus) WriteBytes(content []byte, location uint16) {
+	for _, v := range content {
+		b[location] = v
+		location++
+	}
+}
+
+func (b *flatBus) FetchByte(location uint16) byte {
+	return b[location]
+}
+
+func (b *flatBus) PeekByte(location uint16) byte {
+	return b[location]
+}
+
+func (b *flatBus) Bank(location uint16) int {
+	return 0
+}
+
+func (b *flatBus) AcknowledgeInterrupt(interrupt byte) {
+	b[0xFF0F] &^= interrupt
+}
+
+// recordingBus is flat RAM that records each memory access, so they can be matched up with the M-cycles they
+// happened on.
+type recordingBus struct {
+	flatBus
+	accesses []string
+}
+
+func (b *recordingBus) ReadByte(location uint16) byte {
+	value := b.flatBus.ReadByte(location)
+	b.accesses = append(b.accesses, fmt.Sprintf("read %02x from %04x", value, location))
+	return value
+}
+
+func (b *recordingBus) FetchByte(location uint16) byte {
+	return b.ReadByte(location)
+}
+
+func (b *recordingBus) WriteBytes(content []byte, location uint16) {
+	for i, value := range content {
+		b.accesses = append(b.accesses, fmt.Sprintf("write %02x to %04x", value, location+uint16(i)))
+	}
+	b.flatBus.WriteBytes(content, location)
+}
+
+func TestSM83(t *testing.T) {
+	files, err := filepath.Glob("testdata/sm83/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			inst, err := sm83Instruction(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inst == nil {
+				t.Skipf("opcode %s is not implemented", name)
+			}
+
+			contents, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var cases []sm83Case
+			if err := json.Unmarshal(contents, &cases); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tc := range cases {
+				runSM83Case(t, tc, false)
+				runSM83Case(t, tc, true)
+			}
+		})
+	}
+}
+
+// sm83Instruction returns the instruction a test file covers based on its name, or nil if it isn't implemented.
+func sm83Instruction(name string) (*instruction, error) {
+	table := baseInstructions
+	if strings.HasPrefix(name, "cb ") {
+		table = extendedInstructions
+		name = strings.TrimPrefix(name, "cb ")
+	}
+
+	opcode, err := strconv.ParseUint(name, 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("can't determine the opcode from the file name: %v", err)
+	}
+
+	return table[byte(opcode)], nil
+}
+
+// runSM83Case loads the initial state of a test case, executes a single instruction and compares the result
+// against the final state. When mcycle is true the CPU is M-cycle stepped and the bus activity is compared as well.
+func runSM83Case(t *testing.T, tc sm83Case, mcycle bool) {
+	bus := &recordingBus{}
+	c := NewCPU(bus)
+
+	// when M-cycle stepping, each M-cycle gets whatever memory access happened since the last one
+	var activity []string
+	if mcycle {
+		c.SetCycleHook(func(cycles int) {
+			for i := 0; i < cycles; i += 4 {
+				access := "internal"
+				if len(bus.accesses) > 0 {
+					access = strings.Join(bus.accesses, ", ")
+					bus.accesses = nil
+				}
+				activity = append(activity, access)
+			}
+		})
+	}
+
+	in := tc.Initial
+	c.programCounter = in.PC
+	c.stackPointer = in.SP
+	c.registers.AF.low, c.registers.AF.high = in.A, in.F
+	c.registers.BC.low, c.registers.BC.high = in.B, in.C
+	c.registers.DE.low, c.registers.DE.high = in.D, in.E
+	c.registers.HL.low, c.registers.HL.high = in.H, in.L
+	c.ime = in.IME == 1
+	bus.flatBus[interruptEnableRegister] = in.IE
+	for _, entry := range in.RAM {
+		bus.flatBus[entry[0]] = byte(entry[1])
+	}
+
+	cycles := c.Step()
+
+	out := tc.Final
+	expectWord := func(reg string, expected, actual uint16) {
+		if expected != actual {
+			t.Errorf("%s: expected %s to be 0x%04x, but was 0x%04x", tc.Name, reg, expected, actual)
+		}
+	}
+	expectByte := func(reg string, expected, actual byte) {
+		if expected != actual {
+			t.Errorf("%s: expected %s to be 0x%02x, but was 0x%02x", tc.Name, reg, expected, actual)
+		}
+	}
+
+	expectWord("PC", out.PC, c.programCounter)
+	expectWord("SP", out.SP, c.stackPointer)
+	expectByte("A", out.A, c.registers.AF.low)
+	expectByte("F", out.F, c.registers.AF.high)
+	expectByte("B", out.B, c.registers.BC.low)
+	expectByte("C", out.C, c.registers.BC.high)
+	expectByte("D", out.D, c.registers.DE.low)
+	expectByte("E", out.E, c.registers.DE.high)
+	expectByte("H", out.H, c.registers.HL.low)
+	expectByte("L", out.L, c.registers.HL.high)
+	if c.ime != (out.IME == 1) {
+		t.Errorf("%s: expected IME to be %d, but was %t", tc.Name, out.IME, c.ime)
+	}
+	expectByte("IE", out.IE, bus.flatBus[interruptEnableRegister])
+
+	for _, entry := range out.RAM {
+		expectByte(fmt.Sprintf("memory at 0x%04x", entry[0]), byte(entry[1]), bus.flatBus[entry[0]])
+	}
+
+	expected := sm83Activity(t, tc)
+	if len(expected)*4 != cycles {
+		t.Errorf("%s: expected the instruction to take %d cycles, but it took %d", tc.Name, len(expected)*4, cycles)
+	}
+
+	if !mcycle {
+		return
+	}
+
+	if len(activity) != len(expected) {
+		t.Errorf("%s: expected %d M-cycles, but %d were stepped", tc.Name, len(expected), len(activity))
+		return
+	}
+	for i := range expected {
+		if activity[i] != expected[i] {
+			t.Errorf("%s: expected M-cycle %d to %s, but it did %s", tc.Name, i+1, expected[i], activity[i])
+		}
+	}
+}
+
+// sm83Activity returns the memory access made on each M-cycle of a test case, in the same form the
+// recordingBus records them.
+func sm83Activity(t *testing.T, tc sm83Case) []string {
+	var cycles []*[3]interface{}
+	if err := json.Unmarshal(tc.Cycles, &cycles); err != nil {
+		t.Fatal(err)
+	}
+
+	activity := make([]string, len(cycles))
+	for i, cycle := range cycles {
+		activity[i] = "internal"
+		if cycle == nil {
+			continue
+		}
+
+		address, _ := cycle[0].(float64)
+		value, _ := cycle[1].(float64)
+		pins, _ := cycle[2].(string)
+		switch {
+		case strings.Contains(pins, "r"):
+			activity[i] = fmt.Sprintf("read %02x from %04x", int(value), int(address))
+		case strings.Contains(pins, "w"):
+			activity[i] = fmt.Sprintf("write %02x to %04x", int(value), int(address))
+		}
+	}
+
+	return activity
+}
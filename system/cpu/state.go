@@ -0,0 +1,110 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"io"
+)
+
+// cpuState is the CPU state that goes into a save state. The CPU has no HALT or STOP state yet, so the registers
+// and interrupt master enable are everything apart from the shadow call stack. CallDepth frames follow.
+type cpuState struct {
+	AF, BC, DE, HL uint16
+	StackPointer   uint16
+	ProgramCounter uint16
+	IME            bool
+	IMEDelay       uint8
+	CallDepth      uint16
+}
+
+// savedFrame is a frame of the shadow call stack in a save state. Kind is an index into frameKinds.
+type savedFrame struct {
+	Kind                        uint8
+	Caller, CallerBank          uint16
+	Target, TargetBank          uint16
+	ReturnAddress, StackPointer uint16
+}
+
+// frameKinds are the kinds of frame, in the order they're numbered in save states
+var frameKinds = []string{debugger.FrameCall, debugger.FrameRST, debugger.FrameInterrupt}
+
+// SaveState writes the CPU's registers, interrupt state and shadow call stack to w. The call stack is saved so
+// going back to a state, like the debugger does to run backwards, doesn't lose the calls made before it.
+func (c *CPU) SaveState(w io.Writer) error {
+	state := cpuState{
+		AF:             c.registers.AF.word(),
+		BC:             c.registers.BC.word(),
+		DE:             c.registers.DE.word(),
+		HL:             c.registers.HL.word(),
+		StackPointer:   c.stackPointer,
+		ProgramCounter: c.programCounter,
+		IME:            c.ime,
+		IMEDelay:       uint8(c.imeDelay),
+		CallDepth:      uint16(len(c.callStack)),
+	}
+
+	frames := make([]savedFrame, len(c.callStack))
+	for i, frame := range c.callStack {
+		frames[i] = savedFrame{
+			Caller:        frame.Caller,
+			CallerBank:    uint16(frame.CallerBank),
+			Target:        frame.Target,
+			TargetBank:    uint16(frame.TargetBank),
+			ReturnAddress: frame.ReturnAddress,
+			StackPointer:  frame.StackPointer,
+		}
+		for kind, name := range frameKinds {
+			if frame.Kind == name {
+				frames[i].Kind = uint8(kind)
+			}
+		}
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, state); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, frames)
+}
+
+// LoadState restores the CPU's registers, interrupt state and shadow call stack from r.
+func (c *CPU) LoadState(r io.Reader) error {
+	var state cpuState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+	if state.CallDepth > maxCallStack {
+		return errors.New("The save state's call stack is too deep.")
+	}
+
+	frames := make([]savedFrame, state.CallDepth)
+	if err := binary.Read(r, binary.LittleEndian, frames); err != nil {
+		return err
+	}
+	var callStack []debugger.Frame
+	for _, frame := range frames {
+		if int(frame.Kind) >= len(frameKinds) {
+			return errors.New("The save state's call stack is corrupt.")
+		}
+		callStack = append(callStack, debugger.Frame{
+			Kind:          frameKinds[frame.Kind],
+			Caller:        frame.Caller,
+			CallerBank:    int(frame.CallerBank),
+			Target:        frame.Target,
+			TargetBank:    int(frame.TargetBank),
+			ReturnAddress: frame.ReturnAddress,
+			StackPointer:  frame.StackPointer,
+		})
+	}
+
+	c.registers.AF.setWord(state.AF)
+	c.registers.BC.setWord(state.BC)
+	c.registers.DE.setWord(state.DE)
+	c.registers.HL.setWord(state.HL)
+	c.stackPointer = state.StackPointer
+	c.programCounter = state.ProgramCounter
+	c.ime = state.IME
+	c.imeDelay = int(state.IMEDelay)
+	c.callStack = callStack
+	return nil
+}
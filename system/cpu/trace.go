@@ -0,0 +1,116 @@
+package cpu
+
+import (
+	"bufio"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"io"
+)
+
+const hexDigits = "0123456789ABCDEF"
+
+// Tracer writes a line for every instruction the CPU executes in the format used by gameboy-doctor
+// (https://github.com/robert/gameboy-doctor), which makes it easy to diff our CPU against other emulators:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// Each line is the CPU state right before the instruction at PC is executed. Traces run to millions of lines,
+// so lines are built by hand into a reused buffer and written through a buffered writer.
+type Tracer struct {
+	w    *bufio.Writer
+	line []byte
+
+	// only trace instructions between from and to (inclusive)
+	from uint16
+	to   uint16
+
+	// only trace instructions in this ROM bank. -1 traces all banks.
+	bank int
+
+	// symbols, when set, are used to add the label each instruction is in to the end of its line
+	symbols *symbols.Table
+}
+
+// NewTracer creates a tracer that writes to w. Flush must be called when tracing is done.
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{
+		w:    bufio.NewWriterSize(w, 1<<16),
+		line: make([]byte, 0, 80),
+		from: 0x0000,
+		to:   0xFFFF,
+		bank: -1,
+	}
+}
+
+// LimitRange only traces instructions with a program counter between from and to (inclusive).
+func (t *Tracer) LimitRange(from, to uint16) {
+	t.from = from
+	t.to = to
+}
+
+// LimitBank only traces instructions in the given ROM bank.
+func (t *Tracer) LimitBank(bank int) {
+	t.bank = bank
+}
+
+// AttachSymbols adds the label each instruction is in to the end of its line, e.g. "... PCMEM:00,C3,13,02 ; Main+$3".
+// gameboy-doctor doesn't expect anything after PCMEM, so this is only useful for reading traces.
+func (t *Tracer) AttachSymbols(table *symbols.Table) {
+	t.symbols = table
+}
+
+// Flush writes any buffered lines.
+func (t *Tracer) Flush() error {
+	return t.w.Flush()
+}
+
+// trace writes a line for the current state of the CPU if it's within the traced range.
+func (t *Tracer) trace(c *CPU) {
+	pc := c.programCounter
+	if pc < t.from || pc > t.to {
+		return
+	}
+
+	if t.bank >= 0 && c.mmu.Bank(pc) != t.bank {
+		return
+	}
+
+	line := t.line[:0]
+	line = appendByte(append(line, "A:"...), c.registers.AF.low)
+	line = appendByte(append(line, " F:"...), c.registers.AF.high)
+	line = appendByte(append(line, " B:"...), c.registers.BC.low)
+	line = appendByte(append(line, " C:"...), c.registers.BC.high)
+	line = appendByte(append(line, " D:"...), c.registers.DE.low)
+	line = appendByte(append(line, " E:"...), c.registers.DE.high)
+	line = appendByte(append(line, " H:"...), c.registers.HL.low)
+	line = appendByte(append(line, " L:"...), c.registers.HL.high)
+	line = appendWord(append(line, " SP:"...), c.stackPointer)
+	line = appendWord(append(line, " PC:"...), pc)
+
+	line = append(line, " PCMEM:"...)
+	for i := uint16(0); i < 4; i++ {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = appendByte(line, c.mmu.PeekByte(pc+i))
+	}
+
+	if t.symbols != nil {
+		if label := t.symbols.Describe(c.mmu.Bank(pc), pc); label != "" {
+			line = append(append(line, " ; "...), label...)
+		}
+	}
+	line = append(line, '\n')
+
+	t.w.Write(line)
+	t.line = line
+}
+
+// appendByte appends a byte as two uppercase hex digits.
+func appendByte(dst []byte, b byte) []byte {
+	return append(dst, hexDigits[b>>4], hexDigits[b&0x0F])
+}
+
+// appendWord appends a word as four uppercase hex digits.
+func appendWord(dst []byte, w uint16) []byte {
+	return appendByte(appendByte(dst, byte(w>>8)), byte(w))
+}
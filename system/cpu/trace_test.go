@@ -0,0 +1,89 @@
+package cpu
+
+import (
+	"bytes"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"strings"
+	"testing"
+)
+
+func TestTraceFormat(t *testing.T) {
+	bus := &flatBus{}
+	bus.WriteBytes([]byte{0x00, 0xC3, 0x13, 0x02}, 0x0100)
+
+	c := NewCPU(bus)
+	c.programCounter = 0x0100
+	c.stackPointer = 0xFFFE
+	c.registers.AF.setWord(0x01B0)
+	c.registers.BC.setWord(0x0013)
+	c.registers.DE.setWord(0x00D8)
+	c.registers.HL.setWord(0x014D)
+
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+	c.AttachTracer(tracer)
+	c.Step()
+	tracer.Flush()
+
+	expected := "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02\n"
+	if out.String() != expected {
+		t.Errorf("Expected trace line %q, but got %q", expected, out.String())
+	}
+}
+
+func TestTraceLimitRange(t *testing.T) {
+	bus := &flatBus{}
+	c := NewCPU(bus)
+
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+	tracer.LimitRange(0x0002, 0x0003)
+	c.AttachTracer(tracer)
+
+	// step through five NOPs
+	for i := 0; i < 5; i++ {
+		c.Step()
+	}
+	tracer.Flush()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 trace lines, but got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], "PC:0002") || !strings.Contains(lines[1], "PC:0003") {
+		t.Errorf("Unexpected trace lines: %v", lines)
+	}
+}
+
+func TestTraceLimitBank(t *testing.T) {
+	c := NewCPU(&flatBus{})
+
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+	tracer.LimitBank(1)
+	c.AttachTracer(tracer)
+	c.Step()
+	tracer.Flush()
+
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing to be traced outside of bank 1, but got %q", out.String())
+	}
+}
+
+func TestTraceLabels(t *testing.T) {
+	bus := &flatBus{}
+	c := NewCPU(bus)
+	c.programCounter = 0x0151
+
+	table, _ := symbols.Parse(strings.NewReader("00:0150 Main\n"))
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+	tracer.AttachSymbols(table)
+	c.AttachTracer(tracer)
+	c.Step()
+	tracer.Flush()
+
+	if !strings.HasSuffix(out.String(), "PCMEM:00,00,00,00 ; Main+$1\n") {
+		t.Errorf("Expected the trace line to end with the label, but got %q", out.String())
+	}
+}
@@ -0,0 +1,185 @@
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// setBreakpoints replaces the breakpoints in a source file. Lines are mapped to addresses through the label
+// defined on them, so breakpoints on lines without a label from the symbol file aren't verified.
+func (s *session) setBreakpoints(req request) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]interface{}
+	s.run(func() {
+		for _, id := range s.breakpoints[path] {
+			s.debugger.RemoveBreakpoint(id)
+		}
+		s.breakpoints[path] = nil
+
+		for _, bp := range args.Breakpoints {
+			result := map[string]interface{}{"line": bp.Line, "verified": false}
+
+			id, err := s.addSourceBreakpoint(path, bp.Line, bp.Condition)
+			if err != nil {
+				result["message"] = err.Error()
+			} else {
+				result["id"] = id
+				result["verified"] = true
+				s.breakpoints[path] = append(s.breakpoints[path], id)
+			}
+			results = append(results, result)
+		}
+	})
+
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+// addSourceBreakpoint adds a breakpoint at the label defined on a line.
+func (s *session) addSourceBreakpoint(path string, line int, condition string) (int, error) {
+	label, ok := s.sources.label(path, line)
+	if !ok {
+		return 0, fmt.Errorf("there's no label on this line")
+	}
+
+	table := s.debugger.Symbols()
+	if table == nil {
+		return 0, fmt.Errorf("no symbols are loaded")
+	}
+
+	sym, ok := table.Lookup(label)
+	if !ok {
+		return 0, fmt.Errorf("%s isn't in the symbol file", label)
+	}
+
+	if condition != "" {
+		return s.debugger.AddConditionalBreakpoint(sym.Address, sym.Bank, condition)
+	}
+	return s.debugger.AddBreakpoint(sym.Address, sym.Bank), nil
+}
+
+// setInstructionBreakpoints replaces the breakpoints set on addresses. References can be hex addresses like
+// "0x0150", BANK:ADDRESS pairs or labels.
+func (s *session) setInstructionBreakpoints(req request) (interface{}, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+			Condition            string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	var results []map[string]interface{}
+	s.run(func() {
+		for _, id := range s.instructionBreakpoints {
+			s.debugger.RemoveBreakpoint(id)
+		}
+		s.instructionBreakpoints = nil
+
+		for _, bp := range args.Breakpoints {
+			result := map[string]interface{}{"verified": false}
+
+			bank, address, err := s.debugger.ParseLocation(strings.TrimPrefix(bp.InstructionReference, "0x"))
+			if err == nil {
+				address += uint16(bp.Offset)
+				id := 0
+				if bp.Condition != "" {
+					id, err = s.debugger.AddConditionalBreakpoint(address, bank, bp.Condition)
+				} else {
+					id = s.debugger.AddBreakpoint(address, bank)
+				}
+
+				if err == nil {
+					result["id"] = id
+					result["verified"] = true
+					result["instructionReference"] = instructionReference(address)
+					s.instructionBreakpoints = append(s.instructionBreakpoints, id)
+				}
+			}
+
+			if err != nil {
+				result["message"] = err.Error()
+			}
+			results = append(results, result)
+		}
+	})
+
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+// stackTrace returns where execution is in each frame of the call stack, innermost first.
+func (s *session) stackTrace(req request) (interface{}, error) {
+	var frames []map[string]interface{}
+	var err error
+
+	s.run(func() {
+		var bank int
+		var pc uint16
+		if bank, pc, err = s.debugger.Location(); err != nil {
+			return
+		}
+
+		// without a call stack there's just the current frame
+		calls, _ := s.debugger.Frames()
+
+		frames = append(frames, s.stackFrame(0, bank, pc))
+		for i := len(calls) - 1; i >= 0; i-- {
+			frames = append(frames, s.stackFrame(len(frames), calls[i].CallerBank, calls[i].Caller))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// stackFrame describes where execution is in a frame. When the label it's in was found in a source file the
+// frame is shown at the label's line.
+func (s *session) stackFrame(id, bank int, address uint16) map[string]interface{} {
+	frame := map[string]interface{}{
+		"id":                          id,
+		"name":                        s.debugger.Describe(bank, address),
+		"line":                        0,
+		"column":                      0,
+		"instructionPointerReference": instructionReference(address),
+	}
+
+	if table := s.debugger.Symbols(); table != nil {
+		if sym, ok := table.Nearest(bank, address); ok {
+			if def, ok := s.sources.definition(sym.Name); ok {
+				frame["source"] = map[string]interface{}{"name": filepath.Base(def.path), "path": def.path}
+				frame["line"] = def.line
+				frame["column"] = 1
+			}
+		}
+	}
+
+	return frame
+}
+
+// instructionReference formats an address the way it's given to the client.
+func instructionReference(address uint16) string {
+	return fmt.Sprintf("0x%04X", address)
+}
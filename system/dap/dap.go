@@ -0,0 +1,516 @@
+// Package dap is a Debug Adapter Protocol server, which lets editors debug the code running in the emulator. It
+// talks to one client at a time over stdio or TCP.
+//
+// The emulator is already running the rom when a client connects, so launch and attach both attach to it. Both take
+// these arguments:
+//
+//	stopOnEntry      stop as soon as the client is configured
+//	sourceDirectory  a directory to scan for RGBDS source files, so stack frames can be shown in the source
+//
+// Breakpoints can be set on source lines that define a label from the rom's symbol file, or on addresses and
+// labels with instruction breakpoints. There's a single thread, the CPU, and every stack frame shares the same
+// scopes: the registers, the I/O registers and memory. Stepping back and reverse continue work when rewinding is on.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// threadID is the id of the only thread, the CPU
+const threadID = 1
+
+// request is a request from the client.
+type request struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// response is sent in reply to a request.
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// event is sent to the client when something happens, like execution stopping.
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Server serves Debug Adapter Protocol clients.
+type Server struct {
+	debugger  *debugger.Debugger
+	run       func(fn func())
+	terminate func()
+	listener  net.Listener
+
+	// stops gets the breakpoints, watchpoints and targets that stop execution
+	stops chan debugger.StopEvent
+}
+
+// NewServer creates a server that debugs through dbg. run has to run fn on the goroutine running the system and
+// wait for it to finish. terminate is called when a client asks for the emulator to be stopped.
+func NewServer(dbg *debugger.Debugger, run func(fn func()), terminate func()) *Server {
+	s := &Server{debugger: dbg, run: run, terminate: terminate, stops: make(chan debugger.StopEvent, 1)}
+
+	dbg.OnStop(func(event debugger.StopEvent) {
+		select {
+		case s.stops <- event:
+		default:
+		}
+	})
+
+	return s
+}
+
+// Serve talks to a single client, like one connected over stdio, until it disconnects.
+func (s *Server) Serve(r io.Reader, w io.Writer) {
+	newSession(s, w).serve(r)
+}
+
+// Listen starts serving clients one at a time on a TCP address like ":4711". Addresses without a host only listen
+// on localhost.
+func (s *Server) Listen(address string) error {
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	log.Println("Waiting for a debug adapter client on", listener.Addr())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			log.Println("Debug adapter client connected from", conn.RemoteAddr())
+			s.Serve(conn, conn)
+			conn.Close()
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops listening for clients.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// session is a connection to a client.
+type session struct {
+	*Server
+	w        io.Writer
+	seq      int
+	requests chan request
+	done     chan bool
+
+	sources *sourceIndex
+
+	// the ids of the breakpoints the client set, by source path, and its instruction breakpoints
+	breakpoints            map[string][]int
+	instructionBreakpoints []int
+
+	// stopOnEntry is set by launch and attach, and acted on once the client is configured
+	stopOnEntry bool
+
+	// reverseReason is why execution stopped after running backwards
+	reverseReason string
+}
+
+func newSession(s *Server, w io.Writer) *session {
+	return &session{
+		Server:      s,
+		w:           w,
+		requests:    make(chan request),
+		done:        make(chan bool),
+		sources:     newSourceIndex(),
+		breakpoints: make(map[string][]int),
+	}
+}
+
+// serve handles requests until the client disconnects.
+func (s *session) serve(r io.Reader) {
+	go s.read(r)
+	defer close(s.done)
+
+	// anything that stopped execution before the client connected has already been dealt with
+	select {
+	case <-s.stops:
+	default:
+	}
+
+	for {
+		select {
+		case req, ok := <-s.requests:
+			if !ok {
+				s.disconnect(false)
+				return
+			}
+			if s.handle(req) {
+				return
+			}
+		case stop := <-s.stops:
+			s.stopped(stop)
+		}
+	}
+}
+
+// read reads requests from the client until the connection is closed.
+func (s *session) read(r io.Reader) {
+	defer close(s.requests)
+	br := bufio.NewReader(r)
+
+	for {
+		contents, err := readMessage(br)
+		if err != nil {
+			if err != io.EOF {
+				log.Println("dap:", err)
+			}
+			return
+		}
+
+		var req request
+		if err := json.Unmarshal(contents, &req); err != nil {
+			log.Println("dap:", err)
+			continue
+		}
+
+		select {
+		case s.requests <- req:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// readMessage reads a message's headers and returns its contents.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid content length %q", value)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("message without a content length")
+	}
+
+	contents := make([]byte, length)
+	_, err := io.ReadFull(r, contents)
+	return contents, err
+}
+
+// send sends a response or event to the client.
+func (s *session) send(message interface{}) {
+	contents, err := json.Marshal(message)
+	if err != nil {
+		log.Println("dap:", err)
+		return
+	}
+
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(contents), contents)
+}
+
+// respond sends a successful response to a request.
+func (s *session) respond(req request, body interface{}) {
+	s.seq++
+	s.send(response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true, Body: body})
+}
+
+// fail sends an error response to a request.
+func (s *session) fail(req request, err error) {
+	s.seq++
+	s.send(response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+// sendEvent sends an event to the client.
+func (s *session) sendEvent(name string, body interface{}) {
+	s.seq++
+	s.send(event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// stopped lets the client know a breakpoint, watchpoint or step stopped execution.
+func (s *session) stopped(stop debugger.StopEvent) {
+	body := map[string]interface{}{"threadId": threadID, "allThreadsStopped": true}
+
+	switch stop.Reason {
+	case debugger.StopBreakpoint:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.ID}
+	case debugger.StopWatchpoint:
+		body["reason"] = "data breakpoint"
+		body["description"] = fmt.Sprintf("Watchpoint %d hit at %04X", stop.ID, stop.Address)
+	default:
+		body["reason"] = "step"
+	}
+
+	s.sendEvent("stopped", body)
+}
+
+// handle handles a request. It returns true when the session is over.
+func (s *session) handle(req request) bool {
+	handlers := map[string]func(req request) (interface{}, error){
+		"initialize":                s.initialize,
+		"launch":                    s.launch,
+		"attach":                    s.launch,
+		"configurationDone":         s.configurationDone,
+		"setBreakpoints":            s.setBreakpoints,
+		"setInstructionBreakpoints": s.setInstructionBreakpoints,
+		"setExceptionBreakpoints":   s.setExceptionBreakpoints,
+		"threads":                   s.threads,
+		"stackTrace":                s.stackTrace,
+		"scopes":                    s.scopes,
+		"variables":                 s.variables,
+		"setVariable":               s.setVariable,
+		"evaluate":                  s.evaluate,
+		"continue":                  s.cont,
+		"next":                      s.next,
+		"stepIn":                    s.stepIn,
+		"stepOut":                   s.stepOut,
+		"pause":                     s.pause,
+		"stepBack":                  s.stepBack,
+		"reverseContinue":           s.reverseContinue,
+	}
+
+	switch req.Command {
+	case "disconnect", "terminate":
+		var args struct {
+			TerminateDebuggee bool `json:"terminateDebuggee"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+
+		s.respond(req, nil)
+		s.disconnect(args.TerminateDebuggee || req.Command == "terminate")
+		return true
+	}
+
+	handler, ok := handlers[req.Command]
+	if !ok {
+		s.fail(req, fmt.Errorf("%s isn't supported", req.Command))
+		return false
+	}
+
+	body, err := handler(req)
+	if err != nil {
+		s.fail(req, err)
+		return false
+	}
+	s.respond(req, body)
+
+	// events that have to come after the response
+	switch req.Command {
+	case "initialize":
+		s.sendEvent("initialized", nil)
+	case "configurationDone":
+		if s.stopOnEntry {
+			s.run(func() { s.debugger.Pause() })
+			s.sendEvent("stopped", map[string]interface{}{"reason": "entry", "threadId": threadID, "allThreadsStopped": true})
+		}
+	case "stepIn":
+		s.sendEvent("stopped", map[string]interface{}{"reason": "step", "threadId": threadID, "allThreadsStopped": true})
+	case "pause":
+		s.sendEvent("stopped", map[string]interface{}{"reason": "pause", "threadId": threadID, "allThreadsStopped": true})
+	case "stepBack", "reverseContinue":
+		s.sendEvent("stopped", map[string]interface{}{"reason": s.reverseReason, "threadId": threadID, "allThreadsStopped": true})
+	}
+
+	return false
+}
+
+// disconnect removes the client's breakpoints and lets execution continue, or stops the emulator if terminate
+// is set.
+func (s *session) disconnect(terminate bool) {
+	s.run(func() {
+		for _, ids := range s.breakpoints {
+			for _, id := range ids {
+				s.debugger.RemoveBreakpoint(id)
+			}
+		}
+		for _, id := range s.instructionBreakpoints {
+			s.debugger.RemoveBreakpoint(id)
+		}
+		s.debugger.Continue()
+	})
+
+	if terminate && s.terminate != nil {
+		s.terminate()
+	}
+}
+
+func (s *session) initialize(req request) (interface{}, error) {
+	return map[string]interface{}{
+		"supportsConfigurationDoneRequest": true,
+		"supportsSetVariable":              true,
+		"supportsInstructionBreakpoints":   true,
+		"supportsTerminateRequest":         true,
+		"supportsEvaluateForHovers":        true,
+		"supportsStepBack":                 true,
+	}, nil
+}
+
+func (s *session) launch(req request) (interface{}, error) {
+	var args struct {
+		StopOnEntry     bool   `json:"stopOnEntry"`
+		SourceDirectory string `json:"sourceDirectory"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	s.stopOnEntry = args.StopOnEntry
+	if args.SourceDirectory != "" {
+		if err := s.sources.scan(args.SourceDirectory); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (s *session) configurationDone(req request) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *session) setExceptionBreakpoints(req request) (interface{}, error) {
+	return map[string]interface{}{"breakpoints": []interface{}{}}, nil
+}
+
+func (s *session) threads(req request) (interface{}, error) {
+	return map[string]interface{}{"threads": []map[string]interface{}{{"id": threadID, "name": "CPU"}}}, nil
+}
+
+func (s *session) pause(req request) (interface{}, error) {
+	var err error
+	s.run(func() { err = s.debugger.Pause() })
+	return nil, err
+}
+
+func (s *session) cont(req request) (interface{}, error) {
+	s.run(func() {
+		// forget anything that stopped execution before now
+		select {
+		case <-s.stops:
+		default:
+		}
+		s.debugger.Continue()
+	})
+	return map[string]interface{}{"allThreadsContinued": true}, nil
+}
+
+func (s *session) next(req request) (interface{}, error) {
+	var err error
+	s.run(func() { err = s.debugger.StepOver() })
+	return nil, err
+}
+
+func (s *session) stepIn(req request) (interface{}, error) {
+	var err error
+	s.run(func() { err = s.debugger.StepInstruction() })
+	return nil, err
+}
+
+func (s *session) stepOut(req request) (interface{}, error) {
+	var err error
+	s.run(func() { err = s.debugger.StepOut() })
+	return nil, err
+}
+
+// stepBack goes back before the last instruction executed. Running backwards needs rewinding to be on.
+func (s *session) stepBack(req request) (interface{}, error) {
+	var err error
+	s.run(func() { err = s.debugger.ReverseStep() })
+	s.reverseReason = "step"
+	return nil, err
+}
+
+// reverseContinue runs backwards to the last breakpoint or watchpoint hit, or to the oldest saved state.
+func (s *session) reverseContinue(req request) (interface{}, error) {
+	var found bool
+	var err error
+	s.run(func() { found, err = s.debugger.ReverseContinue() })
+
+	s.reverseReason = "breakpoint"
+	if !found {
+		s.reverseReason = "pause"
+	}
+	return nil, err
+}
+
+func (s *session) evaluate(req request) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	// the debug console takes the same commands as the terminal console
+	if args.Context == "repl" {
+		var output string
+		s.run(func() { output = s.debugger.Execute(args.Expression) })
+		return map[string]interface{}{"result": strings.TrimRight(output, "\n"), "variablesReference": 0}, nil
+	}
+
+	var value int
+	var err error
+	s.run(func() {
+		value, err = s.debugger.Evaluate(args.Expression)
+
+		// labels evaluate to their address
+		if table := s.debugger.Symbols(); err != nil && table != nil {
+			if sym, ok := table.Lookup(args.Expression); ok {
+				value, err = int(sym.Address), nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"result": formatValue(value), "variablesReference": 0}, nil
+}
+
+// formatValue formats a value as decimal and hex, like "63 ($3F)".
+func formatValue(value int) string {
+	return fmt.Sprintf("%d ($%X)", value, value)
+}
@@ -0,0 +1,222 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger/debugtest"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"net"
+	"testing"
+	"time"
+)
+
+// client is just enough of a debug adapter client to test the server
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+	events []map[string]interface{}
+}
+
+// message is a response or event from the server
+type message struct {
+	Type       string                 `json:"type"`
+	Event      string                 `json:"event"`
+	RequestSeq int                    `json:"request_seq"`
+	Success    bool                   `json:"success"`
+	Message    string                 `json:"message"`
+	Body       map[string]interface{} `json:"body"`
+}
+
+func startSession(t *testing.T) (*client, *debugtest.System) {
+	sys := debugtest.New(0x0150)
+	copy(sys.Memory[0x0150:], []byte{0x3C, 0xCD, 0x00, 0x02})
+	copy(sys.Memory[0x0200:], []byte{0x3C, 0xC9})
+
+	table, err := symbols.Load("testdata/game.sym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Debugger.AttachSymbols(table)
+	sys.Start()
+
+	serverConn, clientConn := net.Pipe()
+	go NewServer(sys.Debugger, sys.RunCommand, nil).Serve(serverConn, serverConn)
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}, sys
+}
+
+// read reads the next message from the server
+func (c *client) read() message {
+	contents, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var msg message
+	if err := json.Unmarshal(contents, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// request sends a request and returns the body of the response. Events that come first are kept for event.
+func (c *client) request(command string, args interface{}) map[string]interface{} {
+	c.seq++
+	contents, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(contents), contents)
+
+	for {
+		msg := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, map[string]interface{}{"event": msg.Event, "body": msg.Body})
+			continue
+		}
+
+		if msg.RequestSeq != c.seq || !msg.Success {
+			c.t.Fatalf("Expected %s to succeed, but got %+v", command, msg)
+		}
+		return msg.Body
+	}
+}
+
+// event waits for an event and returns its body
+func (c *client) event(name string) map[string]interface{} {
+	for len(c.events) == 0 {
+		msg := c.read()
+		c.events = append(c.events, map[string]interface{}{"event": msg.Event, "body": msg.Body})
+	}
+
+	next := c.events[0]
+	c.events = c.events[1:]
+	if next["event"] != name {
+		c.t.Fatalf("Expected a %s event, but got %v", name, next)
+	}
+
+	body, _ := next["body"].(map[string]interface{})
+	return body
+}
+
+// expectStop waits for a stopped event and checks its reason
+func (c *client) expectStop(reason string) map[string]interface{} {
+	body := c.event("stopped")
+	if body["reason"] != reason {
+		c.t.Errorf("Expected execution to stop for %s, but was %v", reason, body["reason"])
+	}
+	return body
+}
+
+// evaluate evaluates an expression
+func (c *client) evaluate(expression string) string {
+	body := c.request("evaluate", map[string]interface{}{"expression": expression, "context": "watch"})
+	return body["result"].(string)
+}
+
+func TestSession(t *testing.T) {
+	c, sys := startSession(t)
+	defer sys.Stop()
+
+	capabilities := c.request("initialize", map[string]interface{}{"adapterID": "gmboy"})
+	if capabilities["supportsConfigurationDoneRequest"] != true {
+		t.Error("Expected configurationDone to be supported")
+	}
+	c.event("initialized")
+
+	c.request("launch", map[string]interface{}{"stopOnEntry": true, "sourceDirectory": "testdata"})
+
+	// only lines with a label can have breakpoints
+	body := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": "testdata/game.asm"},
+		"breakpoints": []map[string]interface{}{{"line": 9}, {"line": 4}},
+	})
+	breakpoints := body["breakpoints"].([]interface{})
+	if breakpoints[0].(map[string]interface{})["verified"] != true || breakpoints[1].(map[string]interface{})["verified"] != false {
+		t.Errorf("Expected only the breakpoint on Update to be verified, but got %v", breakpoints)
+	}
+
+	c.request("configurationDone", nil)
+	c.expectStop("entry")
+
+	c.request("continue", map[string]interface{}{"threadId": threadID})
+	c.expectStop("breakpoint")
+
+	body = c.request("stackTrace", map[string]interface{}{"threadId": threadID})
+	frames := body["stackFrames"].([]interface{})
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 stack frames but got %v", frames)
+	}
+	for i, expected := range []struct {
+		name string
+		line float64
+	}{{"00:0200 Update", 9}, {"00:0151 Main+$1", 3}} {
+		frame := frames[i].(map[string]interface{})
+		if frame["name"] != expected.name || frame["line"] != expected.line {
+			t.Errorf("Expected frame %d to be %s on line %v but was %v", i, expected.name, expected.line, frame)
+		}
+	}
+
+	body = c.request("variables", map[string]interface{}{"variablesReference": registersReference})
+	a := body["variables"].([]interface{})[0].(map[string]interface{})
+	if a["name"] != "A" || a["value"] != "$01" {
+		t.Errorf("Expected A to be $01 but was %v", a)
+	}
+
+	body = c.request("setVariable", map[string]interface{}{"variablesReference": registersReference, "name": "A", "value": "$3F"})
+	if body["value"] != "$3F" {
+		t.Errorf("Expected A to be set to $3F but was %v", body["value"])
+	}
+
+	c.request("stepIn", map[string]interface{}{"threadId": threadID})
+	c.expectStop("step")
+	if result := c.evaluate("A"); result != "64 ($40)" {
+		t.Errorf("Expected stepping to increment A, but A was %s", result)
+	}
+
+	c.request("stepOut", map[string]interface{}{"threadId": threadID})
+	c.expectStop("step")
+	if result := c.evaluate("PC"); result != "340 ($154)" {
+		t.Errorf("Expected stepping out to return to Main.loop, but PC was %s", result)
+	}
+	if result := c.evaluate("Main.loop"); result != "340 ($154)" {
+		t.Errorf("Expected a label to evaluate to its address, but was %s", result)
+	}
+
+	body = c.request("variables", map[string]interface{}{"variablesReference": regionReference + 4, "start": 1, "count": 1})
+	row := body["variables"].([]interface{})[0].(map[string]interface{})
+	if row["name"] != "C010" || row["value"] != "00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00" {
+		t.Errorf("Expected the second row of WRAM but was %v", row)
+	}
+
+	c.request("disconnect", nil)
+	time.Sleep(10 * time.Millisecond)
+	var active bool
+	sys.RunCommand(func() { active = sys.Debugger.BreakpointActive })
+	if active {
+		t.Error("Expected execution to continue after disconnecting")
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"Main:", "Main"},
+		{"Main:: ; exported", "Main"},
+		{".loop", "Main.loop"},
+		{"\t.loop: jr .loop", "Main.loop"},
+		{"Other.skip:", "Other.skip"},
+		{"\tld a, b", ""},
+		{"DEF SPEED EQU 3", ""},
+		{"; Main:", ""},
+	}
+
+	for _, test := range tests {
+		if label := parseLabel(test.line, "Main"); label != test.expected {
+			t.Errorf("Expected %q to define %q but was %q", test.line, test.expected, label)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package dap
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourceExtensions are the assembly source files scanned for labels
+var sourceExtensions = map[string]bool{".asm": true, ".inc": true, ".s": true, ".z80": true, ".sm83": true}
+
+// sourceLine is a line in a source file.
+type sourceLine struct {
+	path string
+	line int
+}
+
+// sourceIndex maps the labels defined in RGBDS assembly files to the lines they're on. RGBDS doesn't write line
+// numbers to its symbol files, so source lines are matched up with addresses through the labels defined on them.
+type sourceIndex struct {
+	// labels by file and line number
+	labels map[string]map[int]string
+
+	// definitions are where each label is defined
+	definitions map[string]sourceLine
+}
+
+func newSourceIndex() *sourceIndex {
+	return &sourceIndex{labels: make(map[string]map[int]string), definitions: make(map[string]sourceLine)}
+}
+
+// scan loads every source file under dir.
+func (x *sourceIndex) scan(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && sourceExtensions[strings.ToLower(filepath.Ext(path))] {
+			return x.load(path)
+		}
+		return nil
+	})
+}
+
+// load finds the labels in a source file. Loading a file again replaces what was found before.
+func (x *sourceIndex) load(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	labels := make(map[int]string)
+	global := ""
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		label := parseLabel(scanner.Text(), global)
+		if label == "" {
+			continue
+		}
+
+		if !strings.Contains(label, ".") {
+			global = label
+		}
+		labels[line] = label
+		if _, exists := x.definitions[label]; !exists {
+			x.definitions[label] = sourceLine{path: path, line: line}
+		}
+	}
+
+	x.labels[path] = labels
+	return scanner.Err()
+}
+
+// label returns the label defined on a line, loading the file if it hasn't been.
+func (x *sourceIndex) label(path string, line int) (string, bool) {
+	path, _ = filepath.Abs(path)
+	if _, loaded := x.labels[path]; !loaded {
+		if err := x.load(path); err != nil {
+			return "", false
+		}
+	}
+
+	label, ok := x.labels[path][line]
+	return label, ok
+}
+
+// definition returns where a label is defined.
+func (x *sourceIndex) definition(label string) (sourceLine, bool) {
+	def, ok := x.definitions[label]
+	return def, ok
+}
+
+// parseLabel returns the label defined on a line of RGBDS assembly, or an empty string if there isn't one. Global
+// labels start in the first column and end with a colon. Local labels start with a dot and are returned qualified
+// by the global label before them, like "Main.loop".
+func parseLabel(line, global string) string {
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = line[:i]
+	}
+
+	trimmed := strings.TrimLeft(line, " \t")
+	if strings.HasPrefix(trimmed, ".") {
+		name := identifier(trimmed[1:])
+		if name == "" || global == "" {
+			return ""
+		}
+		return global + "." + name
+	}
+
+	name := identifier(line)
+	if name == "" || !strings.HasPrefix(line[len(name):], ":") {
+		return ""
+	}
+	return name
+}
+
+// identifier returns the label name at the start of s.
+func identifier(s string) string {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		isLetter := (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_'
+		isDigit := ch >= '0' && ch <= '9'
+		if !isLetter && !(i > 0 && (isDigit || ch == '.' || ch == '#' || ch == '@')) {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,11 @@
+SECTION "Main", ROM0[$150]
+
+Main:
+	inc a
+	call Update
+.loop:
+	jr .loop
+
+Update: ; called once a frame
+	inc a
+	ret
@@ -0,0 +1,220 @@
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"strings"
+)
+
+// The variables references of the scopes. Memory regions are memoryReference plus the region's index.
+const (
+	registersReference = 1
+	ioReference        = 2
+	memoryReference    = 3
+	regionReference    = 100
+)
+
+// bytesPerRow is how many bytes of memory each variable in a memory region shows
+const bytesPerRow = 16
+
+// registerVariables are the CPU registers in the order they're shown
+var registerVariables = []struct {
+	name string
+	reg  expr.Register
+	word bool
+}{
+	{"A", expr.RegA, false}, {"F", expr.RegF, false}, {"B", expr.RegB, false}, {"C", expr.RegC, false},
+	{"D", expr.RegD, false}, {"E", expr.RegE, false}, {"H", expr.RegH, false}, {"L", expr.RegL, false},
+	{"AF", expr.RegAF, true}, {"BC", expr.RegBC, true}, {"DE", expr.RegDE, true}, {"HL", expr.RegHL, true},
+	{"SP", expr.RegSP, true}, {"PC", expr.RegPC, true},
+}
+
+// ioVariables are the I/O registers in the order they're shown
+var ioVariables = []struct {
+	name    string
+	address uint16
+}{
+	{"P1", 0xFF00}, {"SB", 0xFF01}, {"SC", 0xFF02}, {"DIV", 0xFF04}, {"TIMA", 0xFF05}, {"TMA", 0xFF06},
+	{"TAC", 0xFF07}, {"IF", 0xFF0F}, {"LCDC", 0xFF40}, {"STAT", 0xFF41}, {"SCY", 0xFF42}, {"SCX", 0xFF43},
+	{"LY", 0xFF44}, {"LYC", 0xFF45}, {"DMA", 0xFF46}, {"BGP", 0xFF47}, {"OBP0", 0xFF48}, {"OBP1", 0xFF49},
+	{"WY", 0xFF4A}, {"WX", 0xFF4B}, {"IE", 0xFFFF},
+}
+
+// memoryRegions are the areas of the memory map, each shown as rows of bytes
+var memoryRegions = []struct {
+	name     string
+	from, to int
+}{
+	{"ROM0", 0x0000, 0x3FFF}, {"ROMX", 0x4000, 0x7FFF}, {"VRAM", 0x8000, 0x9FFF}, {"SRAM", 0xA000, 0xBFFF},
+	{"WRAM", 0xC000, 0xDFFF}, {"OAM", 0xFE00, 0xFE9F}, {"I/O", 0xFF00, 0xFF7F}, {"HRAM", 0xFF80, 0xFFFE},
+}
+
+// scopes are the same for every frame, since there's only one set of registers and memory.
+func (s *session) scopes(req request) (interface{}, error) {
+	return map[string]interface{}{"scopes": []map[string]interface{}{
+		{"name": "Registers", "presentationHint": "registers", "variablesReference": registersReference},
+		{"name": "I/O", "variablesReference": ioReference, "namedVariables": len(ioVariables)},
+		{"name": "Memory", "variablesReference": memoryReference, "namedVariables": len(memoryRegions), "expensive": true},
+	}}, nil
+}
+
+func (s *session) variables(req request) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+		Start              int `json:"start"`
+		Count              int `json:"count"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	var variables []map[string]interface{}
+	var err error
+	s.run(func() {
+		switch ref := args.VariablesReference; {
+		case ref == registersReference:
+			variables, err = s.registerVariables()
+		case ref == ioReference:
+			variables, err = s.ioVariables()
+		case ref == memoryReference:
+			for i, region := range memoryRegions {
+				variables = append(variables, map[string]interface{}{
+					"name":               region.name,
+					"value":              fmt.Sprintf("%04X-%04X", region.from, region.to),
+					"variablesReference": regionReference + i,
+					"indexedVariables":   (region.to - region.from + bytesPerRow) / bytesPerRow,
+				})
+			}
+		case ref >= regionReference && ref < regionReference+len(memoryRegions):
+			variables, err = s.memoryRows(ref-regionReference, args.Start, args.Count)
+		default:
+			err = fmt.Errorf("unknown variables reference %d", ref)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+func (s *session) registerVariables() ([]map[string]interface{}, error) {
+	var variables []map[string]interface{}
+	for _, reg := range registerVariables {
+		value, err := s.debugger.ReadRegister(reg.reg)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, variable(reg.name, formatRegister(value, reg.word)))
+	}
+
+	f, _ := s.debugger.ReadRegister(expr.RegF)
+	flags := []byte("----")
+	for i, name := range "ZNHC" {
+		if f&(0x80>>uint(i)) != 0 {
+			flags[i] = byte(name)
+		}
+	}
+	return append(variables, variable("flags", string(flags))), nil
+}
+
+func (s *session) ioVariables() ([]map[string]interface{}, error) {
+	var variables []map[string]interface{}
+	for _, io := range ioVariables {
+		value, err := s.debugger.ReadMemory(io.address, 1)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, variable(io.name, formatRegister(int(value[0]), false)))
+	}
+	return variables, nil
+}
+
+// memoryRows returns count rows of a memory region starting at row start. A count of 0 returns every row.
+func (s *session) memoryRows(region, start, count int) ([]map[string]interface{}, error) {
+	from, to := memoryRegions[region].from, memoryRegions[region].to
+	rows := (to - from + bytesPerRow) / bytesPerRow
+	if count == 0 || start+count > rows {
+		count = rows - start
+	}
+
+	var variables []map[string]interface{}
+	for row := start; row < start+count; row++ {
+		address := from + row*bytesPerRow
+		length := bytesPerRow
+		if address+length > to+1 {
+			length = to + 1 - address
+		}
+
+		contents, err := s.debugger.ReadMemory(uint16(address), length)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, variable(fmt.Sprintf("%04X", address), strings.ToUpper(fmt.Sprintf("% x", contents))))
+	}
+	return variables, nil
+}
+
+// setVariable changes a register or I/O register. The value can be any expression, like "$3F" or "HL + 1".
+func (s *session) setVariable(req request) (interface{}, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	var result string
+	var err error
+	s.run(func() {
+		var value int
+		if value, err = s.debugger.Evaluate(args.Value); err != nil {
+			return
+		}
+
+		switch args.VariablesReference {
+		case registersReference:
+			for _, reg := range registerVariables {
+				if reg.name == args.Name {
+					if err = s.debugger.WriteRegister(reg.reg, value); err == nil {
+						value, err = s.debugger.ReadRegister(reg.reg)
+						result = formatRegister(value, reg.word)
+					}
+					return
+				}
+			}
+		case ioReference:
+			for _, io := range ioVariables {
+				if io.name == args.Name {
+					if err = s.debugger.WriteMemory(io.address, []byte{byte(value)}); err == nil {
+						contents, _ := s.debugger.ReadMemory(io.address, 1)
+						result = formatRegister(int(contents[0]), false)
+					}
+					return
+				}
+			}
+		}
+		err = fmt.Errorf("%s can't be changed", args.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"value": result}, nil
+}
+
+// variable is a variable without children.
+func variable(name, value string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "value": value, "variablesReference": 0}
+}
+
+// formatRegister formats an 8 or 16-bit register value as hex.
+func formatRegister(value int, word bool) string {
+	if word {
+		return fmt.Sprintf("$%04X", value)
+	}
+	return fmt.Sprintf("$%02X", value)
+}
@@ -0,0 +1,437 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"log"
+	"strings"
+)
+
+// The kinds of memory access a watchpoint can watch for.
+const (
+	WatchRead = 1 << iota
+	WatchWrite
+)
+
+// Breakpoint stops execution right before the instruction at Address is executed.
+type Breakpoint struct {
+	ID      int
+	Address uint16
+
+	// Bank is the ROM bank the address must be in, or -1 for any bank
+	Bank int
+
+	// Condition has to be true for the breakpoint to be hit. Breakpoints without an address only have a condition,
+	// and are hit when it changes from false to true.
+	Condition *expr.Expr
+
+	// condition-only breakpoints are checked on every instruction. met is whether the condition was true the
+	// last time it was checked.
+	anyAddress bool
+	met        bool
+}
+
+// The reasons execution can stop, passed to the functions added with OnStop.
+const (
+	StopBreakpoint = "breakpoint"
+	StopWatchpoint = "watchpoint"
+	StopTarget     = "target"
+)
+
+// StopEvent describes why execution stopped.
+type StopEvent struct {
+	// Reason is StopBreakpoint, StopWatchpoint or StopTarget
+	Reason string
+
+	// ID is the id of the breakpoint or watchpoint that was hit
+	ID int
+
+	// Address is where execution stopped, or the address that was accessed when a watchpoint was hit
+	Address uint16
+	Bank    int
+
+	// Access is the kind of access that hit a watchpoint, WatchRead or WatchWrite
+	Access int
+}
+
+// Watchpoint stops execution after an instruction reads or writes Address.
+type Watchpoint struct {
+	ID      int
+	Address uint16
+	Access  int
+}
+
+// AddBreakpoint adds a breakpoint at an address and returns its id. Pass -1 as the bank to break on the address
+// no matter which ROM bank is mapped in.
+func (d *Debugger) AddBreakpoint(address uint16, bank int) int {
+	d.nextID++
+	d.breakpoints[address] = append(d.breakpoints[address], &Breakpoint{ID: d.nextID, Address: address, Bank: bank})
+	return d.nextID
+}
+
+// AddConditionalBreakpoint adds a breakpoint at an address that's only hit when condition is true, and returns its id.
+func (d *Debugger) AddConditionalBreakpoint(address uint16, bank int, condition string) (int, error) {
+	cond, err := expr.Parse(condition)
+	if err != nil {
+		return 0, err
+	}
+
+	d.nextID++
+	d.breakpoints[address] = append(d.breakpoints[address], &Breakpoint{ID: d.nextID, Address: address, Bank: bank, Condition: cond})
+	return d.nextID, nil
+}
+
+// BreakWhen adds a breakpoint that's hit whenever condition changes from false to true, no matter which instruction
+// is running, and returns its id.
+func (d *Debugger) BreakWhen(condition string) (int, error) {
+	cond, err := expr.Parse(condition)
+	if err != nil {
+		return 0, err
+	}
+
+	d.nextID++
+	d.conditions = append(d.conditions, &Breakpoint{ID: d.nextID, Bank: -1, Condition: cond, anyAddress: true})
+	return d.nextID, nil
+}
+
+// AddBreakpointSpec adds a breakpoint written the way it is on the command line or in a console and returns its id:
+//
+//	0150                 break at 0150 in any bank
+//	02:4000              break at 4000 in ROM bank 2
+//	0150 if A == $3F     break at 0150 when A is $3F
+//	if LY == 144         break whenever LY becomes 144
+//	Main.loop            break at a label from the symbol file
+//
+// Addresses and banks are hex.
+func (d *Debugger) AddBreakpointSpec(spec string) (int, error) {
+	spec = strings.TrimSpace(spec)
+
+	location, condition := spec, ""
+	if strings.HasPrefix(spec, "if ") {
+		return d.BreakWhen(spec[3:])
+	}
+	if i := strings.Index(spec, " if "); i >= 0 {
+		location, condition = spec[:i], spec[i+4:]
+	}
+
+	bank, address, err := d.ParseLocation(location)
+	if err != nil {
+		return 0, err
+	}
+
+	if condition == "" {
+		return d.AddBreakpoint(address, bank), nil
+	}
+	return d.AddConditionalBreakpoint(address, bank, condition)
+}
+
+// Evaluate evaluates an expression against the current state of the system.
+func (d *Debugger) Evaluate(source string) (int, error) {
+	e, err := expr.Parse(source)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.env == nil {
+		return 0, fmt.Errorf("nothing is attached to evaluate %s against", source)
+	}
+	return e.Eval(d.env), nil
+}
+
+// AttachEnv sets what conditions are evaluated against. It's called by the CPU when the debugger is attached.
+func (d *Debugger) AttachEnv(env expr.Env) {
+	d.env = env
+}
+
+// AddWatchpoint adds a watchpoint for the given kinds of access to an address and returns its id.
+func (d *Debugger) AddWatchpoint(address uint16, access int) int {
+	d.nextID++
+	d.watchpoints[address] = append(d.watchpoints[address], &Watchpoint{ID: d.nextID, Address: address, Access: access})
+	return d.nextID
+}
+
+// RemoveBreakpoint removes the breakpoint or watchpoint with the given id. It returns false if there isn't one.
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	for address, bps := range d.breakpoints {
+		for i, bp := range bps {
+			if bp.ID == id {
+				d.breakpoints[address] = append(bps[:i], bps[i+1:]...)
+				if len(d.breakpoints[address]) == 0 {
+					delete(d.breakpoints, address)
+				}
+				return true
+			}
+		}
+	}
+
+	for i, bp := range d.conditions {
+		if bp.ID == id {
+			d.conditions = append(d.conditions[:i], d.conditions[i+1:]...)
+			return true
+		}
+	}
+
+	for address, wps := range d.watchpoints {
+		for i, wp := range wps {
+			if wp.ID == id {
+				d.watchpoints[address] = append(wps[:i], wps[i+1:]...)
+				if len(d.watchpoints[address]) == 0 {
+					delete(d.watchpoints, address)
+				}
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CheckBreakpoint is called by the CPU before it executes the instruction at address. It returns true if a
+// breakpoint was hit, in which case execution is stopped and the instruction shouldn't be executed yet.
+func (d *Debugger) CheckBreakpoint(address uint16, bank int) bool {
+	if len(d.breakpoints) == 0 && len(d.conditions) == 0 && d.target == nil {
+		return false
+	}
+
+	// conditions are kept up to date even while stopped, so stepping past the point where one becomes true
+	// doesn't hit it as soon as execution continues
+	triggered := d.updateConditions()
+
+	if d.replaying {
+		if triggered != nil || d.breakpointAt(address, bank) != nil {
+			d.replayHit(false)
+		}
+		return false
+	}
+
+	// while stopped every instruction is stepped through by hand, so there's nothing to stop for
+	if d.BreakpointActive {
+		return false
+	}
+
+	if d.target != nil && d.checkTarget(address) {
+		d.stopAtTarget(address, bank)
+		return true
+	}
+
+	// when resuming from a breakpoint the instruction it stopped on needs to be executed
+	if d.resuming {
+		d.resuming = false
+		if address == d.resumeAddress {
+			return false
+		}
+	}
+
+	if bp := d.breakpointAt(address, bank); bp != nil {
+		d.hitBreakpoint(bp, address, bank)
+		return true
+	}
+
+	if triggered != nil {
+		d.hitBreakpoint(triggered, address, bank)
+		return true
+	}
+
+	return false
+}
+
+// breakpointAt returns the breakpoint at an address that should be hit, if there is one.
+func (d *Debugger) breakpointAt(address uint16, bank int) *Breakpoint {
+	for _, bp := range d.breakpoints[address] {
+		if (bp.Bank == -1 || bp.Bank == bank) && d.conditionTrue(bp) {
+			return bp
+		}
+	}
+	return nil
+}
+
+// updateConditions evaluates the breakpoints that only have a condition and returns the first one that changed
+// from false to true.
+func (d *Debugger) updateConditions() *Breakpoint {
+	if d.env == nil {
+		return nil
+	}
+
+	var triggered *Breakpoint
+	for _, bp := range d.conditions {
+		met := bp.Condition.True(d.env)
+		if met && !bp.met && triggered == nil {
+			triggered = bp
+		}
+		bp.met = met
+	}
+
+	return triggered
+}
+
+// conditionTrue returns true if a breakpoint doesn't have a condition or its condition is true.
+func (d *Debugger) conditionTrue(bp *Breakpoint) bool {
+	if bp.Condition == nil {
+		return true
+	}
+	return d.env != nil && bp.Condition.True(d.env)
+}
+
+// hitBreakpoint stops execution before the instruction at address and lets the javascript debugger know.
+func (d *Debugger) hitBreakpoint(bp *Breakpoint, address uint16, bank int) {
+	event := map[string]interface{}{"id": bp.ID, "address": address, "bank": bank}
+	if bp.Condition != nil {
+		event["condition"] = bp.Condition.String()
+		log.Printf("Breakpoint %d (%s) reached at %s\n", bp.ID, bp.Condition, d.Describe(bank, address))
+	} else {
+		log.Printf("Breakpoint %d reached at %s\n", bp.ID, d.Describe(bank, address))
+	}
+
+	d.stop(address, true)
+	d.notifyStop(StopEvent{Reason: StopBreakpoint, ID: bp.ID, Address: address, Bank: bank})
+	d.RunCallbacks("breakpoint", event)
+}
+
+// CheckWatchpoint is called by the MMU for every read and write. When a watchpoint is hit execution stops once
+// the current instruction finishes.
+func (d *Debugger) CheckWatchpoint(address uint16, access int, value byte) {
+	if len(d.watchpoints) == 0 {
+		return
+	}
+
+	for _, wp := range d.watchpoints[address] {
+		if wp.Access&access == 0 {
+			continue
+		}
+
+		if d.replaying {
+			d.replayHit(true)
+			continue
+		}
+
+		accessName := "r"
+		if access == WatchWrite {
+			accessName = "w"
+		}
+
+		log.Printf("Watchpoint %d hit: %s %04X = %02X\n", wp.ID, accessName, address, value)
+		d.stop(0, false)
+		d.notifyStop(StopEvent{Reason: StopWatchpoint, ID: wp.ID, Address: address, Bank: -1, Access: access})
+		d.RunCallbacks("watchpoint", map[string]interface{}{"id": wp.ID, "address": address, "access": accessName, "value": value})
+	}
+}
+
+// stop stops execution so it can be stepped through. atBreakpoint is set when stopping before the
+// instruction at address is executed. Stopping for any reason cancels a step over, step out or run to.
+func (d *Debugger) stop(address uint16, atBreakpoint bool) {
+	d.target = nil
+	d.BreakpointActive = true
+	d.resumeAddress = address
+	d.stoppedAtBreakpoint = atBreakpoint
+}
+
+// OnStop adds a function that's called whenever a breakpoint, a watchpoint or the target of a step over, step out
+// or run to stops execution. Stopping on request, like pausing or stepping, isn't reported. fn is called from the
+// goroutine running the system.
+func (d *Debugger) OnStop(fn func(event StopEvent)) {
+	d.stopListeners = append(d.stopListeners, fn)
+}
+
+// notifyStop lets the functions added with OnStop know execution stopped.
+func (d *Debugger) notifyStop(event StopEvent) {
+	for _, fn := range d.stopListeners {
+		fn(event)
+	}
+}
+
+// Resume is called by the system when execution continues after being stopped.
+func (d *Debugger) Resume() {
+	d.BreakpointActive = false
+	d.resuming = d.stoppedAtBreakpoint
+	d.stoppedAtBreakpoint = false
+}
+
+// attachBreakpointFunctions adds the breakpoint and watchpoint functions to the javascript vm
+func (d *Debugger) attachBreakpointFunctions() {
+	// addBreakpoint(address, [bank], [condition]) returns the id of the new breakpoint
+	d.vm.Set("addBreakpoint", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+
+		bank := int64(-1)
+		if call.Argument(1).IsDefined() && !call.Argument(1).IsNull() {
+			bank, _ = call.Argument(1).ToInteger()
+		}
+
+		id := 0
+		if call.Argument(2).IsDefined() {
+			condition, _ := call.Argument(2).ToString()
+
+			var err error
+			if id, err = d.AddConditionalBreakpoint(uint16(address), int(bank), condition); err != nil {
+				log.Println("Invalid breakpoint condition:", err)
+				return otto.Value{}
+			}
+		} else {
+			id = d.AddBreakpoint(uint16(address), int(bank))
+		}
+
+		val, _ := call.Otto.ToValue(id)
+		return val
+	})
+
+	// breakWhen(condition) returns the id of a new breakpoint that's hit whenever condition becomes true
+	d.vm.Set("breakWhen", func(call otto.FunctionCall) otto.Value {
+		condition, _ := call.Argument(0).ToString()
+
+		id, err := d.BreakWhen(condition)
+		if err != nil {
+			log.Println("Invalid breakpoint condition:", err)
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(id)
+		return val
+	})
+
+	// evaluate(expression) returns the value of a breakpoint condition expression
+	d.vm.Set("evaluate", func(call otto.FunctionCall) otto.Value {
+		source, _ := call.Argument(0).ToString()
+
+		result, err := d.Evaluate(source)
+		if err != nil {
+			log.Println(err)
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(result)
+		return val
+	})
+
+	// addWatchpoint(address, 'r'|'w'|'rw') returns the id of the new watchpoint
+	d.vm.Set("addWatchpoint", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+
+		mode := "rw"
+		if call.Argument(1).IsDefined() {
+			mode, _ = call.Argument(1).ToString()
+		}
+
+		access := 0
+		if strings.Contains(mode, "r") {
+			access |= WatchRead
+		}
+		if strings.Contains(mode, "w") {
+			access |= WatchWrite
+		}
+		if access == 0 {
+			log.Println("Expected the watchpoint mode to be 'r', 'w' or 'rw', but got", mode)
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(d.AddWatchpoint(uint16(address), access))
+		return val
+	})
+
+	// removeBreakpoint(id) removes a breakpoint or watchpoint
+	d.vm.Set("removeBreakpoint", func(call otto.FunctionCall) otto.Value {
+		id, _ := call.Argument(0).ToInteger()
+		val, _ := call.Otto.ToValue(d.RemoveBreakpoint(int(id)))
+		return val
+	})
+}
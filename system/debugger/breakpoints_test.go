@@ -0,0 +1,175 @@
+package debugger
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"testing"
+)
+
+func TestBreakpointHitAndResume(t *testing.T) {
+	d := NewDebugger()
+	d.AddBreakpoint(0x0150, -1)
+
+	if d.CheckBreakpoint(0x0100, 0) {
+		t.Error("Expected no breakpoint at 0x0100")
+	}
+
+	if !d.CheckBreakpoint(0x0150, 0) || !d.BreakpointActive {
+		t.Fatal("Expected the breakpoint at 0x0150 to be hit")
+	}
+
+	// while stopped, stepping doesn't hit breakpoints
+	if d.CheckBreakpoint(0x0150, 0) {
+		t.Error("Expected breakpoints to be ignored while stopped")
+	}
+
+	// after resuming the instruction at the breakpoint runs, but the breakpoint is hit the next time around
+	d.Resume()
+	if d.CheckBreakpoint(0x0150, 0) {
+		t.Error("Expected the instruction at the breakpoint to run after resuming")
+	}
+	if !d.CheckBreakpoint(0x0150, 0) {
+		t.Error("Expected the breakpoint to be hit again")
+	}
+}
+
+func TestBreakpointBank(t *testing.T) {
+	d := NewDebugger()
+	d.AddBreakpoint(0x4000, 2)
+
+	if d.CheckBreakpoint(0x4000, 1) {
+		t.Error("Expected the breakpoint not to be hit in bank 1")
+	}
+	if !d.CheckBreakpoint(0x4000, 2) {
+		t.Error("Expected the breakpoint to be hit in bank 2")
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	d := NewDebugger()
+	id := d.AddWatchpoint(0xC000, WatchWrite)
+
+	d.CheckWatchpoint(0xC000, WatchRead, 0x01)
+	if d.BreakpointActive {
+		t.Error("Expected a write watchpoint not to be hit by a read")
+	}
+
+	d.CheckWatchpoint(0xC000, WatchWrite, 0x01)
+	if !d.BreakpointActive {
+		t.Error("Expected the write watchpoint to be hit")
+	}
+
+	d.Resume()
+	if !d.RemoveBreakpoint(id) {
+		t.Fatal("Expected the watchpoint to be removed")
+	}
+	d.CheckWatchpoint(0xC000, WatchWrite, 0x01)
+	if d.BreakpointActive {
+		t.Error("Expected the removed watchpoint not to be hit")
+	}
+}
+
+func TestJavascriptBreakpointFunctions(t *testing.T) {
+	d := NewDebugger()
+	d.vm.Run(`
+		var hits = [];
+		on('watchpoint', function(hit) { hits.push(hit.access + hit.address); });
+		var id = addBreakpoint(0x150);
+		addWatchpoint(0xFF40, 'rw');
+	`)
+
+	d.CheckWatchpoint(0xFF40, WatchRead, 0x91)
+	hits, _ := d.vm.Run("hits.join(',')")
+	if hits.String() != "r65344" {
+		t.Errorf("Expected the watchpoint callback to run, but got %s", hits.String())
+	}
+
+	removed, _ := d.vm.Run("removeBreakpoint(id)")
+	if ok, _ := removed.ToBoolean(); !ok || len(d.breakpoints) != 0 {
+		t.Error("Expected removeBreakpoint to remove the breakpoint")
+	}
+}
+
+// exprEnv gives conditions a single register and 64K of memory to work with.
+type exprEnv struct {
+	a      int
+	memory [0x10000]byte
+}
+
+func (e *exprEnv) Register(reg expr.Register) int {
+	if reg == expr.RegA {
+		return e.a
+	}
+	return 0
+}
+
+func (e *exprEnv) ReadByte(address uint16) byte {
+	return e.memory[address]
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	d := NewDebugger()
+	env := &exprEnv{}
+	d.AttachEnv(env)
+
+	if _, err := d.AddBreakpointSpec("0150 if A == $3F"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.CheckBreakpoint(0x0150, 0) {
+		t.Error("Expected the breakpoint not to be hit while A isn't $3F")
+	}
+
+	env.a = 0x3F
+	if !d.CheckBreakpoint(0x0150, 0) {
+		t.Error("Expected the breakpoint to be hit once A is $3F")
+	}
+}
+
+func TestBreakWhen(t *testing.T) {
+	d := NewDebugger()
+	env := &exprEnv{}
+	d.AttachEnv(env)
+
+	if _, err := d.AddBreakpointSpec("if LY == 144"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.CheckBreakpoint(0x0100, 0) {
+		t.Error("Expected the condition to be false")
+	}
+
+	env.memory[0xFF44] = 144
+	if !d.CheckBreakpoint(0x0101, 0) {
+		t.Fatal("Expected the breakpoint to be hit when LY becomes 144")
+	}
+
+	// it's only hit again once the condition goes false and back to true
+	d.Resume()
+	if d.CheckBreakpoint(0x0101, 0) || d.CheckBreakpoint(0x0102, 0) {
+		t.Error("Expected the breakpoint not to be hit while LY stays 144")
+	}
+
+	env.memory[0xFF44] = 0
+	d.CheckBreakpoint(0x0103, 0)
+	env.memory[0xFF44] = 144
+	if !d.CheckBreakpoint(0x0104, 0) {
+		t.Error("Expected the breakpoint to be hit when LY becomes 144 again")
+	}
+}
+
+func TestBreakpointSpecs(t *testing.T) {
+	d := NewDebugger()
+
+	if _, err := d.AddBreakpointSpec("02:4000"); err != nil {
+		t.Fatal(err)
+	}
+	if d.CheckBreakpoint(0x4000, 1) || !d.CheckBreakpoint(0x4000, 2) {
+		t.Error("Expected the breakpoint to only be hit in bank 2")
+	}
+
+	for _, spec := range []string{"", "XYZ", "0150 if A ==", "if", "100:4000"} {
+		if _, err := d.AddBreakpointSpec(spec); err == nil {
+			t.Errorf("Expected %q to be an invalid breakpoint", spec)
+		}
+	}
+}
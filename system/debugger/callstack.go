@@ -0,0 +1,101 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robmerrell/gmboy/system/disasm"
+	"strings"
+)
+
+// The ways a frame can be entered.
+const (
+	FrameCall      = "call"
+	FrameRST       = "rst"
+	FrameInterrupt = "interrupt"
+)
+
+// Frame is an entry in the CPU's shadow call stack.
+type Frame struct {
+	// Kind is how the frame was entered: FrameCall, FrameRST or FrameInterrupt
+	Kind string
+
+	// Caller is the address of the call or RST instruction, or of the instruction that was interrupted
+	Caller     uint16
+	CallerBank int
+
+	// Target is the address that was called
+	Target     uint16
+	TargetBank int
+
+	// ReturnAddress was pushed at StackPointer when the frame was entered
+	ReturnAddress uint16
+	StackPointer  uint16
+}
+
+// CallStack is implemented by the CPU, which keeps a shadow call stack so the debugger can show where execution
+// came from and step over and out of calls.
+type CallStack interface {
+	CallDepth() int
+
+	// CallStack returns the frames that haven't returned yet, outermost first
+	CallStack() []Frame
+}
+
+// AttachCallStack sets where the debugger gets the call stack from. It's called by the CPU when the debugger is
+// attached.
+func (d *Debugger) AttachCallStack(calls CallStack) {
+	d.calls = calls
+}
+
+// Frames returns the frames of the call stack that haven't returned yet, outermost first.
+func (d *Debugger) Frames() ([]Frame, error) {
+	if d.calls == nil {
+		return nil, fmt.Errorf("the call stack isn't being tracked")
+	}
+	return d.calls.CallStack(), nil
+}
+
+// Backtrace formats the call stack, innermost frame first, like:
+//
+//	#0  00:0215 in 00:0200
+//	#1  00:0153 in 00:0150 (interrupt)
+//	#2  00:0104
+//
+// Each line is where execution is in that frame followed by the address the frame was entered at. Both are named
+// by label when symbols are loaded.
+func (d *Debugger) Backtrace() (string, error) {
+	if err := d.attached(); err != nil {
+		return "", err
+	}
+	frames, err := d.Frames()
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	pc := d.pc()
+	bank := d.memory.Bank(pc)
+	for i := len(frames) - 1; i >= 0; i-- {
+		frame := frames[i]
+		fmt.Fprintf(&out, "#%-2d %s in %s", len(frames)-1-i, d.Describe(bank, pc), d.describeTarget(frame))
+		if frame.Kind != FrameCall {
+			fmt.Fprintf(&out, " (%s)", frame.Kind)
+		}
+		out.WriteString("\n")
+
+		pc, bank = frame.Caller, frame.CallerBank
+	}
+	fmt.Fprintf(&out, "#%-2d %s\n", len(frames), d.Describe(bank, pc))
+
+	return out.String(), nil
+}
+
+// describeTarget names the address a frame was entered at by its label, or formats the address if it doesn't
+// have one.
+func (d *Debugger) describeTarget(frame Frame) string {
+	if d.symbols != nil {
+		if name, ok := d.symbols.Name(frame.TargetBank, frame.Target); ok {
+			return name
+		}
+	}
+	return disasm.FormatAddress(frame.TargetBank, frame.Target)
+}
@@ -0,0 +1,497 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"github.com/robmerrell/gmboy/system/disasm"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Memory is how the debugger looks at memory. Reading through it must not trigger watchpoints.
+type Memory interface {
+	PeekByte(location uint16) byte
+	Bank(location uint16) int
+}
+
+// peekMemory lets instructions be decoded from the debugger's view of memory
+type peekMemory struct {
+	Memory
+}
+
+func (m peekMemory) ReadByte(location uint16) byte {
+	return m.PeekByte(location)
+}
+
+// consoleCommand is a command that can be typed into a debugger console.
+type consoleCommand struct {
+	name  string
+	alias string
+	usage string
+	help  string
+	run   func(d *Debugger, args []string) (string, error)
+}
+
+var consoleCommands []consoleCommand
+
+func init() {
+	consoleCommands = []consoleCommand{
+		{"step", "s", "step [N]", "execute the next N instructions, stopping first if running", (*Debugger).stepCommand},
+		{"next", "n", "next", "execute the next instruction, running calls and RSTs until they return", (*Debugger).nextCommand},
+		{"finish", "f", "finish", "run until the current function returns", (*Debugger).finishCommand},
+		{"until", "u", "until ADDR", "run until the instruction at ADDR is about to be executed", (*Debugger).untilCommand},
+		{"frame", "fr", "frame", "run until VBlank next starts", (*Debugger).frameCommand},
+		{"scanline", "sl", "scanline", "run until the next scanline starts", (*Debugger).scanlineCommand},
+		{"reverse-step", "rs", "reverse-step [N]", "go back N instructions, when rewinding is on", (*Debugger).reverseStepCommand},
+		{"reverse-continue", "rc", "reverse-continue", "run backwards to the last breakpoint or watchpoint hit", (*Debugger).reverseContinueCommand},
+		{"back", "bk", "back", "go back to the last rewind state, a few frames earlier", (*Debugger).backCommand},
+		{"continue", "c", "continue", "continue running", (*Debugger).continueCommand},
+		{"pause", "p", "pause", "stop before the next instruction", (*Debugger).pauseCommand},
+		{"regs", "r", "regs", "show the CPU registers", (*Debugger).regsCommand},
+		{"bt", "", "bt", "show the call stack", (*Debugger).btCommand},
+		{"mem", "m", "mem ADDR [LEN]", "show LEN bytes of memory starting at ADDR or a label", (*Debugger).memCommand},
+		{"disasm", "d", "disasm [ADDR] [COUNT]", "disassemble COUNT instructions starting at ADDR, or PC", (*Debugger).disasmCommand},
+		{"break", "b", "break SPEC", "add a breakpoint: ADDR, BANK:ADDR, ADDR if COND or if COND", (*Debugger).breakCommand},
+		{"watch", "w", "watch ADDR [r|w|rw]", "stop after ADDR is read or written", (*Debugger).watchCommand},
+		{"delete", "del", "delete ID", "remove a breakpoint or watchpoint", (*Debugger).deleteCommand},
+		{"list", "l", "list", "list the breakpoints and watchpoints", (*Debugger).listCommand},
+		{"print", "=", "print EXPR", "evaluate an expression like [HL] or LY == 144", (*Debugger).printCommand},
+		{"help", "h", "help", "show this help", (*Debugger).helpCommand},
+	}
+}
+
+// Execute runs a line typed into a debugger console and returns what should be printed. Lines that aren't a
+// console command are run as javascript. Execute must be called from the goroutine running the system.
+//
+// Addresses and banks are hex, or labels when symbols are loaded. Counts are decimal.
+func (d *Debugger) Execute(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	for _, cmd := range d.consoleCommands() {
+		if fields[0] == cmd.name || fields[0] == cmd.alias {
+			output, err := cmd.run(d, fields[1:])
+			if err != nil {
+				return err.Error() + "\n"
+			}
+			return output
+		}
+	}
+
+	return d.runJavascript(line)
+}
+
+// Completions returns the words a console can tab complete: the console commands and everything defined in the
+// javascript vm.
+func (d *Debugger) Completions() []string {
+	var words []string
+	for _, cmd := range d.consoleCommands() {
+		words = append(words, cmd.name)
+	}
+
+	if globals, err := d.vm.Run("Object.keys(this)"); err == nil {
+		if exported, err := globals.Export(); err == nil {
+			if names, ok := exported.([]string); ok {
+				words = append(words, names...)
+			}
+		}
+	}
+
+	sort.Strings(words)
+	return words
+}
+
+// AttachCommand adds a console command for something outside of the debugger, like resetting the system. alias can
+// be empty.
+func (d *Debugger) AttachCommand(name, alias, usage, help string, run func(args []string) (string, error)) {
+	d.commands = append(d.commands, consoleCommand{name, alias, usage, help, func(_ *Debugger, args []string) (string, error) {
+		return run(args)
+	}})
+}
+
+// consoleCommands returns the built in console commands followed by the ones added with AttachCommand.
+func (d *Debugger) consoleCommands() []consoleCommand {
+	return append(consoleCommands[:len(consoleCommands):len(consoleCommands)], d.commands...)
+}
+
+// AttachMemory sets the memory the debugger reads from. It's called by the MMU when the debugger is attached.
+func (d *Debugger) AttachMemory(mem Memory) {
+	d.memory = mem
+}
+
+// AttachStepper sets the function the debugger uses to execute a single instruction.
+func (d *Debugger) AttachStepper(step func()) {
+	d.stepper = step
+}
+
+// AttachClock sets the function the debugger uses to get the number of cycles run since power on, and how many of
+// them make up a frame.
+func (d *Debugger) AttachClock(cycles func() uint64, cyclesPerFrame uint64) {
+	d.clock = cycles
+	d.cyclesPerFrame = cyclesPerFrame
+}
+
+// AttachRewind sets the function the debugger uses to go back to the last state saved for rewinding.
+func (d *Debugger) AttachRewind(rewind func() error) {
+	d.rewind = rewind
+}
+
+// runJavascript runs a line as javascript and returns its result.
+func (d *Debugger) runJavascript(line string) string {
+	val, err := d.vm.Run(line)
+	if err != nil {
+		return err.Error() + "\n"
+	}
+
+	if val.IsUndefined() {
+		return ""
+	}
+	return val.String() + "\n"
+}
+
+// attached returns an error if the debugger isn't attached to a running system.
+func (d *Debugger) attached() error {
+	if d.env == nil || d.memory == nil {
+		return fmt.Errorf("the debugger isn't attached to a system")
+	}
+	return nil
+}
+
+// pc returns the current program counter.
+func (d *Debugger) pc() uint16 {
+	return uint16(d.env.Register(expr.RegPC))
+}
+
+// location returns the instruction at the program counter as a disassembly listing line.
+func (d *Debugger) location() string {
+	return d.listing(disasm.DecodeWithSymbols(peekMemory{d.memory}, d.disasmSymbols(), d.pc()))
+}
+
+// listing formats an instruction as a line of a disassembly listing, preceded by its label if it has one.
+func (d *Debugger) listing(inst disasm.Instruction) string {
+	if inst.Label != "" {
+		return inst.Label + ":\n" + inst.String() + "\n"
+	}
+	return inst.String() + "\n"
+}
+
+func (d *Debugger) stepCommand(args []string) (string, error) {
+	count, err := parseCount(args, 0, 1)
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < count; i++ {
+		if err := d.StepInstruction(); err != nil {
+			return "", err
+		}
+	}
+
+	return d.location(), nil
+}
+
+func (d *Debugger) nextCommand(args []string) (string, error) {
+	if err := d.attached(); err != nil {
+		return "", err
+	}
+
+	// only calls need to run, everything else can be stepped right away
+	if !isCall(d.memory.PeekByte(d.pc())) {
+		return d.stepCommand(nil)
+	}
+
+	if err := d.StepOver(); err != nil {
+		return "", err
+	}
+	return "Running until the call returns\n", nil
+}
+
+func (d *Debugger) finishCommand(args []string) (string, error) {
+	if err := d.StepOut(); err != nil {
+		return "", err
+	}
+	return "Running until the function returns\n", nil
+}
+
+func (d *Debugger) untilCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: until ADDR")
+	}
+
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.RunTo(address); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Running until %04X\n", address), nil
+}
+
+func (d *Debugger) frameCommand(args []string) (string, error) {
+	if err := d.RunToNextFrame(); err != nil {
+		return "", err
+	}
+	return "Running until the next frame\n", nil
+}
+
+func (d *Debugger) scanlineCommand(args []string) (string, error) {
+	if err := d.RunToNextScanline(); err != nil {
+		return "", err
+	}
+	return "Running until the next scanline\n", nil
+}
+
+func (d *Debugger) backCommand(args []string) (string, error) {
+	if err := d.StepBack(); err != nil {
+		return "", err
+	}
+	return d.location(), nil
+}
+
+func (d *Debugger) continueCommand(args []string) (string, error) {
+	if !d.BreakpointActive {
+		return "", fmt.Errorf("already running")
+	}
+
+	d.Continue()
+	return "", nil
+}
+
+func (d *Debugger) pauseCommand(args []string) (string, error) {
+	if err := d.Pause(); err != nil {
+		return "", err
+	}
+	return d.location(), nil
+}
+
+func (d *Debugger) regsCommand(args []string) (string, error) {
+	if err := d.attached(); err != nil {
+		return "", err
+	}
+
+	reg := func(r expr.Register) int { return d.env.Register(r) }
+
+	flags := []byte("----")
+	for i, name := range "ZNHC" {
+		if reg(expr.RegF)&(0x80>>uint(i)) != 0 {
+			flags[i] = byte(name)
+		}
+	}
+
+	return fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X flags:%s\n",
+		reg(expr.RegA), reg(expr.RegF), reg(expr.RegB), reg(expr.RegC), reg(expr.RegD), reg(expr.RegE),
+		reg(expr.RegH), reg(expr.RegL), reg(expr.RegSP), reg(expr.RegPC), flags), nil
+}
+
+func (d *Debugger) btCommand(args []string) (string, error) {
+	return d.Backtrace()
+}
+
+func (d *Debugger) memCommand(args []string) (string, error) {
+	if err := d.attached(); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: mem ADDR [LEN]")
+	}
+
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	length, err := parseCount(args, 1, 16)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for i := 0; i < length; i++ {
+		location := address + uint16(i)
+		if i%16 == 0 {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			fmt.Fprintf(&out, "%04X ", location)
+		}
+		fmt.Fprintf(&out, " %02X", d.memory.PeekByte(location))
+	}
+	out.WriteString("\n")
+
+	return out.String(), nil
+}
+
+func (d *Debugger) disasmCommand(args []string) (string, error) {
+	if err := d.attached(); err != nil {
+		return "", err
+	}
+
+	address := d.pc()
+	if len(args) > 0 {
+		var err error
+		if address, err = d.parseAddress(args[0]); err != nil {
+			return "", err
+		}
+	}
+
+	count, err := parseCount(args, 1, 10)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for i := 0; i < count; i++ {
+		inst := disasm.DecodeWithSymbols(peekMemory{d.memory}, d.disasmSymbols(), address)
+		out.WriteString(d.listing(inst))
+		address += inst.Len()
+	}
+
+	return out.String(), nil
+}
+
+func (d *Debugger) breakCommand(args []string) (string, error) {
+	id, err := d.AddBreakpointSpec(strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Breakpoint %d added\n", id), nil
+}
+
+func (d *Debugger) watchCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: watch ADDR [r|w|rw]")
+	}
+
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	mode := "rw"
+	if len(args) > 1 {
+		mode = args[1]
+	}
+
+	access := 0
+	if strings.Contains(mode, "r") {
+		access |= WatchRead
+	}
+	if strings.Contains(mode, "w") {
+		access |= WatchWrite
+	}
+	if access == 0 {
+		return "", fmt.Errorf("expected the watchpoint mode to be r, w or rw")
+	}
+
+	return fmt.Sprintf("Watchpoint %d added\n", d.AddWatchpoint(address, access)), nil
+}
+
+func (d *Debugger) deleteCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: delete ID")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil || !d.RemoveBreakpoint(id) {
+		return "", fmt.Errorf("there's no breakpoint %s", args[0])
+	}
+	return "", nil
+}
+
+func (d *Debugger) listCommand(args []string) (string, error) {
+	lines := make(map[int]string)
+
+	for _, bps := range d.breakpoints {
+		for _, bp := range bps {
+			lines[bp.ID] = describeBreakpoint(bp)
+		}
+	}
+	for _, bp := range d.conditions {
+		lines[bp.ID] = describeBreakpoint(bp)
+	}
+	for _, wps := range d.watchpoints {
+		for _, wp := range wps {
+			mode := ""
+			if wp.Access&WatchRead != 0 {
+				mode += "r"
+			}
+			if wp.Access&WatchWrite != 0 {
+				mode += "w"
+			}
+			lines[wp.ID] = fmt.Sprintf("watch %04X %s", wp.Address, mode)
+		}
+	}
+
+	ids := make([]int, 0, len(lines))
+	for id := range lines {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var out strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&out, "%3d  %s\n", id, lines[id])
+	}
+	return out.String(), nil
+}
+
+// describeBreakpoint formats a breakpoint the same way it's added.
+func describeBreakpoint(bp *Breakpoint) string {
+	if bp.anyAddress {
+		return "break if " + bp.Condition.String()
+	}
+
+	location := fmt.Sprintf("%04X", bp.Address)
+	if bp.Bank >= 0 {
+		location = disasm.FormatAddress(bp.Bank, bp.Address)
+	}
+
+	if bp.Condition != nil {
+		return "break " + location + " if " + bp.Condition.String()
+	}
+	return "break " + location
+}
+
+func (d *Debugger) printCommand(args []string) (string, error) {
+	result, err := d.Evaluate(strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d ($%X)\n", result, result), nil
+}
+
+func (d *Debugger) helpCommand(args []string) (string, error) {
+	var out strings.Builder
+	for _, cmd := range d.consoleCommands() {
+		fmt.Fprintf(&out, "  %-24s %-4s %s\n", cmd.usage, cmd.alias, cmd.help)
+	}
+	out.WriteString("Anything else is run as javascript.\n")
+	return out.String(), nil
+}
+
+// parseHexAddress parses a hex address, optionally prefixed with $ or 0x.
+func parseHexAddress(address string) (uint16, error) {
+	parsed, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(address, "$"), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid address", address)
+	}
+	return uint16(parsed), nil
+}
+
+// parseCount parses the decimal count at args[i], or returns fallback if there isn't one.
+func parseCount(args []string, i int, fallback int) (int, error) {
+	if len(args) <= i {
+		return fallback, nil
+	}
+
+	count, err := strconv.Atoi(args[i])
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("%s is not a valid count", args[i])
+	}
+	return count, nil
+}
@@ -0,0 +1,168 @@
+package debugger_test
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/debugger/debugtest"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"strings"
+	"testing"
+)
+
+func newConsoleDebugger() (*debugger.Debugger, *debugtest.System) {
+	sys := debugtest.New(0x0100)
+	copy(sys.Memory[0x0100:], []byte{0x00, 0x3C, 0xC3, 0x50, 0x01})
+	copy(sys.Memory[0xC000:], []byte{0xDE, 0xAD, 0xBE, 0xEF})
+	return sys.Debugger, sys
+}
+
+func assertOutput(t *testing.T, d *debugger.Debugger, line, expected string) {
+	if output := d.Execute(line); output != expected {
+		t.Errorf("Expected %q to output %q but was %q", line, expected, output)
+	}
+}
+
+func TestConsoleStep(t *testing.T) {
+	d, sys := newConsoleDebugger()
+
+	assertOutput(t, d, "step", "00:0101  3C        INC A\n")
+	if !d.BreakpointActive {
+		t.Error("Expected stepping to stop execution")
+	}
+
+	assertOutput(t, d, "s 2", "00:0103  50        LD D,B\n")
+	if sys.PC != 0x0103 || sys.A != 1 {
+		t.Errorf("Expected 3 instructions to be stepped but was at %04X with A %d", sys.PC, sys.A)
+	}
+
+	assertOutput(t, d, "continue", "")
+	if len(d.Cont) != 1 {
+		t.Error("Expected continue to resume execution")
+	}
+}
+
+func TestConsoleInspect(t *testing.T) {
+	d, sys := newConsoleDebugger()
+	sys.A = 0x3F
+
+	assertOutput(t, d, "regs", "A:3F F:00 B:00 C:00 D:00 E:00 H:00 L:00 SP:0000 PC:0100 flags:----\n")
+	assertOutput(t, d, "mem C000 4", "C000  DE AD BE EF\n")
+	assertOutput(t, d, "disasm 0100 3", "00:0100  00        NOP\n00:0101  3C        INC A\n00:0102  C3 50 01  JP $0150\n")
+	assertOutput(t, d, "print [$C000] + 1", "223 ($DF)\n")
+
+	if output := d.Execute("mem C000 20"); strings.Count(output, "\n") != 2 {
+		t.Errorf("Expected 20 bytes to be shown on 2 lines, but got %q", output)
+	}
+}
+
+func TestConsoleBreakpoints(t *testing.T) {
+	d, _ := newConsoleDebugger()
+
+	assertOutput(t, d, "break 0150", "Breakpoint 1 added\n")
+	assertOutput(t, d, "break if A == 3", "Breakpoint 2 added\n")
+	assertOutput(t, d, "watch C000 w", "Watchpoint 3 added\n")
+	assertOutput(t, d, "list", "  1  break 0150\n  2  break if A == 3\n  3  watch C000 w\n")
+
+	assertOutput(t, d, "delete 2", "")
+	assertOutput(t, d, "list", "  1  break 0150\n  3  watch C000 w\n")
+	assertOutput(t, d, "delete 2", "there's no breakpoint 2\n")
+}
+
+func TestConsoleJavascript(t *testing.T) {
+	d, _ := newConsoleDebugger()
+
+	assertOutput(t, d, "var x = 40", "")
+	assertOutput(t, d, "x + 2", "42\n")
+
+	found := false
+	for _, word := range d.Completions() {
+		if word == "addBreakpoint" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the javascript functions to be tab completed")
+	}
+}
+
+func TestConsoleAttachCommand(t *testing.T) {
+	d, _ := newConsoleDebugger()
+	d.AttachCommand("echo", "e", "echo WORDS", "print the words", func(args []string) (string, error) {
+		return strings.Join(args, " ") + "\n", nil
+	})
+
+	assertOutput(t, d, "e hello there", "hello there\n")
+	if help := d.Execute("help"); !strings.Contains(help, "print the words") {
+		t.Errorf("Expected attached commands to be in the help, but was %q", help)
+	}
+}
+
+func TestConsoleBacktrace(t *testing.T) {
+	d, sys := newConsoleDebugger()
+	sys.PC = 0x0215
+	sys.Frames = []debugger.Frame{
+		{Kind: debugger.FrameCall, Caller: 0x0150, Target: 0x0200},
+		{Kind: debugger.FrameInterrupt, Caller: 0x0204, Target: 0x0040},
+		{Kind: debugger.FrameCall, Caller: 0x0042, Target: 0x0210},
+	}
+
+	assertOutput(t, d, "bt", "#0  00:0215 in 00:0210\n#1  00:0042 in 00:0040 (interrupt)\n#2  00:0204 in 00:0200\n#3  00:0150\n")
+}
+
+func TestConsoleSymbols(t *testing.T) {
+	d, sys := newConsoleDebugger()
+	table, err := symbols.Parse(strings.NewReader("00:0100 Main\n00:0150 Main.loop\n00:0200 Update\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AttachSymbols(table)
+
+	assertOutput(t, d, "disasm Main 3", "Main:\n00:0100  00        NOP\n00:0101  3C        INC A\n00:0102  C3 50 01  JP Main.loop\n")
+
+	assertOutput(t, d, "break Main.loop", "Breakpoint 1 added\n")
+	if !d.CheckBreakpoint(0x0150, 0) {
+		t.Error("Expected the breakpoint at Main.loop to be hit")
+	}
+
+	sys.PC = 0x0203
+	sys.Frames = []debugger.Frame{{Kind: debugger.FrameCall, Caller: 0x0152, Target: 0x0200}}
+	assertOutput(t, d, "bt", "#0  00:0203 Update+$3 in Update\n#1  00:0152 Main.loop+$2\n")
+}
+
+func TestConsoleState(t *testing.T) {
+	d, sys := newConsoleDebugger()
+
+	assertOutput(t, d, "readByte(0xC001)", "173\n")
+	assertOutput(t, d, "readWord(0xC000)", "44510\n")
+	assertOutput(t, d, "readBytes(0xC002, 2)", "190,239\n")
+	assertOutput(t, d, "readBytes(0xC000, -1)", "")
+
+	d.Execute("writeWord(0xC010, 0x1234)")
+	if sys.Memory[0xC010] != 0x34 || sys.Memory[0xC011] != 0x12 {
+		t.Errorf("Expected writeWord to write $1234 little endian but was %02X %02X", sys.Memory[0xC010], sys.Memory[0xC011])
+	}
+
+	d.Execute("setRegister('a', 0x42)")
+	d.Execute("setFlag('Z', true)")
+	d.Execute("setFlag('C', true)")
+	d.Execute("setFlag('C', false)")
+	d.Execute("setPC(0x0150)")
+	d.Execute("setSP(0xFFFE)")
+	assertOutput(t, d, "regs", "A:42 F:80 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0150 flags:Z---\n")
+
+	// the test system can't switch banks, so there's nothing to read from other banks
+	assertOutput(t, d, "readByte(0x4000, 2)", "")
+}
+
+func TestConsoleBack(t *testing.T) {
+	d, sys := newConsoleDebugger()
+	assertOutput(t, d, "back", "rewinding isn't on\n")
+
+	d.AttachRewind(func() error {
+		sys.PC = 0x0101
+		return nil
+	})
+	assertOutput(t, d, "back", "00:0101  3C        INC A\n")
+	if !d.BreakpointActive {
+		t.Error("Expected stepping back to stop execution")
+	}
+}
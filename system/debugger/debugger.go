@@ -2,6 +2,8 @@ package debugger
 
 import (
 	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"github.com/robmerrell/gmboy/system/symbols"
 	"io/ioutil"
 	"log"
 )
@@ -15,19 +17,124 @@ import (
 //   before_execute: fired before an opcode is executed. Passes the current instruction.
 //   after_execute: fired after an opcode is executed. Passes the just executed instruction.
 //   unimplemented_opcode: fired when an unimplemented opcode is encountered. Passes the opcode.
+//   breakpoint: fired when a breakpoint added with addBreakpoint is hit. Passes the breakpoint id, address and bank.
+//   watchpoint: fired when a watchpoint is hit. Passes the watchpoint id, address, access ('r' or 'w') and value.
+//   target_reached: fired when execution stops after a stepOver, stepOut or runTo. Passes the address and bank.
+//   interrupt: fired when an interrupt is serviced. Passes the interrupt's name and vector.
+//   vblank: fired when VBlank starts. Passes the number of frames completed.
+//   scanline: fired when a new scanline starts. Passes LY.
+//   io_write: fired when the CPU writes an I/O register. Passes the address, old value and new value.
+//   bank_switch: fired when a different ROM bank is mapped in. Passes the bank and the previous bank.
+//   dma_start: fired when an OAM DMA transfer starts. Passes the source address.
+//   serial_byte: fired when the start bit of SC is set to send the byte in SB. Passes the byte.
+//   reset: fired when the system powers on, is reset or is power cycled. Passes whether it was a power cycle.
+//   input_poll: fired when the buttons held are read as a frame starts, so a script can change them with setInput
+//     or pressButton. Passes the frame number.
 //
 // Builtin functions:
-//   dumpMemory() - returns an array of the system's memory
-//   writeByte(location, byte) - write a byte at the given location in memory
+//   dumpMemory() - returns an array of the system's memory. Copies all 64K, so prefer readByte and readBytes.
+//   readByte(address, [bank]) - returns the byte at address. Memory functions read and write the given ROM bank
+//     when there is one, even if it isn't mapped in.
+//   readWord(address, [bank]) - returns the little endian word at address
+//   readBytes(address, length, [bank]) - returns an array of length bytes starting at address
+//   writeByte(address, byte, [bank]) - writes a byte at address. Writes to ROM patch it.
+//   writeWord(address, word, [bank]) - writes a little endian word at address
+//   romBank() - returns the ROM bank mapped in at 4000-7FFF
+//   setRegister(name, value) - sets a register, like setRegister('HL', 0xC000)
+//   setFlag(name, set) - sets or clears the Z, N, H or C flag
+//   setPC(address) - moves the program counter
+//   setSP(address) - moves the stack pointer
+//     Don't change registers from before_execute: the instruction has already been fetched by then, so it still
+//     runs, with the new registers, and the program counter moves on past it from wherever setPC put it.
 //   cpuState() - returns an object with the current state of the CPU
+//   callStack() - returns the calls, RSTs and interrupts that haven't returned yet, outermost first
+//   disassemble(address, count) - decodes count instructions starting at address
+//   addBreakpoint(address, [bank], [condition]) - stops execution before the instruction at address runs, optionally
+//     only when condition is true (see the expr package). Returns the breakpoint id.
+//   breakWhen(condition) - stops execution whenever condition becomes true, e.g. breakWhen('LY == 144'). Returns the
+//     breakpoint id.
+//   evaluate(expression) - returns the value of a condition expression, e.g. evaluate('[HL]')
+//   addWatchpoint(address, 'r'|'w'|'rw') - stops execution after address is read or written. Returns the watchpoint id.
+//   removeBreakpoint(id) - removes a breakpoint or watchpoint
+//   stepOver() - executes the next instruction, running calls and RSTs until they return
+//   stepOut() - runs until the current function returns
+//   runTo(address) - runs until the instruction at address is about to be executed
+//   runToNextFrame() - runs until VBlank next starts, or for a frame's worth of cycles while the LCD is off
+//   runToNextScanline() - runs until the next scanline starts
+//   stepBack() - stops and goes back to the last state saved for rewinding, when rewinding is on
+//   reverseStep() - goes back to just before the last instruction executed, when rewinding is on
+//   reverseContinue() - runs backwards to the last breakpoint or watchpoint hit, when rewinding is on. Returns false
+//     if there wasn't one before the oldest saved state. Neither can be called from an event callback.
+//   symbolAt(address, [bank]) - returns the label at or just before address, like "Main.loop+$2"
+//   addressOf(label) - returns an object with the bank and address of a label
+//   setInput({a: true, start: false, ...}) - holds and lets go of buttons in place of the keyboard and joystick, until
+//     clearInput() is called. Buttons that aren't given stay the way they were.
+//   clearInput() - hands the buttons back to the keyboard and joystick
+//   pressButton(name, [frames]) - holds a button, or buttons like 'Down+A', for a number of frames, 1 by default,
+//     in place of the keyboard and joystick
+//   playMacro(name) - plays one of the macros from the config file on top of the buttons held
+//   pause(), resume(), frameAdvance(), reset(), powerCycle() - control the system like the hotkeys do. Resets
+//     happen once the instruction being executed finishes.
 //   ppSystem() - pretty prints the current system state
 //   ppCPU() - pretty prints the current CPU state
 //   ppInstruction(inst) - pretty prints an instruction
+//
+// The debugger can also be driven by commands typed into a console, see Execute.
 type Debugger struct {
 	vm                *otto.Otto
 	stopOnInstruction bool
 	callbacks         map[string][]otto.Value
 
+	// breakpoints and watchpoints by address. Ids are shared between the two so either can be removed by id.
+	breakpoints map[uint16][]*Breakpoint
+	watchpoints map[uint16][]*Watchpoint
+	nextID      int
+
+	// breakpoints that only have a condition, and what conditions are evaluated against
+	conditions []*Breakpoint
+	env        expr.Env
+
+	// used by the console to look at memory and step through instructions, to count frames and to go back in time
+	memory  Memory
+	stepper func()
+	clock   func() uint64
+	rewind  func() error
+
+	// cyclesPerFrame is how many cycles a frame lasts on the clock
+	cyclesPerFrame uint64
+
+	// commands are the console commands added with AttachCommand
+	commands []consoleCommand
+
+	// timeline lets execution run backwards. While replaying, breakpoints and watchpoints don't stop execution,
+	// they just set replayBreakpoint or replayWatchpoint.
+	timeline         Timeline
+	replaying        bool
+	replayBreakpoint bool
+	replayWatchpoint bool
+
+	// runningCallbacks counts the event callbacks running. They run partway through an instruction, so running
+	// backwards from one isn't allowed.
+	runningCallbacks int
+
+	// stopListeners are called when a breakpoint, watchpoint or target stops execution
+	stopListeners []func(event StopEvent)
+
+	// symbols name addresses when they're printed and let labels be used in place of addresses
+	symbols *symbols.Table
+
+	// target is set by step over, step out and run to. Execution stops once it returns true.
+	calls             CallStack
+	target            func(address uint16) bool
+	targetDescription string
+	targetStarted     bool
+
+	// When execution stopped at a breakpoint, the instruction at resumeAddress has to run before the
+	// breakpoint can be hit again. resuming is set for the first check after execution continues.
+	stoppedAtBreakpoint bool
+	resuming            bool
+	resumeAddress       uint16
+
 	// Flag for when we are actively stepping from a breakpoint
 	BreakpointActive bool
 
@@ -41,10 +148,12 @@ type Debugger struct {
 // NewDebugger returns a new debugger instance.
 func NewDebugger() *Debugger {
 	d := &Debugger{
-		vm:        otto.New(),
-		callbacks: make(map[string][]otto.Value),
-		Step:      make(chan bool, 1),
-		Cont:      make(chan bool, 1),
+		vm:          otto.New(),
+		callbacks:   make(map[string][]otto.Value),
+		breakpoints: make(map[uint16][]*Breakpoint),
+		watchpoints: make(map[uint16][]*Watchpoint),
+		Step:        make(chan bool, 1),
+		Cont:        make(chan bool, 1),
 	}
 
 	// Make the "on" function available to the js vm
@@ -54,6 +163,12 @@ func NewDebugger() *Debugger {
 		return otto.Value{}
 	})
 
+	d.attachBreakpointFunctions()
+	d.attachSteppingFunctions()
+	d.attachSymbolFunctions()
+	d.attachStateFunctions()
+	d.attachReverseFunctions()
+
 	// add the pretty print functions
 	d.vm.Run(prettPrintSrc)
 
@@ -78,13 +193,21 @@ func (d *Debugger) AttachFunction(name string, fn func(otto.FunctionCall) otto.V
 	d.vm.Set(name, fn)
 }
 
+// HasCallbacks returns true if any callbacks are registered for name. Events that fire often check this first so
+// they don't build arguments nobody is listening for.
+func (d *Debugger) HasCallbacks(name string) bool {
+	return len(d.callbacks[name]) > 0
+}
+
 // RunCallbacks runs all of a given type of callbacks. Passing arg as an argument to the javascript callback.
 func (d *Debugger) RunCallbacks(name string, arg interface{}) {
 	callbacks, exists := d.callbacks[name]
-	if !exists {
+	if !exists || d.replaying {
 		return
 	}
 
+	d.runningCallbacks++
+	defer func() { d.runningCallbacks-- }()
 	for _, callback := range callbacks {
 		if callback.IsFunction() {
 			_, err := callback.Call(otto.Value{}, arg)
@@ -101,28 +224,39 @@ func (d *Debugger) RunCallbacks(name string, arg interface{}) {
 // Next sends a message to the system that we are ready to move to the next statement
 func (d *Debugger) Next() {
 	if d.BreakpointActive {
-		d.Step <- true
+		signal(d.Step)
 	}
 }
 
 // Continue sends a message to the system that we are ready to remove the breakpoint and continue execution.
 func (d *Debugger) Continue() {
 	if d.BreakpointActive {
-		d.Cont <- true
+		signal(d.Cont)
+	}
+}
+
+// signal sends on a channel unless a message is already waiting, so a key held down or a script calling
+// continue twice can't block the system.
+func signal(ch chan bool) {
+	select {
+	case ch <- true:
+	default:
 	}
 }
 
 var prettPrintSrc = `
 ppInstruction = function(inst) {
-  var mem = dumpMemory();
   var cpu = cpuState();
 
-  var operands = mem.slice(cpu.programCounter+1, cpu.programCounter+inst.len);
+  var operands = readBytes(cpu.programCounter+1, inst.len-1);
   operands = operands.map(function(i) {
     return '0x'+i.toString(16);
   }).join(' ');
 
-  console.log(inst.opcodeHex + ': ' + inst.mnemonic + '    operands: ' + operands);
+  var label = symbolAt(cpu.programCounter);
+  label = label ? ' (' + label + ')' : '';
+
+  console.log(padHex(cpu.programCounter, '0000') + label + ' ' + inst.opcodeHex + ': ' + inst.mnemonic + '    operands: ' + operands);
 };
 
 padHex = function(value, mask) {
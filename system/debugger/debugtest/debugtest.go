@@ -0,0 +1,168 @@
+// Package debugtest is a tiny system for testing the debugger and the debugging servers built on it without a
+// whole Gameboy.
+package debugtest
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+)
+
+// System is a tiny system to debug: A, F, HL, SP and PC, a call stack and 64K of memory. $3C increments A, $77
+// writes A to [HL], $CD calls, $C9 returns and everything else is a NOP.
+type System struct {
+	A, F       byte
+	HL, SP, PC uint16
+	Frames     []debugger.Frame
+	Memory     [0x10000]byte
+
+	Debugger *debugger.Debugger
+
+	commands chan func()
+	quit     chan bool
+}
+
+// New creates a system starting at pc with a debugger attached to it.
+func New(pc uint16) *System {
+	s := &System{PC: pc, commands: make(chan func()), quit: make(chan bool)}
+
+	s.Debugger = debugger.NewDebugger()
+	s.Debugger.AttachEnv(s)
+	s.Debugger.AttachMemory(s)
+	s.Debugger.AttachCallStack(s)
+	s.Debugger.AttachStepper(s.Step)
+	return s
+}
+
+func (s *System) Register(reg expr.Register) int {
+	switch reg {
+	case expr.RegA:
+		return int(s.A)
+	case expr.RegF:
+		return int(s.F)
+	case expr.RegAF:
+		return int(s.A)<<8 | int(s.F)
+	case expr.RegHL:
+		return int(s.HL)
+	case expr.RegSP:
+		return int(s.SP)
+	case expr.RegPC:
+		return int(s.PC)
+	}
+	return 0
+}
+
+func (s *System) SetRegister(reg expr.Register, value int) {
+	switch reg {
+	case expr.RegA:
+		s.A = byte(value)
+	case expr.RegF:
+		s.F = byte(value) & 0xF0
+	case expr.RegAF:
+		s.A, s.F = byte(value>>8), byte(value)&0xF0
+	case expr.RegHL:
+		s.HL = uint16(value)
+	case expr.RegSP:
+		s.SP = uint16(value)
+	case expr.RegPC:
+		s.PC = uint16(value)
+	}
+}
+
+func (s *System) ReadByte(address uint16) byte {
+	return s.Memory[address]
+}
+
+func (s *System) PeekByte(address uint16) byte {
+	return s.Memory[address]
+}
+
+func (s *System) PokeByte(address uint16, value byte) {
+	s.Memory[address] = value
+}
+
+func (s *System) Bank(address uint16) int {
+	return 0
+}
+
+func (s *System) CallDepth() int {
+	return len(s.Frames)
+}
+
+func (s *System) CallStack() []debugger.Frame {
+	return s.Frames
+}
+
+// Step executes an instruction.
+func (s *System) Step() {
+	switch s.Memory[s.PC] {
+	case 0x3C:
+		s.A++
+	case 0x77:
+		s.Memory[s.HL] = s.A
+		s.Debugger.CheckWatchpoint(s.HL, debugger.WatchWrite, s.A)
+	case 0xCD:
+		target := uint16(s.Memory[s.PC+1]) | uint16(s.Memory[s.PC+2])<<8
+		s.Frames = append(s.Frames, debugger.Frame{Kind: debugger.FrameCall, Caller: s.PC, Target: target, ReturnAddress: s.PC + 3})
+		s.PC = target
+		return
+	case 0xC9:
+		if len(s.Frames) == 0 {
+			break
+		}
+		s.PC = s.Frames[len(s.Frames)-1].ReturnAddress
+		s.Frames = s.Frames[:len(s.Frames)-1]
+		return
+	}
+	s.PC++
+}
+
+// Start runs the system on its own goroutine, the way the real system runs, until Stop is called. It starts
+// stopped so nothing runs before a client connects.
+func (s *System) Start() {
+	s.Debugger.Pause()
+	go s.run()
+}
+
+// Stop stops the goroutine started by Start.
+func (s *System) Stop() {
+	close(s.quit)
+}
+
+// run runs the system until it's stopped
+func (s *System) run() {
+	for {
+		select {
+		case fn := <-s.commands:
+			fn()
+			continue
+		case <-s.quit:
+			return
+		default:
+		}
+
+		if s.Debugger.BreakpointActive {
+			select {
+			case <-s.Debugger.Step:
+				s.Step()
+			case <-s.Debugger.Cont:
+				s.Debugger.Resume()
+			case fn := <-s.commands:
+				fn()
+			case <-s.quit:
+				return
+			}
+		} else if !s.Debugger.CheckBreakpoint(s.PC, 0) {
+			s.Step()
+		}
+	}
+}
+
+// RunCommand runs fn on the system's goroutine and waits for it to finish.
+func (s *System) RunCommand(fn func()) {
+	done := make(chan bool)
+	s.commands <- func() {
+		fn()
+		done <- true
+	}
+	<-done
+}
@@ -0,0 +1,411 @@
+// Package expr parses and evaluates the small expression language used for conditional breakpoints, e.g.
+//
+//	A == 0x3F && [HL] != 0
+//	LY == 144
+//	ZF && BC > $C000
+//
+// Expressions work on integers and follow C's operators and precedence: || && | ^ & == != < <= > >= << >> + - * / %
+// and the unary operators ! - ~. Anything non-zero is true and comparisons evaluate to 1 or 0.
+//
+// Operands are:
+//
+//	numbers:    decimal (144), hex with a 0x or $ prefix (0x3F, $3F)
+//	registers:  A F B C D E H L AF BC DE HL SP PC
+//	flags:      ZF NF HF CF
+//	I/O:        the standard register names like LCDC, STAT, LY, LYC, DIV, TIMA, IF and IE
+//	memory:     [address] reads the byte at address, which can be any expression, e.g. [HL] or [$C000+B]
+//
+// Names aren't case sensitive.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Register identifies a CPU register.
+type Register int
+
+// The CPU registers available to expressions.
+const (
+	RegA Register = iota
+	RegF
+	RegB
+	RegC
+	RegD
+	RegE
+	RegH
+	RegL
+	RegAF
+	RegBC
+	RegDE
+	RegHL
+	RegSP
+	RegPC
+)
+
+// Env gives expressions access to the state of the system while they're evaluated.
+type Env interface {
+	Register(reg Register) int
+	ReadByte(address uint16) byte
+}
+
+var registerNames = map[string]Register{
+	"A": RegA, "F": RegF, "B": RegB, "C": RegC, "D": RegD, "E": RegE, "H": RegH, "L": RegL,
+	"AF": RegAF, "BC": RegBC, "DE": RegDE, "HL": RegHL, "SP": RegSP, "PC": RegPC,
+}
+
+// ParseRegister returns the register with the given name, like "A" or "hl".
+func ParseRegister(name string) (Register, bool) {
+	reg, ok := registerNames[strings.ToUpper(name)]
+	return reg, ok
+}
+
+// flag bits in the F register
+var flagNames = map[string]uint{"ZF": 7, "NF": 6, "HF": 5, "CF": 4}
+
+var ioNames = map[string]uint16{
+	"P1": 0xFF00, "JOYP": 0xFF00, "SB": 0xFF01, "SC": 0xFF02,
+	"DIV": 0xFF04, "TIMA": 0xFF05, "TMA": 0xFF06, "TAC": 0xFF07, "IF": 0xFF0F,
+	"LCDC": 0xFF40, "STAT": 0xFF41, "SCY": 0xFF42, "SCX": 0xFF43, "LY": 0xFF44, "LYC": 0xFF45,
+	"DMA": 0xFF46, "BGP": 0xFF47, "OBP0": 0xFF48, "OBP1": 0xFF49, "WY": 0xFF4A, "WX": 0xFF4B,
+	"IE": 0xFFFF,
+}
+
+// node is a compiled piece of an expression.
+type node func(env Env) int
+
+// Expr is a parsed expression, ready to be evaluated any number of times.
+type Expr struct {
+	source string
+	root   node
+}
+
+// Parse parses an expression.
+func Parse(source string) (*Expr, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos].text, source)
+	}
+
+	return &Expr{source: source, root: root}, nil
+}
+
+// Eval evaluates the expression.
+func (e *Expr) Eval(env Env) int {
+	return e.root(env)
+}
+
+// True evaluates the expression and returns true if the result is non-zero.
+func (e *Expr) True(env Env) bool {
+	return e.root(env) != 0
+}
+
+// String returns the expression as it was written.
+func (e *Expr) String() string {
+	return e.source
+}
+
+// token kinds
+const (
+	tokenNumber = iota
+	tokenName
+	tokenOperator
+)
+
+type token struct {
+	kind  int
+	text  string
+	value int
+}
+
+// operators, longest first so that "<=" is matched before "<"
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>", "|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "!", "~", "(", ")", "[", "]"}
+
+// tokenize splits an expression into numbers, names and operators.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(source); {
+		ch := source[i]
+
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '$' || isDigit(ch):
+			start := i
+			i++
+			for i < len(source) && (isDigit(source[i]) || isLetter(source[i])) {
+				i++
+			}
+
+			value, err := parseNumber(source[start:i])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], value: value})
+		case isLetter(ch):
+			start := i
+			for i < len(source) && (isDigit(source[i]) || isLetter(source[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenName, text: strings.ToUpper(source[start:i])})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q in %q", ch, source)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseNumber parses a decimal or a 0x or $ prefixed hex number.
+func parseNumber(text string) (int, error) {
+	var value uint64
+	var err error
+
+	switch {
+	case strings.HasPrefix(text, "$"):
+		value, err = strconv.ParseUint(text[1:], 16, 32)
+	case strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X"):
+		value, err = strconv.ParseUint(text[2:], 16, 32)
+	default:
+		value, err = strconv.ParseUint(text, 10, 32)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid number", text)
+	}
+	return int(value), nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_'
+}
+
+// binaryOperators are grouped by precedence, lowest first.
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peekOperator returns the next token's text if it's an operator.
+func (p *parser) peekOperator() string {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+// parseBinary parses binary operators with at least the given precedence.
+func (p *parser) parseBinary(precedence int) (node, error) {
+	if precedence == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(precedence + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peekOperator()
+		if !contains(binaryOperators[precedence], op) {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+}
+
+// parseUnary parses the unary operators and operands.
+func (p *parser) parseUnary() (node, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch {
+	case tok.kind == tokenNumber:
+		value := tok.value
+		return func(Env) int { return value }, nil
+	case tok.kind == tokenName:
+		return name(tok.text)
+	case tok.text == "!" || tok.text == "-" || tok.text == "~":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary(tok.text, operand), nil
+	case tok.text == "(":
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case tok.text == "[":
+		address, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return func(env Env) int { return int(env.ReadByte(uint16(address(env)))) }, p.expect("]")
+	}
+
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// expect consumes the given operator or returns an error if it's missing.
+func (p *parser) expect(op string) error {
+	if p.peekOperator() != op {
+		return fmt.Errorf("expected %q", op)
+	}
+	p.pos++
+	return nil
+}
+
+// name compiles a register, flag or I/O register name.
+func name(text string) (node, error) {
+	if reg, ok := registerNames[text]; ok {
+		return func(env Env) int { return env.Register(reg) }, nil
+	}
+
+	if bit, ok := flagNames[text]; ok {
+		return func(env Env) int { return (env.Register(RegF) >> bit) & 1 }, nil
+	}
+
+	if address, ok := ioNames[text]; ok {
+		return func(env Env) int { return int(env.ReadByte(address)) }, nil
+	}
+
+	return nil, fmt.Errorf("unknown name %s", text)
+}
+
+// unary compiles a unary operator.
+func unary(op string, operand node) node {
+	switch op {
+	case "!":
+		return func(env Env) int { return boolToInt(operand(env) == 0) }
+	case "-":
+		return func(env Env) int { return -operand(env) }
+	}
+	return func(env Env) int { return ^operand(env) }
+}
+
+// binary compiles a binary operator.
+func binary(op string, left, right node) node {
+	switch op {
+	case "||":
+		return func(env Env) int { return boolToInt(left(env) != 0 || right(env) != 0) }
+	case "&&":
+		return func(env Env) int { return boolToInt(left(env) != 0 && right(env) != 0) }
+	case "|":
+		return func(env Env) int { return left(env) | right(env) }
+	case "^":
+		return func(env Env) int { return left(env) ^ right(env) }
+	case "&":
+		return func(env Env) int { return left(env) & right(env) }
+	case "==":
+		return func(env Env) int { return boolToInt(left(env) == right(env)) }
+	case "!=":
+		return func(env Env) int { return boolToInt(left(env) != right(env)) }
+	case "<":
+		return func(env Env) int { return boolToInt(left(env) < right(env)) }
+	case "<=":
+		return func(env Env) int { return boolToInt(left(env) <= right(env)) }
+	case ">":
+		return func(env Env) int { return boolToInt(left(env) > right(env)) }
+	case ">=":
+		return func(env Env) int { return boolToInt(left(env) >= right(env)) }
+	case "<<":
+		return func(env Env) int { return left(env) << uint(right(env)&31) }
+	case ">>":
+		return func(env Env) int { return left(env) >> uint(right(env)&31) }
+	case "+":
+		return func(env Env) int { return left(env) + right(env) }
+	case "-":
+		return func(env Env) int { return left(env) - right(env) }
+	case "*":
+		return func(env Env) int { return left(env) * right(env) }
+	case "/":
+		return func(env Env) int { return divide(left(env), right(env)) }
+	}
+	return func(env Env) int { return modulo(left(env), right(env)) }
+}
+
+// divide divides, treating division by zero as 0 so a condition can't crash the emulator.
+func divide(a, b int) int {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// modulo is the remainder, treating division by zero as 0.
+func modulo(a, b int) int {
+	if b == 0 {
+		return 0
+	}
+	return a % b
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
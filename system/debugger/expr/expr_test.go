@@ -0,0 +1,79 @@
+package expr
+
+import (
+	"testing"
+)
+
+// testEnv has fixed registers and 64K of memory.
+type testEnv struct {
+	registers map[Register]int
+	memory    [0x10000]byte
+}
+
+func (e *testEnv) Register(reg Register) int {
+	return e.registers[reg]
+}
+
+func (e *testEnv) ReadByte(address uint16) byte {
+	return e.memory[address]
+}
+
+func newTestEnv() *testEnv {
+	env := &testEnv{registers: map[Register]int{
+		RegA: 0x3F, RegF: 0x90, RegB: 0x01, RegC: 0x02, RegHL: 0xC000, RegSP: 0xFFFE, RegPC: 0x0150,
+	}}
+	env.memory[0xC000] = 0x12
+	env.memory[0xC001] = 0x34
+	env.memory[0xFF44] = 144
+	return env
+}
+
+func TestEval(t *testing.T) {
+	env := newTestEnv()
+
+	tests := []struct {
+		source   string
+		expected int
+	}{
+		{"144", 144},
+		{"0x3F", 0x3F},
+		{"$3f", 0x3F},
+		{"A", 0x3F},
+		{"hl", 0xC000},
+		{"A == 0x3F && [HL] != 0", 1},
+		{"A == 0x3F && [HL] == 0", 0},
+		{"LY == 144", 1},
+		{"[HL+1]", 0x34},
+		{"[$C000] << 8 | [$C001]", 0x1234},
+		{"ZF", 1},
+		{"NF", 0},
+		{"CF && !NF", 1},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"B < C || PC >= $8000", 1},
+		{"-1 + 2", 1},
+		{"~0 & $FF", 0xFF},
+		{"A / 0", 0},
+		{"SP - 2 == $FFFC", 1},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.source)
+		if err != nil {
+			t.Errorf("Expected %s to parse, but got %v", test.source, err)
+			continue
+		}
+
+		if result := e.Eval(env); result != test.expected {
+			t.Errorf("Expected %s to be %d but was %d", test.source, test.expected, result)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, source := range []string{"", "A ==", "[HL", "(A", "FOO == 1", "A = 1", "0xZZ", "A B"} {
+		if _, err := Parse(source); err == nil {
+			t.Errorf("Expected %q not to parse", source)
+		}
+	}
+}
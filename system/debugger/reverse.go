@@ -0,0 +1,189 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"log"
+)
+
+// Timeline is implemented by the system when rewinding is on. Running the system forwards from a saved state
+// always does the same thing, so the debugger runs backwards by going back to a saved state and running forwards
+// again to the point it wants to stop at.
+type Timeline interface {
+	// Cycles returns the number of cycles run since power on, which tells apart every point execution stops at.
+	Cycles() uint64
+
+	// Restore goes back to the newest saved state from at or before the given cycle.
+	Restore(cycles uint64) error
+}
+
+// AttachTimeline lets the debugger run backwards through the states saved for rewinding.
+func (d *Debugger) AttachTimeline(timeline Timeline) {
+	d.timeline = timeline
+}
+
+// ReverseStep stops execution and goes back to just before the last instruction executed. It must be called from
+// the goroutine running the system.
+func (d *Debugger) ReverseStep() error {
+	_, err := d.reverse(false)
+	return err
+}
+
+// ReverseContinue stops execution and runs backwards to the last breakpoint or watchpoint hit before the current
+// instruction, or to the oldest saved state if there wasn't one. It returns false when no breakpoint or
+// watchpoint was hit. It must be called from the goroutine running the system.
+func (d *Debugger) ReverseContinue() (bool, error) {
+	return d.reverse(true)
+}
+
+// Replaying returns true while execution is being run forwards again to go backwards. Anything that isn't
+// deterministic, like input, has to be given the same way it was the first time.
+func (d *Debugger) Replaying() bool {
+	return d.replaying
+}
+
+// reverse goes back to the last point before the current one that execution would have stopped at, either when
+// stepping or, when breakpoints is set, at a breakpoint or watchpoint. It's done in two passes: the first runs
+// forwards from saved states to find the point, going further back a saved state at a time until it's found, and
+// the second goes back to the saved state before it and runs to it. Nothing stops execution and no callbacks are
+// run while replaying.
+func (d *Debugger) reverse(breakpoints bool) (bool, error) {
+	if d.runningCallbacks > 0 {
+		return false, fmt.Errorf("can't run backwards from an event callback")
+	}
+	if err := d.Pause(); err != nil {
+		return false, err
+	}
+	if d.timeline == nil || d.stepper == nil {
+		return false, fmt.Errorf("running backwards needs rewinding to be on")
+	}
+
+	end := d.timeline.Cycles()
+	if end == 0 {
+		return false, fmt.Errorf("already at power on")
+	}
+
+	d.replaying = true
+	defer func() { d.replaying = false }()
+
+	// without a point to stop at, execution goes back to the start of the oldest segment searched
+	var target uint64
+	found := false
+	for segmentEnd := end; !found && segmentEnd > 0; {
+		if err := d.timeline.Restore(segmentEnd - 1); err != nil {
+			if segmentEnd == end {
+				return false, err
+			}
+			break
+		}
+
+		start := d.timeline.Cycles()
+		point, ok, err := d.search(segmentEnd, end, breakpoints)
+		if err != nil {
+			return false, err
+		}
+
+		target, found = start, ok
+		if found {
+			target = point
+		}
+		segmentEnd = start
+	}
+
+	if err := d.timeline.Restore(target); err != nil {
+		return false, err
+	}
+	for d.timeline.Cycles() < target {
+		d.stepper()
+	}
+
+	d.stop(d.pc(), true)
+	return found, nil
+}
+
+// search runs from a saved state to segmentEnd and returns the last point execution would have stopped at. A
+// watchpoint hit by the instruction that ended at the current point, end, doesn't count since that's where
+// execution is already stopped.
+func (d *Debugger) search(segmentEnd, end uint64, breakpoints bool) (uint64, bool, error) {
+	var point uint64
+	found := false
+	for d.timeline.Cycles() < segmentEnd {
+		before := d.timeline.Cycles()
+		d.replayBreakpoint, d.replayWatchpoint = false, false
+		d.stepper()
+
+		// watchpoints stop after the instruction that hit them, breakpoints before the instruction they're on
+		after := d.timeline.Cycles()
+		switch {
+		case after == before:
+			return 0, false, fmt.Errorf("execution didn't move forwards while replaying")
+		case !breakpoints:
+			point, found = before, true
+		case d.replayWatchpoint && after < end:
+			point, found = after, true
+		case d.replayBreakpoint:
+			point, found = before, true
+		}
+	}
+	return point, found, nil
+}
+
+// replayHit records that a breakpoint or watchpoint would have been hit while replaying.
+func (d *Debugger) replayHit(watchpoint bool) {
+	if watchpoint {
+		d.replayWatchpoint = true
+	} else {
+		d.replayBreakpoint = true
+	}
+}
+
+// reverseContinueCommand runs backwards to the last breakpoint or watchpoint hit.
+func (d *Debugger) reverseContinueCommand(args []string) (string, error) {
+	found, err := d.ReverseContinue()
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "Reached the oldest saved state\n" + d.location(), nil
+	}
+	return d.location(), nil
+}
+
+// reverseStepCommand goes back before the last instruction executed.
+func (d *Debugger) reverseStepCommand(args []string) (string, error) {
+	count, err := parseCount(args, 0, 1)
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < count; i++ {
+		if err := d.ReverseStep(); err != nil {
+			return "", err
+		}
+	}
+	return d.location(), nil
+}
+
+// attachReverseFunctions adds the functions for running backwards to the javascript vm
+func (d *Debugger) attachReverseFunctions() {
+	// reverseStep() goes back to just before the last instruction executed
+	d.vm.Set("reverseStep", func(call otto.FunctionCall) otto.Value {
+		if err := d.ReverseStep(); err != nil {
+			log.Println(err)
+		}
+		return otto.Value{}
+	})
+
+	// reverseContinue() runs backwards to the last breakpoint or watchpoint hit, returning false if there wasn't one
+	d.vm.Set("reverseContinue", func(call otto.FunctionCall) otto.Value {
+		found, err := d.ReverseContinue()
+		if err != nil {
+			log.Println(err)
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(found)
+		return val
+	})
+}
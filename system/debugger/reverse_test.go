@@ -0,0 +1,134 @@
+package debugger_test
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/debugger/debugtest"
+	"testing"
+)
+
+// replaySystem adds a timeline to the test system. A state is saved every 10 instructions, and the instruction at
+// 0105 writes C000.
+type replaySystem struct {
+	*debugtest.System
+	cycles uint64
+	saved  []replayState
+}
+
+type replayState struct {
+	cycles uint64
+	pc     uint16
+	a      byte
+}
+
+func (s *replaySystem) Cycles() uint64 {
+	return s.cycles
+}
+
+func (s *replaySystem) Restore(cycles uint64) error {
+	for i := len(s.saved) - 1; i >= 0; i-- {
+		if state := s.saved[i]; state.cycles <= cycles {
+			s.cycles, s.PC, s.A = state.cycles, state.pc, state.a
+			s.saved = s.saved[:i+1]
+			return nil
+		}
+	}
+	return fmt.Errorf("there's no saved state from that far back")
+}
+
+// step executes an instruction the way the CPU does, checking breakpoints first
+func (s *replaySystem) step() {
+	if s.Debugger.CheckBreakpoint(s.PC, 0) {
+		return
+	}
+
+	if s.PC == 0x0105 {
+		s.Debugger.CheckWatchpoint(0xC000, debugger.WatchWrite, 0x01)
+	}
+	s.Step()
+	s.cycles += 4
+
+	if s.cycles%40 == 0 {
+		s.saved = append(s.saved, replayState{s.cycles, s.PC, s.A})
+	}
+}
+
+func newReplayDebugger() (*debugger.Debugger, *replaySystem) {
+	d, console := newConsoleDebugger()
+	sys := &replaySystem{System: console, saved: []replayState{{0, console.PC, 0}}}
+	d.AttachStepper(sys.step)
+	d.AttachTimeline(sys)
+	return d, sys
+}
+
+func TestReverseStep(t *testing.T) {
+	d, sys := newReplayDebugger()
+	for i := 0; i < 25; i++ {
+		d.StepInstruction()
+	}
+
+	if err := d.ReverseStep(); err != nil {
+		t.Fatal(err)
+	}
+	if sys.PC != 0x0118 || sys.cycles != 96 {
+		t.Errorf("Expected to go back one instruction to 0118, but was at %04X after %d cycles", sys.PC, sys.cycles)
+	}
+
+	assertOutput(t, d, "rs 2", "00:0116  00        NOP\n")
+	if !d.BreakpointActive {
+		t.Error("Expected execution to be stopped after running backwards")
+	}
+}
+
+func TestReverseFromCallback(t *testing.T) {
+	d, sys := newReplayDebugger()
+	for i := 0; i < 5; i++ {
+		d.StepInstruction()
+	}
+
+	// callbacks run partway through an instruction, so they can't go back in time
+	var err error
+	d.AttachFunction("tryReverseStep", func(call otto.FunctionCall) otto.Value {
+		err = d.ReverseStep()
+		return otto.Value{}
+	})
+	d.Execute("on('scanline', function() { tryReverseStep(); })")
+	d.RunCallbacks("scanline", nil)
+
+	if err == nil || sys.PC != 0x0105 {
+		t.Errorf("Expected running backwards from a callback to be refused, but was at %04X", sys.PC)
+	}
+}
+
+func TestReverseContinue(t *testing.T) {
+	d, sys := newReplayDebugger()
+	d.AddBreakpoint(0x0103, -1)
+	d.AddWatchpoint(0xC000, debugger.WatchWrite)
+	for i := 0; i < 20; i++ {
+		d.StepInstruction()
+	}
+
+	// watchpoints stop after the instruction that hit them, and breakpoints before
+	for _, expected := range []uint16{0x0106, 0x0103} {
+		found, err := d.ReverseContinue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || sys.PC != expected {
+			t.Errorf("Expected to run back to %04X but was at %04X", expected, sys.PC)
+		}
+	}
+
+	// continuing from a breakpoint found going backwards runs the instruction it's on
+	d.Continue()
+	resume(t, d)
+	sys.step()
+	if sys.PC != 0x0104 {
+		t.Errorf("Expected to continue past the breakpoint, but was at %04X", sys.PC)
+	}
+
+	d.Pause()
+	assertOutput(t, d, "rc", "00:0103  50        LD D,B\n")
+	assertOutput(t, d, "rc", "Reached the oldest saved state\n00:0100  00        NOP\n")
+}
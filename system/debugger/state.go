@@ -0,0 +1,269 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"log"
+	"strings"
+)
+
+// registerWriter is implemented by an Env that lets the debugger change registers.
+type registerWriter interface {
+	SetRegister(reg expr.Register, value int)
+}
+
+// memoryWriter is implemented by Memory that lets the debugger change memory.
+type memoryWriter interface {
+	PokeByte(location uint16, value byte)
+}
+
+// bankedMemory is implemented by Memory that can read and write ROM banks that aren't mapped in.
+type bankedMemory interface {
+	PeekBankByte(bank int, location uint16) byte
+	PokeBankByte(bank int, location uint16, value byte)
+}
+
+// flagBits are the bits of each flag in the F register
+var flagBits = map[string]int{"Z": 0x80, "N": 0x40, "H": 0x20, "C": 0x10}
+
+// Location returns the program counter and the ROM bank it's in.
+func (d *Debugger) Location() (int, uint16, error) {
+	if err := d.attached(); err != nil {
+		return 0, 0, err
+	}
+
+	pc := d.pc()
+	return d.memory.Bank(pc), pc, nil
+}
+
+// ReadRegister returns the value of a register.
+func (d *Debugger) ReadRegister(reg expr.Register) (int, error) {
+	if err := d.attached(); err != nil {
+		return 0, err
+	}
+	return d.env.Register(reg), nil
+}
+
+// WriteRegister changes a register. 8-bit registers are given the low byte of value.
+func (d *Debugger) WriteRegister(reg expr.Register, value int) error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	writer, ok := d.env.(registerWriter)
+	if !ok {
+		return fmt.Errorf("registers can't be changed")
+	}
+	writer.SetRegister(reg, value)
+	return nil
+}
+
+// ReadMemory returns length bytes of memory starting at address. Reading doesn't trigger watchpoints.
+func (d *Debugger) ReadMemory(address uint16, length int) ([]byte, error) {
+	if err := d.attached(); err != nil {
+		return nil, err
+	}
+
+	contents := make([]byte, length)
+	for i := range contents {
+		contents[i] = d.memory.PeekByte(address + uint16(i))
+	}
+	return contents, nil
+}
+
+// WriteMemory writes bytes to memory starting at address. Writing doesn't trigger watchpoints, and writes to the
+// cartridge ROM patch the bank that's mapped in.
+func (d *Debugger) WriteMemory(address uint16, contents []byte) error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	writer, ok := d.memory.(memoryWriter)
+	if !ok {
+		return fmt.Errorf("memory can't be changed")
+	}
+	for i, value := range contents {
+		writer.PokeByte(address+uint16(i), value)
+	}
+	return nil
+}
+
+// readBankByte reads a byte from the given ROM bank, or from whatever is mapped in when bank is -1.
+func (d *Debugger) readBankByte(bank int, address uint16) (byte, error) {
+	if err := d.attached(); err != nil {
+		return 0, err
+	}
+
+	if bank == -1 {
+		return d.memory.PeekByte(address), nil
+	}
+
+	banked, ok := d.memory.(bankedMemory)
+	if !ok {
+		return 0, fmt.Errorf("banks can't be read")
+	}
+	return banked.PeekBankByte(bank, address), nil
+}
+
+// writeBankByte writes a byte to the given ROM bank, or to whatever is mapped in when bank is -1.
+func (d *Debugger) writeBankByte(bank int, address uint16, value byte) error {
+	if bank == -1 {
+		return d.WriteMemory(address, []byte{value})
+	}
+
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	banked, ok := d.memory.(bankedMemory)
+	if !ok {
+		return fmt.Errorf("banks can't be written")
+	}
+	banked.PokeBankByte(bank, address, value)
+	return nil
+}
+
+// setFlag sets or clears a flag in the F register, given as Z, N, H or C.
+func (d *Debugger) setFlag(name string, set bool) error {
+	bit, ok := flagBits[strings.TrimSuffix(strings.ToUpper(name), "F")]
+	if !ok {
+		return fmt.Errorf("%s isn't a flag", name)
+	}
+
+	f, err := d.ReadRegister(expr.RegF)
+	if err != nil {
+		return err
+	}
+
+	if set {
+		f |= bit
+	} else {
+		f &^= bit
+	}
+	return d.WriteRegister(expr.RegF, f)
+}
+
+// attachStateFunctions adds the functions for reading and changing memory and registers to the javascript vm.
+// Memory functions take an optional ROM bank, which reads or writes that bank even when it isn't mapped in.
+func (d *Debugger) attachStateFunctions() {
+	// optionalBank returns the bank argument at index i, or -1 if it wasn't given
+	optionalBank := func(call otto.FunctionCall, i int) int {
+		if !call.Argument(i).IsDefined() || call.Argument(i).IsNull() {
+			return -1
+		}
+		bank, _ := call.Argument(i).ToInteger()
+		return int(bank)
+	}
+
+	result := func(call otto.FunctionCall, value interface{}, err error) otto.Value {
+		if err != nil {
+			log.Println(err)
+			return otto.Value{}
+		}
+		if value == nil {
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(value)
+		return val
+	}
+
+	// readByte(address, [bank]) returns the byte at address
+	d.vm.Set("readByte", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		value, err := d.readBankByte(optionalBank(call, 1), uint16(address))
+		return result(call, value, err)
+	})
+
+	// readWord(address, [bank]) returns the little endian word at address
+	d.vm.Set("readWord", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		bank := optionalBank(call, 1)
+
+		low, err := d.readBankByte(bank, uint16(address))
+		if err != nil {
+			return result(call, nil, err)
+		}
+		high, err := d.readBankByte(bank, uint16(address)+1)
+		return result(call, uint16(low)|uint16(high)<<8, err)
+	})
+
+	// readBytes(address, length, [bank]) returns an array of length bytes starting at address
+	d.vm.Set("readBytes", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		length, _ := call.Argument(1).ToInteger()
+		bank := optionalBank(call, 2)
+		if length < 0 || length > 0x10000 {
+			return result(call, nil, fmt.Errorf("can't read %d bytes, the length has to be from 0 up to 65536", length))
+		}
+
+		contents := make([]byte, length)
+		for i := range contents {
+			value, err := d.readBankByte(bank, uint16(address)+uint16(i))
+			if err != nil {
+				return result(call, nil, err)
+			}
+			contents[i] = value
+		}
+		return result(call, contents, nil)
+	})
+
+	// writeByte(address, value, [bank]) writes a byte at address
+	d.vm.Set("writeByte", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		value, _ := call.Argument(1).ToInteger()
+		return result(call, nil, d.writeBankByte(optionalBank(call, 2), uint16(address), byte(value)))
+	})
+
+	// writeWord(address, value, [bank]) writes a little endian word at address
+	d.vm.Set("writeWord", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		value, _ := call.Argument(1).ToInteger()
+		bank := optionalBank(call, 2)
+
+		if err := d.writeBankByte(bank, uint16(address), byte(value)); err != nil {
+			return result(call, nil, err)
+		}
+		return result(call, nil, d.writeBankByte(bank, uint16(address)+1, byte(value>>8)))
+	})
+
+	// romBank() returns the ROM bank mapped in at 4000-7FFF
+	d.vm.Set("romBank", func(call otto.FunctionCall) otto.Value {
+		if err := d.attached(); err != nil {
+			return result(call, nil, err)
+		}
+		return result(call, d.memory.Bank(0x4000), nil)
+	})
+
+	// setRegister(name, value) sets a register like 'A' or 'HL'
+	d.vm.Set("setRegister", func(call otto.FunctionCall) otto.Value {
+		name, _ := call.Argument(0).ToString()
+		value, _ := call.Argument(1).ToInteger()
+
+		reg, ok := expr.ParseRegister(name)
+		if !ok {
+			return result(call, nil, fmt.Errorf("%s isn't a register", name))
+		}
+		return result(call, nil, d.WriteRegister(reg, int(value)))
+	})
+
+	// setFlag(name, set) sets or clears the Z, N, H or C flag
+	d.vm.Set("setFlag", func(call otto.FunctionCall) otto.Value {
+		name, _ := call.Argument(0).ToString()
+		set, _ := call.Argument(1).ToBoolean()
+		return result(call, nil, d.setFlag(name, set))
+	})
+
+	// setPC(address) moves the program counter
+	d.vm.Set("setPC", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		return result(call, nil, d.WriteRegister(expr.RegPC, int(address)))
+	})
+
+	// setSP(address) moves the stack pointer
+	d.vm.Set("setSP", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		return result(call, nil, d.WriteRegister(expr.RegSP, int(address)))
+	})
+}
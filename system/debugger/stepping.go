@@ -0,0 +1,242 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/disasm"
+	"log"
+)
+
+// lcdcRegister turns the LCD on with bit 7, lyRegister is the LCD's current scanline and VBlank starts on
+// vblankLine.
+const (
+	lcdcRegister = 0xFF40
+	lcdEnable    = 0x80
+	lyRegister   = 0xFF44
+	vblankLine   = 144
+)
+
+// Pause stops execution before the next instruction, if it isn't already stopped.
+func (d *Debugger) Pause() error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	if !d.BreakpointActive {
+		d.stop(d.pc(), true)
+	}
+	return nil
+}
+
+// StepInstruction executes the next instruction, stopping execution first if it's running. Unlike Next it runs
+// the instruction right away, so it must be called from the goroutine running the system.
+func (d *Debugger) StepInstruction() error {
+	if err := d.Pause(); err != nil {
+		return err
+	}
+	if d.stepper == nil {
+		return fmt.Errorf("stepping isn't available")
+	}
+
+	d.stepper()
+	return nil
+}
+
+// StepBack stops execution and puts the system back to the last state saved for rewinding, which is taken every
+// few frames. It must be called from the goroutine running the system.
+func (d *Debugger) StepBack() error {
+	if err := d.Pause(); err != nil {
+		return err
+	}
+	if d.rewind == nil {
+		return fmt.Errorf("rewinding isn't on")
+	}
+
+	if err := d.rewind(); err != nil {
+		return err
+	}
+
+	// the breakpoint at the restored location, if there is one, has already been hit
+	d.stop(d.pc(), true)
+	return nil
+}
+
+// StepOver executes the next instruction. Calls and RSTs are run until they return, as if they were a single
+// instruction.
+func (d *Debugger) StepOver() error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	pc := d.pc()
+	opcode := d.memory.PeekByte(pc)
+	if !isCall(opcode) {
+		d.runUntil("the next instruction", func(uint16) bool { return true })
+		return nil
+	}
+
+	returnAddress := pc + disasm.Decode(peekMemory{d.memory}, pc).Len()
+	depth := d.callDepth()
+	d.runUntil("the end of the call", func(address uint16) bool {
+		return address == returnAddress && d.callDepth() <= depth
+	})
+	return nil
+}
+
+// StepOut runs until the current function returns.
+func (d *Debugger) StepOut() error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+	if d.calls == nil {
+		return fmt.Errorf("the call depth isn't being tracked")
+	}
+
+	depth := d.callDepth()
+	if depth == 0 {
+		return fmt.Errorf("not in a call")
+	}
+
+	d.runUntil("the end of the function", func(uint16) bool {
+		return d.callDepth() < depth
+	})
+	return nil
+}
+
+// RunTo runs until the instruction at address is about to be executed.
+func (d *Debugger) RunTo(address uint16) error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	d.runUntil(fmt.Sprintf("%04X", address), func(pc uint16) bool { return pc == address })
+	return nil
+}
+
+// RunToNextFrame runs until VBlank next starts. While the LCD is off there's no VBlank, so it runs until the cycle
+// count reaches the next frame instead.
+func (d *Debugger) RunToNextFrame() error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+	if d.clock == nil {
+		return fmt.Errorf("the cycle count isn't available")
+	}
+
+	next := (d.clock()/d.cyclesPerFrame + 1) * d.cyclesPerFrame
+	inVBlank := d.memory.PeekByte(lyRegister) >= vblankLine
+	d.runUntil("the next frame", func(uint16) bool {
+		if d.memory.PeekByte(lcdcRegister)&lcdEnable == 0 {
+			return d.clock() >= next
+		}
+
+		wasVBlank := inVBlank
+		inVBlank = d.memory.PeekByte(lyRegister) >= vblankLine
+		return inVBlank && !wasVBlank
+	})
+	return nil
+}
+
+// RunToNextScanline runs until the LCD moves on to the next scanline.
+func (d *Debugger) RunToNextScanline() error {
+	if err := d.attached(); err != nil {
+		return err
+	}
+
+	start := d.memory.PeekByte(lyRegister)
+	d.runUntil("the next scanline", func(uint16) bool {
+		return d.memory.PeekByte(lyRegister) != start
+	})
+	return nil
+}
+
+// runUntil runs until reached returns true, continuing execution if it's stopped. reached is called with the
+// program counter before each instruction, starting with the one after the current instruction. Anything else
+// that stops execution, like a breakpoint, cancels it.
+func (d *Debugger) runUntil(description string, reached func(address uint16) bool) {
+	d.target = reached
+	d.targetDescription = description
+	d.targetStarted = false
+
+	d.Continue()
+}
+
+// checkTarget returns true if the target of a step over, step out or run to has been reached.
+func (d *Debugger) checkTarget(address uint16) bool {
+	// the current instruction always runs first
+	if !d.targetStarted {
+		d.targetStarted = true
+		return false
+	}
+
+	return d.target(address)
+}
+
+// stopAtTarget stops execution once the target of a step over, step out or run to has been reached.
+func (d *Debugger) stopAtTarget(address uint16, bank int) {
+	log.Printf("Reached %s at %s\n", d.targetDescription, d.Describe(bank, address))
+
+	d.stop(address, true)
+	d.notifyStop(StopEvent{Reason: StopTarget, Address: address, Bank: bank})
+	d.RunCallbacks("target_reached", map[string]interface{}{"address": address, "bank": bank})
+}
+
+// callDepth returns the current call depth, or 0 if it isn't being tracked.
+func (d *Debugger) callDepth() int {
+	if d.calls == nil {
+		return 0
+	}
+	return d.calls.CallDepth()
+}
+
+// isCall returns true if opcode is a CALL or RST instruction.
+func isCall(opcode byte) bool {
+	switch opcode {
+	case 0xCD, 0xC4, 0xCC, 0xD4, 0xDC:
+		return true
+	}
+
+	// RST 00 through RST 38
+	return opcode&0xC7 == 0xC7
+}
+
+// attachSteppingFunctions adds the step over, step out and run to functions to the javascript vm
+func (d *Debugger) attachSteppingFunctions() {
+	run := func(fn func() error) otto.Value {
+		if err := fn(); err != nil {
+			log.Println(err)
+		}
+		return otto.Value{}
+	}
+
+	// stepOver() executes the next instruction, running calls until they return
+	d.vm.Set("stepOver", func(call otto.FunctionCall) otto.Value {
+		return run(d.StepOver)
+	})
+
+	// stepOut() runs until the current function returns
+	d.vm.Set("stepOut", func(call otto.FunctionCall) otto.Value {
+		return run(d.StepOut)
+	})
+
+	// runTo(address) runs until the instruction at address is about to be executed
+	d.vm.Set("runTo", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		return run(func() error { return d.RunTo(uint16(address)) })
+	})
+
+	// runToNextFrame() runs until VBlank next starts
+	d.vm.Set("runToNextFrame", func(call otto.FunctionCall) otto.Value {
+		return run(d.RunToNextFrame)
+	})
+
+	// stepBack() goes back to the last state saved for rewinding
+	d.vm.Set("stepBack", func(call otto.FunctionCall) otto.Value {
+		return run(d.StepBack)
+	})
+
+	// runToNextScanline() runs until the next scanline starts
+	d.vm.Set("runToNextScanline", func(call otto.FunctionCall) otto.Value {
+		return run(d.RunToNextScanline)
+	})
+}
@@ -0,0 +1,147 @@
+package debugger_test
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"testing"
+)
+
+// cyclesPerFrame is how many cycles a frame lasts
+const cyclesPerFrame = 70224
+
+// resume continues execution the way the system does when the debugger says to.
+func resume(t *testing.T, d *debugger.Debugger) {
+	select {
+	case <-d.Cont:
+		d.Resume()
+	default:
+		t.Fatal("Expected execution to continue")
+	}
+}
+
+func TestStepOverCall(t *testing.T) {
+	d, sys := newConsoleDebugger()
+	copy(sys.Memory[0x0100:], []byte{0xCD, 0x00, 0x02})
+
+	d.Execute("pause")
+	if output := d.Execute("next"); output != "Running until the call returns\n" {
+		t.Fatalf("Expected next to run the call, but got %q", output)
+	}
+	resume(t, d)
+
+	// the call, then a recursive call that returns to the same address
+	if d.CheckBreakpoint(0x0100, 0) {
+		t.Error("Expected the call to be executed")
+	}
+	sys.Frames = make([]debugger.Frame, 1)
+	d.CheckBreakpoint(0x0200, 0)
+	sys.Frames = make([]debugger.Frame, 2)
+	if d.CheckBreakpoint(0x0103, 0) {
+		t.Error("Expected a nested return not to stop execution")
+	}
+
+	sys.Frames = make([]debugger.Frame, 0)
+	if !d.CheckBreakpoint(0x0103, 0) || !d.BreakpointActive {
+		t.Error("Expected execution to stop once the call returned")
+	}
+}
+
+func TestStepOverInstruction(t *testing.T) {
+	d, sys := newConsoleDebugger()
+
+	// stepping over anything that isn't a call is the same as stepping
+	d.Execute("pause")
+	if output := d.Execute("next"); output != "00:0101  3C        INC A\n" || sys.PC != 0x0101 {
+		t.Errorf("Expected next to step a single instruction, but got %q", output)
+	}
+}
+
+func TestStepOut(t *testing.T) {
+	d, sys := newConsoleDebugger()
+
+	d.Execute("pause")
+	if output := d.Execute("finish"); output != "not in a call\n" {
+		t.Errorf("Expected finish outside of a call to fail, but got %q", output)
+	}
+
+	sys.Frames = make([]debugger.Frame, 2)
+	d.Execute("finish")
+	resume(t, d)
+
+	d.CheckBreakpoint(0x0100, 0)
+	if d.CheckBreakpoint(0x0101, 0) {
+		t.Error("Expected execution to continue inside the function")
+	}
+
+	sys.Frames = make([]debugger.Frame, 1)
+	if !d.CheckBreakpoint(0x0203, 0) {
+		t.Error("Expected execution to stop once the function returned")
+	}
+}
+
+func TestRunTo(t *testing.T) {
+	d, _ := newConsoleDebugger()
+	d.AddBreakpoint(0x0180, -1)
+
+	d.Execute("pause")
+	d.Execute("until 0150")
+	resume(t, d)
+
+	d.CheckBreakpoint(0x0100, 0)
+	if !d.CheckBreakpoint(0x0150, 0) {
+		t.Fatal("Expected execution to stop at 0150")
+	}
+
+	// breakpoints cancel running to an address
+	d.Execute("until 0200")
+	resume(t, d)
+	d.CheckBreakpoint(0x0150, 0)
+	d.CheckBreakpoint(0x0180, 0)
+	d.Resume()
+	d.CheckBreakpoint(0x0180, 0)
+	if d.CheckBreakpoint(0x0200, 0) {
+		t.Error("Expected the breakpoint to cancel running to 0200")
+	}
+}
+
+func TestRunToNextFrame(t *testing.T) {
+	d, sys := newConsoleDebugger()
+	if output := d.Execute("frame"); output != "the cycle count isn't available\n" {
+		t.Errorf("Expected running to the next frame to need the cycle count, but got %q", output)
+	}
+
+	cycles := uint64(cyclesPerFrame + 100)
+	d.AttachClock(func() uint64 { return cycles }, cyclesPerFrame)
+
+	d.Execute("pause")
+	d.Execute("frame")
+	resume(t, d)
+
+	d.CheckBreakpoint(0x0100, 0)
+	cycles = 2*cyclesPerFrame - 4
+	if d.CheckBreakpoint(0x0101, 0) {
+		t.Error("Expected execution to continue until the frame's cycles had run")
+	}
+
+	cycles = 2 * cyclesPerFrame
+	if !d.CheckBreakpoint(0x0102, 0) {
+		t.Error("Expected execution to stop when the next frame started")
+	}
+
+	// with the LCD on it runs until VBlank starts, wherever the cycle count is
+	sys.Memory[0xFF40] = 0x80
+	sys.Memory[0xFF44] = 100
+	d.Execute("frame")
+	resume(t, d)
+
+	d.CheckBreakpoint(0x0102, 0)
+	cycles = 4 * cyclesPerFrame
+	sys.Memory[0xFF44] = 143
+	if d.CheckBreakpoint(0x0103, 0) {
+		t.Error("Expected execution to continue until VBlank started")
+	}
+
+	sys.Memory[0xFF44] = 144
+	if !d.CheckBreakpoint(0x0104, 0) {
+		t.Error("Expected execution to stop when VBlank started")
+	}
+}
@@ -0,0 +1,113 @@
+package debugger
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/disasm"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"strconv"
+	"strings"
+)
+
+// AttachSymbols sets the symbols used to name addresses and to find labels given in place of addresses.
+func (d *Debugger) AttachSymbols(table *symbols.Table) {
+	d.symbols = table
+}
+
+// ParseLocation parses a label, a hex address or a BANK:ADDRESS pair. The bank is -1 when it isn't known.
+func (d *Debugger) ParseLocation(location string) (int, uint16, error) {
+	if d.symbols != nil {
+		if sym, ok := d.symbols.Lookup(location); ok {
+			return sym.Bank, sym.Address, nil
+		}
+	}
+
+	bank := -1
+	if i := strings.Index(location, ":"); i >= 0 {
+		parsed, err := strconv.ParseUint(location[:i], 16, 8)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s is not a valid bank", location[:i])
+		}
+		bank = int(parsed)
+		location = location[i+1:]
+	}
+
+	address, err := parseHexAddress(location)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s is not a valid address or label", location)
+	}
+	return bank, address, nil
+}
+
+// parseAddress parses a label or hex address, ignoring the bank.
+func (d *Debugger) parseAddress(location string) (uint16, error) {
+	_, address, err := d.ParseLocation(location)
+	return address, err
+}
+
+// Symbols returns the symbols that have been attached, or nil if there aren't any.
+func (d *Debugger) Symbols() *symbols.Table {
+	return d.symbols
+}
+
+// Describe formats an address qualified by its bank, followed by the label it's at or in when symbols are loaded,
+// e.g. "00:0153 Main+$3".
+func (d *Debugger) Describe(bank int, address uint16) string {
+	formatted := disasm.FormatAddress(bank, address)
+	if d.symbols != nil {
+		if label := d.symbols.Describe(bank, address); label != "" {
+			formatted += " " + label
+		}
+	}
+	return formatted
+}
+
+// disasmSymbols returns the symbols to decode instructions with, or nil if none are loaded.
+func (d *Debugger) disasmSymbols() disasm.Symbols {
+	if d.symbols == nil {
+		return nil
+	}
+	return d.symbols
+}
+
+// attachSymbolFunctions adds the symbol functions to the javascript vm
+func (d *Debugger) attachSymbolFunctions() {
+	// symbolAt(address, [bank]) returns the label at or just before address, like "Main.loop+$2"
+	d.vm.Set("symbolAt", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		if d.symbols == nil {
+			return otto.Value{}
+		}
+
+		bank := int64(0)
+		if call.Argument(1).IsDefined() {
+			bank, _ = call.Argument(1).ToInteger()
+		} else if d.memory != nil {
+			bank = int64(d.memory.Bank(uint16(address)))
+		}
+
+		label := d.symbols.Describe(int(bank), uint16(address))
+		if label == "" {
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(label)
+		return val
+	})
+
+	// addressOf(label) returns an object with the bank and address of a label
+	d.vm.Set("addressOf", func(call otto.FunctionCall) otto.Value {
+		name, _ := call.Argument(0).ToString()
+		if d.symbols == nil {
+			return otto.Value{}
+		}
+
+		sym, ok := d.symbols.Lookup(name)
+		if !ok {
+			return otto.Value{}
+		}
+
+		val, _ := call.Otto.ToValue(map[string]interface{}{"bank": sym.Bank, "address": sym.Address})
+		return val
+	})
+}
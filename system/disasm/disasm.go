@@ -0,0 +1,174 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Memory is anything instructions can be decoded from. Bank returns the ROM bank that is mapped in at an
+// address so decoded instructions can be bank qualified.
+type Memory interface {
+	ReadByte(location uint16) byte
+	Bank(location uint16) int
+}
+
+// Symbols names addresses, like the labels in a symbol file. When decoding with symbols, addresses that are
+// jumped to, called or loaded from are shown by name.
+type Symbols interface {
+	Name(bank int, address uint16) (string, bool)
+}
+
+// Instruction is a single decoded instruction.
+type Instruction struct {
+	// Bank is the ROM bank the instruction was decoded from. Addresses outside of the switchable
+	// ROM bank area are always bank 0.
+	Bank int
+
+	// Address is where the instruction starts in the memory map
+	Address uint16
+
+	// Bytes holds the opcode and operands, including the 0xCB prefix for extended instructions
+	Bytes []byte
+
+	// Text is the fully formatted instruction, e.g. "LD BC,$FFFE"
+	Text string
+
+	// Label is the name of the symbol at Address when decoded with symbols, if there is one
+	Label string
+}
+
+// Len returns the number of bytes the instruction occupies.
+func (i Instruction) Len() uint16 {
+	return uint16(len(i.Bytes))
+}
+
+// String formats the instruction as a line of a disassembly listing: the bank qualified address, the raw
+// bytes and then the instruction itself.
+func (i Instruction) String() string {
+	raw := make([]string, len(i.Bytes))
+	for n, b := range i.Bytes {
+		raw[n] = fmt.Sprintf("%02X", b)
+	}
+
+	return fmt.Sprintf("%s  %-8s  %s", FormatAddress(i.Bank, i.Address), strings.Join(raw, " "), i.Text)
+}
+
+// FormatAddress formats an address qualified by its bank in the same BB:AAAA form that RGBDS and most
+// debuggers use.
+func FormatAddress(bank int, address uint16) string {
+	return fmt.Sprintf("%02X:%04X", bank, address)
+}
+
+// Decode decodes the instruction at the given address. Bytes that aren't a valid opcode are decoded as
+// a single "DB" byte so that disassembly can carry on past them.
+func Decode(mem Memory, address uint16) Instruction {
+	return DecodeWithSymbols(mem, nil, address)
+}
+
+// DecodeWithSymbols decodes the instruction at the given address, naming addresses with syms.
+func DecodeWithSymbols(mem Memory, syms Symbols, address uint16) Instruction {
+	inst := Instruction{Bank: mem.Bank(address), Address: address}
+	if syms != nil {
+		inst.Label, _ = syms.Name(inst.Bank, address)
+	}
+
+	opcode := mem.ReadByte(address)
+	inst.Bytes = append(inst.Bytes, opcode)
+
+	mnemonic := baseMnemonics[opcode]
+	if opcode == 0xCB {
+		opcode = mem.ReadByte(address + 1)
+		inst.Bytes = append(inst.Bytes, opcode)
+		mnemonic = extendedMnemonics[opcode]
+	}
+
+	if mnemonic == "" {
+		inst.Text = fmt.Sprintf("DB $%02X", opcode)
+		return inst
+	}
+
+	// read however many operand bytes the placeholder in the mnemonic calls for
+	start := address + uint16(len(inst.Bytes))
+	for n := 0; n < operandCount(mnemonic); n++ {
+		inst.Bytes = append(inst.Bytes, mem.ReadByte(start+uint16(n)))
+	}
+
+	inst.Text = formatOperands(mnemonic, inst, func(target uint16) string {
+		if syms != nil {
+			if name, ok := syms.Name(mem.Bank(target), target); ok {
+				return name
+			}
+		}
+		return fmt.Sprintf("$%04X", target)
+	})
+	return inst
+}
+
+// Disassemble decodes every instruction starting at from up to and including the one that starts at to.
+func Disassemble(mem Memory, from, to uint16) []Instruction {
+	return DisassembleWithSymbols(mem, nil, from, to)
+}
+
+// DisassembleWithSymbols disassembles like Disassemble, naming addresses with syms.
+func DisassembleWithSymbols(mem Memory, syms Symbols, from, to uint16) []Instruction {
+	var insts []Instruction
+
+	for address := uint32(from); address <= uint32(to); {
+		inst := DecodeWithSymbols(mem, syms, uint16(address))
+		insts = append(insts, inst)
+		address += uint32(inst.Len())
+	}
+
+	return insts
+}
+
+// formatOperands replaces the operand placeholders in a mnemonic with the values of the decoded instruction.
+// Addresses that are jumped to, called or loaded from are formatted with target.
+func formatOperands(mnemonic string, inst Instruction, target func(address uint16) string) string {
+	operands := inst.Bytes[len(inst.Bytes)-operandCount(mnemonic):]
+
+	switch {
+	case strings.HasPrefix(mnemonic, "RST "):
+		return "RST $" + strings.TrimSuffix(mnemonic[4:], "H")
+	case mnemonic == "STOP d8":
+		// STOP is followed by a padding byte that isn't really an operand
+		return "STOP"
+	case strings.Contains(mnemonic, "d16"):
+		return strings.Replace(mnemonic, "d16", fmt.Sprintf("$%04X", word(operands)), 1)
+	case strings.Contains(mnemonic, "a16"):
+		return strings.Replace(mnemonic, "a16", target(word(operands)), 1)
+	case strings.Contains(mnemonic, "d8"):
+		return strings.Replace(mnemonic, "d8", fmt.Sprintf("$%02X", operands[0]), 1)
+	case strings.Contains(mnemonic, "a8"):
+		return strings.Replace(mnemonic, "a8", fmt.Sprintf("$%04X", 0xFF00+uint16(operands[0])), 1)
+	case strings.Contains(mnemonic, "SP+r8"):
+		offset := int8(operands[0])
+		if offset < 0 {
+			return strings.Replace(mnemonic, "SP+r8", fmt.Sprintf("SP-%d", -int(offset)), 1)
+		}
+		return strings.Replace(mnemonic, "r8", fmt.Sprintf("%d", offset), 1)
+	case strings.HasPrefix(mnemonic, "ADD SP"):
+		return strings.Replace(mnemonic, "r8", fmt.Sprintf("%d", int8(operands[0])), 1)
+	case strings.Contains(mnemonic, "r8"):
+		// relative jumps are shown with the address they jump to
+		return strings.Replace(mnemonic, "r8", target(inst.Address+inst.Len()+uint16(int8(operands[0]))), 1)
+	}
+
+	return mnemonic
+}
+
+// operandCount returns how many operand bytes a mnemonic has.
+func operandCount(mnemonic string) int {
+	switch {
+	case strings.Contains(mnemonic, "d16") || strings.Contains(mnemonic, "a16"):
+		return 2
+	case strings.Contains(mnemonic, "d8") || strings.Contains(mnemonic, "a8") || strings.Contains(mnemonic, "r8"):
+		return 1
+	}
+	return 0
+}
+
+// word returns a little endian word from two operand bytes.
+func word(operands []byte) uint16 {
+	return uint16(operands[0]) | uint16(operands[1])<<8
+}
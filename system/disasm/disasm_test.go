@@ -0,0 +1,135 @@
+package disasm
+
+import (
+	"testing"
+)
+
+// flatMemory is unbanked memory for decoding bytes written at the start of the address space.
+type flatMemory []byte
+
+func (m flatMemory) ReadByte(location uint16) byte {
+	if int(location) >= len(m) {
+		return 0x00
+	}
+	return m[location]
+}
+
+func (m flatMemory) Bank(location uint16) int {
+	return 0
+}
+
+func assertText(t *testing.T, expected string, inst Instruction) {
+	if inst.Text != expected {
+		t.Errorf("Expected %s, but got %s", expected, inst.Text)
+	}
+}
+
+func TestDecodeOperands(t *testing.T) {
+	tests := []struct {
+		code     []byte
+		expected string
+	}{
+		{[]byte{0x00}, "NOP"},
+		{[]byte{0x01, 0xFE, 0xFF}, "LD BC,$FFFE"},
+		{[]byte{0x06, 0x04}, "LD B,$04"},
+		{[]byte{0x20, 0x05}, "JR NZ,$0007"},
+		{[]byte{0x18, 0xFE}, "JR $0000"},
+		{[]byte{0xE0, 0x44}, "LDH ($FF44),A"},
+		{[]byte{0xEA, 0x00, 0xC0}, "LD ($C000),A"},
+		{[]byte{0xF8, 0xFE}, "LD HL,SP-2"},
+		{[]byte{0xF8, 0x05}, "LD HL,SP+5"},
+		{[]byte{0xE8, 0xFC}, "ADD SP,-4"},
+		{[]byte{0x76}, "HALT"},
+		{[]byte{0x7E}, "LD A,(HL)"},
+		{[]byte{0x9A}, "SBC A,D"},
+		{[]byte{0xFF}, "RST $38"},
+		{[]byte{0x10, 0x00}, "STOP"},
+		{[]byte{0xD3}, "DB $D3"},
+	}
+
+	for _, test := range tests {
+		inst := Decode(flatMemory(test.code), 0)
+		assertText(t, test.expected, inst)
+
+		if int(inst.Len()) != len(test.code) {
+			t.Errorf("Expected %s to be %d bytes, but was %d", test.expected, len(test.code), inst.Len())
+		}
+	}
+}
+
+func TestDecodeExtended(t *testing.T) {
+	assertText(t, "BIT 7,H", Decode(flatMemory{0xCB, 0x7C}, 0))
+	assertText(t, "RL C", Decode(flatMemory{0xCB, 0x11}, 0))
+	assertText(t, "SWAP (HL)", Decode(flatMemory{0xCB, 0x36}, 0))
+	assertText(t, "SET 0,A", Decode(flatMemory{0xCB, 0xC7}, 0))
+}
+
+func TestRelativeJumpFromAddress(t *testing.T) {
+	mem := make(flatMemory, 0x10)
+	mem[0x0A] = 0x20
+	mem[0x0B] = 0xFB
+
+	assertText(t, "JR NZ,$0007", Decode(mem, 0x0A))
+}
+
+func TestDisassemble(t *testing.T) {
+	mem := flatMemory{0x31, 0xFE, 0xFF, 0xAF, 0x21, 0xFF, 0x9F, 0xCB, 0x7C}
+	insts := Disassemble(mem, 0x0000, 0x0007)
+
+	expected := []string{"LD SP,$FFFE", "XOR A", "LD HL,$9FFF", "BIT 7,H"}
+	if len(insts) != len(expected) {
+		t.Fatalf("Expected %d instructions, but got %d", len(expected), len(insts))
+	}
+	for i := range expected {
+		assertText(t, expected[i], insts[i])
+	}
+
+	if insts[2].String() != "00:0004  21 FF 9F  LD HL,$9FFF" {
+		t.Errorf("Unexpected listing line: %s", insts[2].String())
+	}
+}
+
+func TestROMBanks(t *testing.T) {
+	data := make([]byte, romBankSize*3)
+	data[0x0000] = 0x00
+	data[romBankSize*2] = 0xC3
+	data[romBankSize*2+1] = 0x50
+	data[romBankSize*2+2] = 0x41
+
+	rom := NewROM(data, 2)
+	if rom.Banks() != 3 {
+		t.Errorf("Expected 3 banks, but got %d", rom.Banks())
+	}
+
+	inst := Decode(rom, 0x4000)
+	assertText(t, "JP $4150", inst)
+	if inst.Bank != 2 {
+		t.Errorf("Expected the instruction to be in bank 2, but was in %d", inst.Bank)
+	}
+
+	if Decode(rom, 0x0000).Bank != 0 {
+		t.Error("Expected the fixed ROM area to be bank 0")
+	}
+}
+
+// testSymbols names a couple of addresses
+type testSymbols map[uint16]string
+
+func (s testSymbols) Name(bank int, address uint16) (string, bool) {
+	name, ok := s[address]
+	return name, ok
+}
+
+func TestDecodeWithSymbols(t *testing.T) {
+	mem := flatMemory{0xCD, 0x08, 0x00, 0x18, 0x03, 0xEA, 0x00, 0xC0, 0xC9}
+	syms := testSymbols{0x0000: "Main", 0x0008: "Helper", 0xC000: "wCounter"}
+
+	insts := DisassembleWithSymbols(mem, syms, 0x0000, 0x0005)
+	assertText(t, "CALL Helper", insts[0])
+	assertText(t, "JR Helper", insts[1])
+	assertText(t, "LD (wCounter),A", insts[2])
+
+	if insts[0].Label != "Main" || insts[1].Label != "" {
+		t.Errorf("Expected only the first instruction to be labeled, but got %q and %q", insts[0].Label, insts[1].Label)
+	}
+}
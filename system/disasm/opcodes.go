@@ -0,0 +1,68 @@
+package disasm
+
+import (
+	"fmt"
+)
+
+// Mnemonics are written the same way as the opcode table at http://www.pastraiser.com/cpu/gameboy/gameboy_opcodes.html
+// where the operand placeholders are:
+//
+//	d8:  immediate 8-bit data
+//	d16: immediate 16-bit data
+//	a8:  8-bit unsigned offset added to $FF00
+//	a16: 16-bit address
+//	r8:  8-bit signed offset
+//
+// The placeholders are replaced with the actual operand values when an instruction is decoded. Opcodes that
+// don't exist on the gameboy are left as empty strings.
+var baseMnemonics = [256]string{
+	0x00: "NOP", 0x01: "LD BC,d16", 0x02: "LD (BC),A", 0x03: "INC BC", 0x04: "INC B", 0x05: "DEC B", 0x06: "LD B,d8", 0x07: "RLCA",
+	0x08: "LD (a16),SP", 0x09: "ADD HL,BC", 0x0A: "LD A,(BC)", 0x0B: "DEC BC", 0x0C: "INC C", 0x0D: "DEC C", 0x0E: "LD C,d8", 0x0F: "RRCA",
+	0x10: "STOP d8", 0x11: "LD DE,d16", 0x12: "LD (DE),A", 0x13: "INC DE", 0x14: "INC D", 0x15: "DEC D", 0x16: "LD D,d8", 0x17: "RLA",
+	0x18: "JR r8", 0x19: "ADD HL,DE", 0x1A: "LD A,(DE)", 0x1B: "DEC DE", 0x1C: "INC E", 0x1D: "DEC E", 0x1E: "LD E,d8", 0x1F: "RRA",
+	0x20: "JR NZ,r8", 0x21: "LD HL,d16", 0x22: "LD (HL+),A", 0x23: "INC HL", 0x24: "INC H", 0x25: "DEC H", 0x26: "LD H,d8", 0x27: "DAA",
+	0x28: "JR Z,r8", 0x29: "ADD HL,HL", 0x2A: "LD A,(HL+)", 0x2B: "DEC HL", 0x2C: "INC L", 0x2D: "DEC L", 0x2E: "LD L,d8", 0x2F: "CPL",
+	0x30: "JR NC,r8", 0x31: "LD SP,d16", 0x32: "LD (HL-),A", 0x33: "INC SP", 0x34: "INC (HL)", 0x35: "DEC (HL)", 0x36: "LD (HL),d8", 0x37: "SCF",
+	0x38: "JR C,r8", 0x39: "ADD HL,SP", 0x3A: "LD A,(HL-)", 0x3B: "DEC SP", 0x3C: "INC A", 0x3D: "DEC A", 0x3E: "LD A,d8", 0x3F: "CCF",
+
+	0xC0: "RET NZ", 0xC1: "POP BC", 0xC2: "JP NZ,a16", 0xC3: "JP a16", 0xC4: "CALL NZ,a16", 0xC5: "PUSH BC", 0xC6: "ADD A,d8", 0xC7: "RST 00H",
+	0xC8: "RET Z", 0xC9: "RET", 0xCA: "JP Z,a16", 0xCB: "PREFIX CB", 0xCC: "CALL Z,a16", 0xCD: "CALL a16", 0xCE: "ADC A,d8", 0xCF: "RST 08H",
+	0xD0: "RET NC", 0xD1: "POP DE", 0xD2: "JP NC,a16", 0xD4: "CALL NC,a16", 0xD5: "PUSH DE", 0xD6: "SUB d8", 0xD7: "RST 10H",
+	0xD8: "RET C", 0xD9: "RETI", 0xDA: "JP C,a16", 0xDC: "CALL C,a16", 0xDE: "SBC A,d8", 0xDF: "RST 18H",
+	0xE0: "LDH (a8),A", 0xE1: "POP HL", 0xE2: "LD (C),A", 0xE5: "PUSH HL", 0xE6: "AND d8", 0xE7: "RST 20H",
+	0xE8: "ADD SP,r8", 0xE9: "JP (HL)", 0xEA: "LD (a16),A", 0xEE: "XOR d8", 0xEF: "RST 28H",
+	0xF0: "LDH A,(a8)", 0xF1: "POP AF", 0xF2: "LD A,(C)", 0xF3: "DI", 0xF5: "PUSH AF", 0xF6: "OR d8", 0xF7: "RST 30H",
+	0xF8: "LD HL,SP+r8", 0xF9: "LD SP,HL", 0xFA: "LD A,(a16)", 0xFB: "EI", 0xFE: "CP d8", 0xFF: "RST 38H",
+}
+
+// extendedMnemonics are the mnemonics for the opcodes following a 0xCB prefix.
+var extendedMnemonics [256]string
+
+// registerOperands is the order registers are encoded in the low 3 bits of the register to register loads,
+// the ALU operations and all of the extended opcodes.
+var registerOperands = []string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+func init() {
+	// 0x40-0x7F are loads between registers, with HALT in the spot where LD (HL),(HL) would be
+	for i := 0x40; i < 0x80; i++ {
+		baseMnemonics[i] = fmt.Sprintf("LD %s,%s", registerOperands[(i>>3)&7], registerOperands[i&7])
+	}
+	baseMnemonics[0x76] = "HALT"
+
+	// 0x80-0xBF are the ALU operations on the accumulator
+	aluOps := []string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	for i := 0x80; i < 0xC0; i++ {
+		baseMnemonics[i] = aluOps[(i>>3)&7] + registerOperands[i&7]
+	}
+
+	// the extended opcodes are shifts and rotates in the first quarter, then BIT, RES and SET for every bit
+	shiftOps := []string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+	for i := 0; i < 0x40; i++ {
+		extendedMnemonics[i] = shiftOps[i>>3] + " " + registerOperands[i&7]
+	}
+
+	bitOps := []string{"BIT", "RES", "SET"}
+	for i := 0x40; i < 0x100; i++ {
+		extendedMnemonics[i] = fmt.Sprintf("%s %d,%s", bitOps[(i>>6)-1], (i>>3)&7, registerOperands[i&7])
+	}
+}
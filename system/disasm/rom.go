@@ -0,0 +1,46 @@
+package disasm
+
+const romBankSize = 0x4000
+
+// ROM maps a cartridge ROM image into the address space the same way a cartridge would with a given bank
+// switched in. This makes it possible to disassemble any bank of a ROM file without running it.
+type ROM struct {
+	data []byte
+	bank int
+}
+
+// NewROM returns a ROM with bank switched into 4000-7FFF. Bank 0 is always mapped at 0000-3FFF.
+func NewROM(data []byte, bank int) *ROM {
+	return &ROM{data: data, bank: bank}
+}
+
+// Banks returns the number of banks in the ROM.
+func (r *ROM) Banks() int {
+	return (len(r.data) + romBankSize - 1) / romBankSize
+}
+
+// ReadByte reads a byte from the ROM. Anything outside of the ROM reads as 0xFF, which is what reading
+// from an unmapped area of the bus returns.
+func (r *ROM) ReadByte(location uint16) byte {
+	offset := int(location)
+	if location >= romBankSize*2 {
+		return 0xFF
+	}
+
+	if location >= romBankSize {
+		offset = r.bank*romBankSize + int(location-romBankSize)
+	}
+
+	if offset >= len(r.data) {
+		return 0xFF
+	}
+	return r.data[offset]
+}
+
+// Bank returns the bank mapped in at an address.
+func (r *ROM) Bank(location uint16) int {
+	if location >= romBankSize && location < romBankSize*2 {
+		return r.bank
+	}
+	return 0
+}
@@ -0,0 +1,649 @@
+// Package gdb is a stub for the GDB remote serial protocol, which lets gdb and other frontends that speak it
+// debug the code running in the emulator over TCP.
+//
+// The registers are AF, BC, DE, HL, SP and PC, in that order. Each is 16 bits, sent little endian, which is the
+// same as the first six registers of gdb's z80 target. Addresses are the 16-bit CPU addresses, so breakpoints in
+// the switchable ROM area are hit in every bank.
+//
+// Supported packets:
+//
+//	?               why execution stopped
+//	g G p P         read and write registers
+//	m M             read and write memory. Writes to the ROM area patch the bank that's mapped in.
+//	Z0 Z1 z0 z1     add and remove breakpoints. Software and hardware breakpoints are the same thing here.
+//	Z2 Z3 Z4 z2...  add and remove write, read and access watchpoints
+//	s c             step and continue, optionally from a new address
+//	bs bc           step and continue backwards, when rewinding is on
+//	D k             detach. The breakpoints the client added are removed and execution continues.
+//
+// Sending ctrl-c (0x03) while running stops execution.
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/debugger/expr"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// registers are the registers in the order the protocol sends them
+var registers = []expr.Register{expr.RegAF, expr.RegBC, expr.RegDE, expr.RegHL, expr.RegSP, expr.RegPC}
+
+// targetXML describes the registers to clients that ask for it
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gnu.gdb.z80.cpu">
+    <reg name="af" bitsize="16" type="int"/>
+    <reg name="bc" bitsize="16" type="int"/>
+    <reg name="de" bitsize="16" type="int"/>
+    <reg name="hl" bitsize="16" type="data_ptr"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+// interrupt is the byte a client sends to stop execution
+const interrupt = "\x03"
+
+// Server serves GDB remote protocol clients, one at a time.
+type Server struct {
+	debugger *debugger.Debugger
+	run      func(fn func())
+	listener net.Listener
+
+	// stops gets the breakpoints, watchpoints and targets that stop execution
+	stops chan debugger.StopEvent
+}
+
+// NewServer creates a server that debugs through dbg. run has to run fn on the goroutine running the system and
+// wait for it to finish.
+func NewServer(dbg *debugger.Debugger, run func(fn func())) *Server {
+	s := &Server{debugger: dbg, run: run, stops: make(chan debugger.StopEvent, 1)}
+
+	dbg.OnStop(func(event debugger.StopEvent) {
+		select {
+		case s.stops <- event:
+		default:
+		}
+	})
+
+	return s
+}
+
+// Listen starts serving clients on a TCP address like ":2345". Addresses without a host only listen on localhost.
+func (s *Server) Listen(address string) error {
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	log.Println("Waiting for gdb on", listener.Addr())
+	go s.serve()
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops listening for clients.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// serve accepts clients until the listener is closed.
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		log.Println("gdb connected from", conn.RemoteAddr())
+		newSession(s, conn).serve()
+		conn.Close()
+		log.Println("gdb disconnected")
+	}
+}
+
+// point is a breakpoint or watchpoint added by a client, by the Z packet type and address.
+type point struct {
+	kind    byte
+	address uint16
+}
+
+// packet is a packet received from the client. valid is false when the checksum didn't match.
+type packet struct {
+	data  string
+	valid bool
+}
+
+// session is a connection to a client.
+type session struct {
+	*Server
+	conn    net.Conn
+	packets chan packet
+	done    chan bool
+
+	// noAck is set once the client turns off acknowledgements, and swbreak once it says it understands
+	// swbreak stop reasons
+	noAck   bool
+	swbreak bool
+
+	// running is set while execution continues on the client's behalf
+	running bool
+
+	// points are the ids of the breakpoints and watchpoints the client added
+	points map[point][]int
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	return &session{Server: s, conn: conn, packets: make(chan packet), done: make(chan bool), points: make(map[point][]int)}
+}
+
+// serve handles packets from the client until it detaches or disconnects.
+func (s *session) serve() {
+	go s.read()
+	defer close(s.done)
+
+	// execution stops while a client is connected
+	s.run(func() { s.debugger.Pause() })
+
+	for {
+		select {
+		case pkt, ok := <-s.packets:
+			if !ok {
+				s.detach()
+				return
+			}
+
+			if pkt.data == interrupt {
+				if s.running {
+					s.running = false
+					s.run(func() { s.debugger.Pause() })
+					s.send("S02")
+				}
+				continue
+			}
+
+			if !s.noAck {
+				if !pkt.valid {
+					s.conn.Write([]byte("-"))
+					continue
+				}
+				s.conn.Write([]byte("+"))
+			}
+
+			if s.handle(pkt.data) {
+				return
+			}
+		case event := <-s.stops:
+			if s.running {
+				s.running = false
+				s.send(s.stopReply(event))
+			}
+		}
+	}
+}
+
+// read reads packets from the client until the connection is closed.
+func (s *session) read() {
+	defer close(s.packets)
+	r := bufio.NewReader(s.conn)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		var pkt packet
+		switch b {
+		case interrupt[0]:
+			pkt = packet{data: interrupt, valid: true}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return
+			}
+			pkt = packet{data: data, valid: string(sum) == checksum(data)}
+		default:
+			// anything else, like acknowledgements, is ignored
+			continue
+		}
+
+		select {
+		case s.packets <- pkt:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// send sends a packet to the client.
+func (s *session) send(data string) {
+	fmt.Fprintf(s.conn, "$%s#%s", data, checksum(data))
+}
+
+// checksum is the two digit hex checksum of a packet's data.
+func checksum(data string) string {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return fmt.Sprintf("%02x", sum)
+}
+
+// handle handles a packet and sends the reply. It returns true when the session is over.
+func (s *session) handle(data string) bool {
+	if data == "" {
+		s.send("")
+		return false
+	}
+
+	var reply string
+	var err error
+
+	switch data[0] {
+	case '?':
+		reply = "S05"
+	case 'g':
+		reply, err = s.readRegisters()
+	case 'G':
+		err = s.writeRegisters(data[1:])
+	case 'p':
+		reply, err = s.readRegister(data[1:])
+	case 'P':
+		err = s.writeRegister(data[1:])
+	case 'm':
+		reply, err = s.readMemory(data[1:])
+	case 'M':
+		err = s.writeMemory(data[1:])
+	case 'Z':
+		err = s.addPoint(data[1:])
+	case 'z':
+		err = s.removePoint(data[1:])
+	case 's':
+		if err = s.resumeAt(data[1:]); err == nil {
+			s.run(func() { err = s.debugger.StepInstruction() })
+			reply = "S05"
+		}
+	case 'c':
+		if err = s.resumeAt(data[1:]); err == nil {
+			s.cont()
+			return false
+		}
+	case 'b':
+		reply, err = s.reverse(data[1:])
+	case 'D':
+		s.send("OK")
+		s.detach()
+		return true
+	case 'k':
+		s.detach()
+		return true
+	case 'H':
+		reply = "OK"
+	case 'q', 'Q', 'v':
+		reply = s.query(data)
+	}
+
+	if err != nil {
+		log.Println("gdb:", err)
+		reply = "E01"
+	} else if reply == "" && strings.ContainsRune("GPMZz", rune(data[0])) {
+		reply = "OK"
+	}
+
+	s.send(reply)
+	return false
+}
+
+// query handles the general query and multi-letter packets. Anything that isn't supported gets an empty reply.
+func (s *session) query(data string) string {
+	switch {
+	case strings.HasPrefix(data, "qSupported"):
+		s.swbreak = strings.Contains(data, "swbreak+")
+		return "PacketSize=4000;QStartNoAckMode+;qXfer:features:read+;swbreak+;hwbreak+;ReverseStep+;ReverseContinue+"
+	case data == "QStartNoAckMode":
+		// the OK is still acknowledged
+		s.noAck = true
+		return "OK"
+	case strings.HasPrefix(data, "qXfer:features:read:target.xml:"):
+		return readChunk(targetXML, strings.TrimPrefix(data, "qXfer:features:read:target.xml:"))
+	case data == "qAttached":
+		return "1"
+	case data == "qC":
+		return "QC1"
+	case data == "qfThreadInfo":
+		return "m1"
+	case data == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(data, "qSymbol"):
+		return "OK"
+	}
+
+	return ""
+}
+
+// readChunk returns the part of a document asked for with an OFFSET,LENGTH qXfer request.
+func readChunk(document, request string) string {
+	offset, length, err := parseRange(request)
+	if err != nil || offset >= len(document) {
+		return "l"
+	}
+
+	if offset+length >= len(document) {
+		return "l" + document[offset:]
+	}
+	return "m" + document[offset:offset+length]
+}
+
+// cont continues execution until something stops it or the client interrupts.
+func (s *session) cont() {
+	s.run(func() {
+		// forget anything that stopped execution before now
+		select {
+		case <-s.stops:
+		default:
+		}
+		s.debugger.Continue()
+	})
+	s.running = true
+}
+
+// reverse handles the bs and bc packets, which run backwards a step or to the last breakpoint or watchpoint hit.
+// Running backwards needs rewinding to be on.
+func (s *session) reverse(data string) (string, error) {
+	var found bool
+	var err error
+
+	switch data {
+	case "s":
+		s.run(func() { err = s.debugger.ReverseStep() })
+		found = true
+	case "c":
+		s.run(func() { found, err = s.debugger.ReverseContinue() })
+	default:
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "T05replaylog:begin;", nil
+	}
+	return "S05", nil
+}
+
+// resumeAt sets the program counter before stepping or continuing, if the packet has an address.
+func (s *session) resumeAt(address string) error {
+	if address == "" {
+		return nil
+	}
+
+	pc, err := strconv.ParseUint(address, 16, 16)
+	if err != nil {
+		return err
+	}
+	return s.writeRegisterValue(expr.RegPC, int(pc))
+}
+
+// detach removes the client's breakpoints and watchpoints and lets execution continue.
+func (s *session) detach() {
+	s.run(func() {
+		for _, ids := range s.points {
+			for _, id := range ids {
+				s.debugger.RemoveBreakpoint(id)
+			}
+		}
+		s.debugger.Continue()
+	})
+	s.points = make(map[point][]int)
+}
+
+// stopReply is the stop reply packet for a breakpoint, watchpoint or target stopping execution.
+func (s *session) stopReply(event debugger.StopEvent) string {
+	switch {
+	case event.Reason == debugger.StopBreakpoint && s.swbreak:
+		return "T05swbreak:;"
+	case event.Reason == debugger.StopWatchpoint:
+		kind := "watch"
+		if event.Access == debugger.WatchRead {
+			kind = "rwatch"
+		}
+		if _, ok := s.points[point{'4', event.Address}]; ok {
+			kind = "awatch"
+		}
+		return fmt.Sprintf("T05%s:%04x;", kind, event.Address)
+	}
+	return "S05"
+}
+
+func (s *session) readRegisters() (string, error) {
+	var out strings.Builder
+	for _, reg := range registers {
+		value, err := s.readRegisterValue(reg)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(encodeWord(value))
+	}
+	return out.String(), nil
+}
+
+func (s *session) writeRegisters(data string) error {
+	for i, reg := range registers {
+		if len(data) < (i+1)*4 {
+			break
+		}
+
+		value, err := decodeWord(data[i*4 : (i+1)*4])
+		if err != nil {
+			return err
+		}
+		if err := s.writeRegisterValue(reg, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) readRegister(data string) (string, error) {
+	reg, err := parseRegister(data)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := s.readRegisterValue(reg)
+	return encodeWord(value), err
+}
+
+func (s *session) writeRegister(data string) error {
+	parts := strings.SplitN(data, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid register write %q", data)
+	}
+
+	reg, err := parseRegister(parts[0])
+	if err != nil {
+		return err
+	}
+
+	value, err := decodeWord(parts[1])
+	if err != nil {
+		return err
+	}
+	return s.writeRegisterValue(reg, value)
+}
+
+func (s *session) readRegisterValue(reg expr.Register) (int, error) {
+	var value int
+	var err error
+	s.run(func() { value, err = s.debugger.ReadRegister(reg) })
+	return value, err
+}
+
+func (s *session) writeRegisterValue(reg expr.Register, value int) error {
+	var err error
+	s.run(func() { err = s.debugger.WriteRegister(reg, value) })
+	return err
+}
+
+func (s *session) readMemory(data string) (string, error) {
+	address, length, err := parseRange(data)
+	if err != nil {
+		return "", err
+	}
+
+	var contents []byte
+	s.run(func() { contents, err = s.debugger.ReadMemory(uint16(address), length) })
+	return hex.EncodeToString(contents), err
+}
+
+func (s *session) writeMemory(data string) error {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid memory write %q", data)
+	}
+
+	address, _, err := parseRange(parts[0])
+	if err != nil {
+		return err
+	}
+
+	contents, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+
+	s.run(func() { err = s.debugger.WriteMemory(uint16(address), contents) })
+	return err
+}
+
+// addPoint adds a breakpoint or watchpoint from a Z packet: TYPE,ADDR,KIND. For watchpoints KIND is the number
+// of bytes to watch.
+func (s *session) addPoint(data string) error {
+	p, length, err := parsePoint(data)
+	if err != nil {
+		return err
+	}
+
+	// adding the same point twice has no effect
+	if _, ok := s.points[p]; ok {
+		return nil
+	}
+
+	access := 0
+	switch p.kind {
+	case '0', '1':
+		s.run(func() { s.points[p] = []int{s.debugger.AddBreakpoint(p.address, -1)} })
+		return nil
+	case '2':
+		access = debugger.WatchWrite
+	case '3':
+		access = debugger.WatchRead
+	case '4':
+		access = debugger.WatchRead | debugger.WatchWrite
+	default:
+		return fmt.Errorf("unsupported breakpoint type %c", p.kind)
+	}
+
+	s.run(func() {
+		for i := 0; i < length; i++ {
+			s.points[p] = append(s.points[p], s.debugger.AddWatchpoint(p.address+uint16(i), access))
+		}
+	})
+	return nil
+}
+
+// removePoint removes a breakpoint or watchpoint added with addPoint.
+func (s *session) removePoint(data string) error {
+	p, _, err := parsePoint(data)
+	if err != nil {
+		return err
+	}
+
+	s.run(func() {
+		for _, id := range s.points[p] {
+			s.debugger.RemoveBreakpoint(id)
+		}
+	})
+	delete(s.points, p)
+	return nil
+}
+
+// parsePoint parses the TYPE,ADDR,KIND of a Z or z packet.
+func parsePoint(data string) (point, int, error) {
+	if len(data) < 2 || data[1] != ',' {
+		return point{}, 0, fmt.Errorf("invalid breakpoint %q", data)
+	}
+
+	address, length, err := parseRange(data[2:])
+	if err != nil {
+		return point{}, 0, err
+	}
+	return point{kind: data[0], address: uint16(address)}, length, nil
+}
+
+// parseRange parses a hex ADDR,LENGTH pair. Anything after the length, like breakpoint conditions, is ignored.
+func parseRange(data string) (int, int, error) {
+	parts := strings.SplitN(strings.SplitN(data, ";", 2)[0], ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid range %q", data)
+	}
+
+	address, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(address), int(length), nil
+}
+
+// parseRegister parses a register number.
+func parseRegister(data string) (expr.Register, error) {
+	n, err := strconv.ParseUint(data, 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return 0, fmt.Errorf("invalid register %q", data)
+	}
+	return registers[n], nil
+}
+
+// encodeWord encodes a register value as little endian hex.
+func encodeWord(value int) string {
+	return hex.EncodeToString([]byte{byte(value), byte(value >> 8)})
+}
+
+// decodeWord decodes a little endian hex register value.
+func decodeWord(data string) (int, error) {
+	b, err := hex.DecodeString(data)
+	if err != nil || len(b) != 2 {
+		return 0, fmt.Errorf("invalid register value %q", data)
+	}
+	return int(b[0]) | int(b[1])<<8, nil
+}
@@ -0,0 +1,160 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/debugger/debugtest"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T) (*Server, *debugtest.System) {
+	sys := debugtest.New(0x0100)
+	sys.HL = 0xC010
+	copy(sys.Memory[0x0100:], []byte{0x3C, 0x3C, 0x3C, 0x3C, 0x77})
+	sys.Start()
+
+	server := NewServer(sys.Debugger, sys.RunCommand)
+	if err := server.Listen("localhost:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	return server, sys
+}
+
+// client is just enough of a gdb client to test the server
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, server *Server) *client {
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send sends a packet without waiting for the reply
+func (c *client) send(data string) {
+	fmt.Fprintf(c.conn, "$%s#%s", data, checksum(data))
+}
+
+// reply reads the next packet, skipping acknowledgements
+func (c *client) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.r.Discard(2)
+	c.conn.Write([]byte("+"))
+
+	return strings.TrimSuffix(data, "#")
+}
+
+// expect sends a packet and checks the reply
+func (c *client) expect(data, expected string) {
+	c.send(data)
+	if reply := c.reply(); reply != expected {
+		c.t.Errorf("Expected %q to reply %q but was %q", data, expected, reply)
+	}
+}
+
+func TestRegistersAndMemory(t *testing.T) {
+	server, sys := startServer(t)
+	defer sys.Stop()
+	defer server.Close()
+
+	c := dial(t, server)
+	defer c.conn.Close()
+
+	c.send("qSupported:swbreak+;hwbreak+")
+	if reply := c.reply(); !strings.Contains(reply, "qXfer:features:read+") {
+		t.Errorf("Expected the target description to be supported but was %q", reply)
+	}
+	c.expect("?", "S05")
+
+	// AF BC DE HL SP PC, little endian
+	c.expect("g", "00000000000010c000000001")
+	c.expect("P0=003f", "OK")
+	c.expect("p0", "003f")
+	c.expect("G0000000000000000feff5001", "OK")
+	c.expect("p5", "5001")
+	c.expect("p4", "feff")
+
+	c.expect("Mc000,2:beef", "OK")
+	c.expect("mc000,3", "beef00")
+	c.expect("mzzzz", "E01")
+
+	if !strings.Contains(c.readTargetXML(), `<reg name="pc"`) {
+		t.Error("Expected the target description to list the registers")
+	}
+}
+
+// readTargetXML reads the whole target description
+func (c *client) readTargetXML() string {
+	var xml string
+	for {
+		c.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,%x", len(xml), 0x100))
+		reply := c.reply()
+		xml += reply[1:]
+		if reply[0] == 'l' {
+			return xml
+		}
+	}
+}
+
+func TestExecution(t *testing.T) {
+	server, sys := startServer(t)
+	defer sys.Stop()
+	defer server.Close()
+
+	c := dial(t, server)
+	defer c.conn.Close()
+
+	c.send("qSupported:swbreak+")
+	c.reply()
+
+	c.expect("s", "S05")
+	c.expect("p5", "0101")
+	c.expect("p0", "0001")
+
+	// continue to a breakpoint
+	c.expect("Z0,103,1", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expect("p5", "0301")
+	c.expect("z0,103,1", "OK")
+
+	// continue until a watchpoint is hit
+	c.expect("Z2,c010,1", "OK")
+	c.expect("c", "T05watch:c010;")
+	c.expect("mc010,1", "04")
+	c.expect("z2,c010,1", "OK")
+
+	// continue until interrupted
+	c.send("c")
+	time.Sleep(10 * time.Millisecond)
+	c.conn.Write([]byte(interrupt))
+	if reply := c.reply(); reply != "S02" {
+		t.Errorf("Expected an interrupt to stop execution but was %q", reply)
+	}
+
+	// detaching lets the system run again
+	c.expect("D", "OK")
+	time.Sleep(10 * time.Millisecond)
+	var active bool
+	sys.RunCommand(func() { active = sys.Debugger.BreakpointActive })
+	if active {
+		t.Error("Expected execution to continue after detaching")
+	}
+}
@@ -0,0 +1,173 @@
+package system
+
+import (
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"github.com/robmerrell/gmboy/system/macro"
+	"log"
+	"math"
+	"time"
+)
+
+// How many times a second the turbo buttons can press A and B. The fastest is pressed on one frame and let go on
+// the next.
+const (
+	DefaultTurboRate = 15
+	maxTurboRate     = 30
+)
+
+// SetTurboRate sets how many times a second the turbo buttons press A and B while they're held, from 1 up to 30.
+func (s *System) SetTurboRate(rate int) error {
+	if rate < 1 || rate > maxTurboRate {
+		return fmt.Errorf("the turbo rate should be from 1 up to %d presses a second", maxTurboRate)
+	}
+
+	// each press is held for half the time between presses and let go for the other half
+	frames := math.Round(float64(time.Second) / float64(frameDuration) / float64(2*rate))
+	s.turboFrames = uint64(math.Max(frames, 1))
+	return nil
+}
+
+// AddMacro adds a macro that can be played by name.
+func (s *System) AddMacro(name string, m macro.Macro) {
+	if s.macros == nil {
+		s.macros = make(map[string]macro.Macro)
+	}
+	s.macros[name] = m
+}
+
+// PlayMacro starts playing the macro called name on the next frame the buttons are read for. Its buttons are held
+// on top of the ones held on the keyboard or joystick.
+func (s *System) PlayMacro(name string) error {
+	m, ok := s.macros[name]
+	if !ok {
+		return fmt.Errorf("there's no macro called %s", name)
+	}
+
+	s.macroPlayer.Play(m, s.lastPolled())
+	return nil
+}
+
+// SetScriptInput holds buttons down in place of the ones held on the keyboard and joystick, from the next frame the
+// buttons are read for until ClearScriptInput hands them back.
+func (s *System) SetScriptInput(buttons joypad.Buttons) {
+	s.scripted = true
+	s.scriptButtons = buttons
+}
+
+// ClearScriptInput lets go of the buttons held by SetScriptInput and PressButtons, and hands the buttons back to the
+// keyboard and joystick.
+func (s *System) ClearScriptInput() {
+	s.scripted = false
+	s.scriptButtons = 0
+	s.scriptPresses.Stop()
+}
+
+// PressButtons holds buttons down for a number of frames, starting on the next one the buttons are read for. The
+// keyboard and joystick are ignored until they're let go, unless they've been handed back by ClearScriptInput.
+func (s *System) PressButtons(buttons joypad.Buttons, frames int) error {
+	if frames < 1 {
+		return fmt.Errorf("buttons have to be pressed for at least a frame")
+	}
+
+	s.scriptPresses.Play(macro.Macro{{Buttons: buttons, Frames: frames}}, s.lastPolled())
+	return nil
+}
+
+// lastPolled returns the last frame the buttons were read for. While the input_poll callbacks run it's the one
+// before the frame that's starting, so buttons pressed from them are held on that frame.
+func (s *System) lastPolled() uint64 {
+	if s.polling {
+		return s.frame - 1
+	}
+	return s.frame
+}
+
+// liveInput returns the buttons held on a frame when nothing is being played back: the ones held on the keyboard or
+// joystick, the turbo buttons on the frames they're pressed and the ones held by macros. Buttons held by a script
+// take the place of the keyboard, joystick and turbo buttons.
+func (s *System) liveInput(frame uint64) joypad.Buttons {
+	var buttons joypad.Buttons
+	if pressed := s.scriptPresses.Buttons(frame); s.scripted || s.scriptPresses.Playing() {
+		buttons = s.scriptButtons | pressed
+	} else {
+		if s.input != nil {
+			buttons = s.input()
+		}
+
+		// turbo buttons are pressed for turboFrames frames and then let go for as long, counting from power on so
+		// they're pressed on the same frames every time
+		if s.turbo != nil && (frame/s.turboFrames)%2 == 0 {
+			buttons |= s.turbo()
+		}
+	}
+
+	return buttons | s.macroPlayer.Buttons(frame)
+}
+
+// attachInput adds the input functions to the debugger's javascript vm.
+func (s *System) attachInput(dbg *debugger.Debugger) {
+	// setInput({a: true, start: false, ...}) holds and lets go of buttons until clearInput() is called. Buttons that
+	// aren't given stay the way they were.
+	dbg.AttachFunction("setInput", func(call otto.FunctionCall) otto.Value {
+		held := call.Argument(0).Object()
+		if held == nil {
+			log.Println("Expected setInput to be given an object like {a: true}")
+			return otto.Value{}
+		}
+
+		buttons := s.scriptButtons
+		for _, name := range held.Keys() {
+			button, err := joypad.ParseButtons(name)
+			if err != nil {
+				log.Println(err)
+				return otto.Value{}
+			}
+
+			value, _ := held.Get(name)
+			if pressed, _ := value.ToBoolean(); pressed {
+				buttons |= button
+			} else {
+				buttons &^= button
+			}
+		}
+
+		s.SetScriptInput(buttons)
+		return otto.Value{}
+	})
+
+	// clearInput() hands the buttons back to the keyboard and joystick
+	dbg.AttachFunction("clearInput", func(call otto.FunctionCall) otto.Value {
+		s.ClearScriptInput()
+		return otto.Value{}
+	})
+
+	// pressButton(name, [frames]) holds a button, or buttons like 'Down+A', for a number of frames, 1 by default
+	dbg.AttachFunction("pressButton", func(call otto.FunctionCall) otto.Value {
+		name, _ := call.Argument(0).ToString()
+		frames := int64(1)
+		if call.Argument(1).IsDefined() {
+			frames, _ = call.Argument(1).ToInteger()
+		}
+
+		buttons, err := joypad.ParseButtons(name)
+		if err == nil {
+			err = s.PressButtons(buttons, int(frames))
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		return otto.Value{}
+	})
+
+	// playMacro(name) plays one of the macros from the config file
+	dbg.AttachFunction("playMacro", func(call otto.FunctionCall) otto.Value {
+		name, _ := call.Argument(0).ToString()
+		if err := s.PlayMacro(name); err != nil {
+			log.Println(err)
+		}
+		return otto.Value{}
+	})
+}
@@ -0,0 +1,77 @@
+package system
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"github.com/robmerrell/gmboy/system/macro"
+	"testing"
+)
+
+func TestTurbo(t *testing.T) {
+	s := newSystem()
+	s.input = func() joypad.Buttons { return joypad.Up }
+	s.turbo = func() joypad.Buttons { return joypad.A }
+
+	if err := s.SetTurboRate(31); err == nil {
+		t.Error("Expected turbo rates over 30 to be refused")
+	}
+
+	// at 15 presses a second A is pressed for 2 frames and let go for 2
+	s.SetTurboRate(15)
+	expected := []joypad.Buttons{joypad.Up | joypad.A, joypad.Up | joypad.A, joypad.Up, joypad.Up, joypad.Up | joypad.A}
+	for i, buttons := range expected {
+		frame := uint64(4 + i)
+		if held := s.liveInput(frame); held != buttons {
+			t.Errorf("Expected %v to be held on frame %d but was %v", buttons, frame, held)
+		}
+	}
+}
+
+func TestPlayMacro(t *testing.T) {
+	s := newSystem()
+	s.AddMacro("jump", macro.Macro{{Buttons: joypad.A, Frames: 2}})
+
+	if err := s.PlayMacro("run"); err == nil {
+		t.Error("Expected playing a macro that doesn't exist to fail")
+	}
+
+	s.frame, s.inputStart = 10, 10
+	s.PlayMacro("jump")
+	for frame := uint64(11); frame <= 13; frame++ {
+		s.frame = frame
+		s.pollInput(frame)
+	}
+
+	expected := []joypad.Buttons{joypad.A, joypad.A, 0}
+	for i, buttons := range expected {
+		if s.inputs[i] != buttons {
+			t.Errorf("Expected %v to be held on frame %d but was %v", buttons, 11+i, s.inputs[i])
+		}
+	}
+}
+
+func TestScriptInput(t *testing.T) {
+	s := newSystem()
+	s.input = func() joypad.Buttons { return joypad.Left }
+	s.debugger = debugger.NewDebugger()
+	s.attachInput(s.debugger)
+
+	// the script presses start on frames 2 and 3, holds A from frame 5 and hands the buttons back on frame 7
+	s.debugger.Execute(`
+		on('input_poll', function(frame) {
+			if (frame == 2) pressButton('start', 2);
+			if (frame == 5) setInput({a: true, start: false});
+			if (frame == 7) clearInput();
+		});
+	`)
+
+	expected := []joypad.Buttons{joypad.Left, joypad.Start, joypad.Start, joypad.Left, joypad.A, joypad.A, joypad.Left, joypad.Left}
+	for i, buttons := range expected {
+		frame := uint64(i + 1)
+		s.frame = frame
+		s.pollInput(frame)
+		if held := s.joypad.Buttons(); held != buttons {
+			t.Errorf("Expected %v to be held on frame %d but was %v", buttons, frame, held)
+		}
+	}
+}
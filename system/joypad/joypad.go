@@ -0,0 +1,151 @@
+package joypad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/mmu"
+	"io"
+	"strings"
+)
+
+// p1Register selects which buttons are read and holds their state
+const p1Register = 0xFF00
+
+// The select bits of P1. Clearing one selects that group of buttons.
+const (
+	selectDirections = 0x10
+	selectButtons    = 0x20
+)
+
+// Buttons is a set of buttons held down, one bit each.
+type Buttons byte
+
+// The buttons. The directions are the low nibble and the rest the high nibble, which is the order they're read from
+// P1 in.
+const (
+	Right Buttons = 1 << iota
+	Left
+	Up
+	Down
+	A
+	B
+	Select
+	Start
+)
+
+// buttonNames are the letters used for each button when writing out a set of buttons, in bit order
+const buttonNames = "RLUDABsS"
+
+// String returns the buttons held as letters, with a dot for each button that isn't held, like "...D A..S".
+func (b Buttons) String() string {
+	var name strings.Builder
+	for i := range buttonNames {
+		if b&(1<<uint(i)) != 0 {
+			name.WriteByte(buttonNames[i])
+		} else {
+			name.WriteByte('.')
+		}
+	}
+	return name.String()
+}
+
+// names are the names of the buttons, for parsing
+var names = map[string]Buttons{
+	"right": Right, "left": Left, "up": Up, "down": Down, "a": A, "b": B, "select": Select, "start": Start,
+}
+
+// ParseButtons parses button names joined with +, like "Down+A". Names aren't case sensitive, and "none" is no
+// buttons.
+func ParseButtons(text string) (Buttons, error) {
+	if strings.EqualFold(text, "none") {
+		return 0, nil
+	}
+
+	var buttons Buttons
+	for _, name := range strings.Split(text, "+") {
+		button, ok := names[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("%q isn't a button", name)
+		}
+		buttons |= button
+	}
+	return buttons, nil
+}
+
+// Joypad emulates the P1 register. A game selects the directions, the buttons or both by clearing bits 4 and 5 and
+// reads the ones held in the low nibble, where a held button reads as 0.
+type Joypad struct {
+	selected byte
+	buttons  Buttons
+
+	mmu *mmu.MMU
+}
+
+// NewJoypad creates a joypad with nothing held and maps P1 into memory.
+func NewJoypad(m *mmu.MMU) *Joypad {
+	j := &Joypad{mmu: m, selected: selectDirections | selectButtons}
+	m.MapIORegister(p1Register, j.read, func(value byte) { j.selected = value & (selectDirections | selectButtons) })
+
+	return j
+}
+
+// Reset deselects both groups of buttons. The buttons held down stay held.
+func (j *Joypad) Reset() {
+	j.selected = selectDirections | selectButtons
+}
+
+// Buttons returns the buttons held down.
+func (j *Joypad) Buttons() Buttons {
+	return j.buttons
+}
+
+// SetButtons changes the buttons held down. Pressing a button in a selected group requests the joypad interrupt.
+func (j *Joypad) SetButtons(buttons Buttons) {
+	before := j.lines()
+	j.buttons = buttons
+
+	// the interrupt is requested when a line goes from high to low
+	if before&^j.lines() != 0 {
+		j.mmu.RequestInterrupt(mmu.InterruptJoypad)
+	}
+}
+
+// read builds the value of P1. The top two bits aren't used and read as 1.
+func (j *Joypad) read() byte {
+	return 0xC0 | j.selected | j.lines()
+}
+
+// lines returns the low nibble of P1, with a bit cleared for each held button in a selected group.
+func (j *Joypad) lines() byte {
+	var held byte
+	if j.selected&selectDirections == 0 {
+		held |= byte(j.buttons) & 0x0F
+	}
+	if j.selected&selectButtons == 0 {
+		held |= byte(j.buttons) >> 4
+	}
+	return 0x0F &^ held
+}
+
+// joypadState is the joypad state that goes into a save state.
+type joypadState struct {
+	Selected byte
+	Buttons  Buttons
+}
+
+// SaveState writes the selected groups and the buttons held to w.
+func (j *Joypad) SaveState(w io.Writer) error {
+	return binary.Write(w, binary.LittleEndian, joypadState{Selected: j.selected, Buttons: j.buttons})
+}
+
+// LoadState restores the selected groups and the buttons held from r.
+func (j *Joypad) LoadState(r io.Reader) error {
+	var state joypadState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+
+	j.selected = state.Selected
+	j.buttons = state.Buttons
+	return nil
+}
@@ -0,0 +1,59 @@
+package joypad
+
+import (
+	"github.com/robmerrell/gmboy/system/mmu"
+	"github.com/robmerrell/gmboy/testhelpers"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	m := mmu.NewMMU()
+	j := NewJoypad(m)
+	j.SetButtons(Down | A | Start)
+
+	// nothing selected
+	testhelpers.AssertByte(t, 0xFF, m.ReadByte(p1Register))
+
+	m.WriteBytes([]byte{selectButtons}, p1Register)
+	testhelpers.AssertByte(t, 0xE7, m.ReadByte(p1Register))
+
+	m.WriteBytes([]byte{selectDirections}, p1Register)
+	testhelpers.AssertByte(t, 0xD6, m.ReadByte(p1Register))
+
+	m.WriteBytes([]byte{0x00}, p1Register)
+	testhelpers.AssertByte(t, 0xC6, m.ReadByte(p1Register))
+}
+
+func TestInterrupt(t *testing.T) {
+	m := mmu.NewMMU()
+	j := NewJoypad(m)
+	m.WriteBytes([]byte{selectButtons}, p1Register)
+
+	// buttons that aren't selected can't be seen, so they don't interrupt
+	j.SetButtons(B)
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0xFF0F))
+
+	j.SetButtons(B | Left)
+	testhelpers.AssertByte(t, mmu.InterruptJoypad, m.ReadByte(0xFF0F))
+}
+
+func TestButtonsString(t *testing.T) {
+	if name := (Up | A | Start).String(); name != "..U.A..S" {
+		t.Errorf("Expected ..U.A..S but was %s", name)
+	}
+}
+
+func TestParseButtons(t *testing.T) {
+	buttons, err := ParseButtons("Down+a + START")
+	if err != nil || buttons != Down|A|Start {
+		t.Errorf("Expected down, A and start but was %v (%v)", buttons, err)
+	}
+
+	if buttons, err := ParseButtons("none"); err != nil || buttons != 0 {
+		t.Errorf("Expected no buttons but was %v (%v)", buttons, err)
+	}
+
+	if _, err := ParseButtons("Up+Jump"); err == nil {
+		t.Errorf("Expected jump not to be a button")
+	}
+}
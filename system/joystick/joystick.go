@@ -0,0 +1,164 @@
+// Package joystick maps the buttons and axes of a game controller onto the Gameboy's buttons. Joysticks are read
+// through a Source, so the mapping can be used without any controllers plugged in.
+package joystick
+
+import (
+	"github.com/robmerrell/gmboy/system/joypad"
+	"log"
+)
+
+// MaxJoysticks is how many joysticks can be plugged in at once
+const MaxJoysticks = 16
+
+// scanInterval is how many reads go by between looking for a joystick when none is plugged in
+const scanInterval = 60
+
+// Source reads the joysticks that are plugged in, which are numbered from 0. A button is held when its byte isn't 0.
+type Source interface {
+	Present(joy int) bool
+	Name(joy int) string
+	Axes(joy int) []float32
+	Buttons(joy int) []byte
+}
+
+// None is the index of a button or axis that isn't mapped.
+const None = -1
+
+// Mapping maps a joystick's buttons and axes onto the Gameboy's buttons by their index. The directions can come from
+// buttons, an analog stick or a d-pad that's reported as a pair of axes.
+type Mapping struct {
+	A, B, Select, Start   int
+	Up, Down, Left, Right int
+
+	// TurboA and TurboB are the buttons that fire A and B over and over while they're held
+	TurboA, TurboB int
+
+	// XAxis and YAxis are the analog stick, and DPadXAxis and DPadYAxis a d-pad reported as axes. Negative values are
+	// left and up.
+	XAxis, YAxis         int
+	DPadXAxis, DPadYAxis int
+
+	// Deadzone is how far from the center an axis has to move, from 0 to 1, before it counts as a direction
+	Deadzone float32
+}
+
+// DefaultMapping returns a mapping for an Xbox style controller: the right face button is A and the bottom one B,
+// like they are on a Gameboy, with the top and left ones their turbo buttons. Back is select and the left stick and
+// d-pad are the directions.
+func DefaultMapping() Mapping {
+	return Mapping{
+		A: 1, B: 0, Select: 6, Start: 7,
+		Up: None, Down: None, Left: None, Right: None,
+		TurboA: 3, TurboB: 2,
+		XAxis: 0, YAxis: 1, DPadXAxis: 6, DPadYAxis: 7,
+		Deadzone: 0.25,
+	}
+}
+
+// Buttons returns the Gameboy buttons held for the given state of a joystick.
+func (m Mapping) Buttons(axes []float32, buttons []byte) joypad.Buttons {
+	held := m.held(buttons, []buttonMapping{
+		{m.A, joypad.A}, {m.B, joypad.B}, {m.Select, joypad.Select}, {m.Start, joypad.Start},
+		{m.Up, joypad.Up}, {m.Down, joypad.Down}, {m.Left, joypad.Left}, {m.Right, joypad.Right},
+	})
+
+	for _, axis := range []struct {
+		index              int
+		negative, positive joypad.Buttons
+	}{
+		{m.XAxis, joypad.Left, joypad.Right}, {m.YAxis, joypad.Up, joypad.Down},
+		{m.DPadXAxis, joypad.Left, joypad.Right}, {m.DPadYAxis, joypad.Up, joypad.Down},
+	} {
+		if axis.index < 0 || axis.index >= len(axes) {
+			continue
+		}
+
+		if value := axes[axis.index]; value < -m.Deadzone {
+			held |= axis.negative
+		} else if value > m.Deadzone {
+			held |= axis.positive
+		}
+	}
+
+	// opposite directions can't both be pressed on a real d-pad, and some games misbehave if they are
+	if held&(joypad.Left|joypad.Right) == joypad.Left|joypad.Right {
+		held &^= joypad.Left | joypad.Right
+	}
+	if held&(joypad.Up|joypad.Down) == joypad.Up|joypad.Down {
+		held &^= joypad.Up | joypad.Down
+	}
+	return held
+}
+
+// Turbo returns the Gameboy buttons whose turbo buttons are held for the given state of a joystick.
+func (m Mapping) Turbo(buttons []byte) joypad.Buttons {
+	return m.held(buttons, []buttonMapping{{m.TurboA, joypad.A}, {m.TurboB, joypad.B}})
+}
+
+// buttonMapping maps a joystick button onto a Gameboy button
+type buttonMapping struct {
+	index  int
+	button joypad.Buttons
+}
+
+// held returns the Gameboy buttons mapped onto the joystick buttons that are held.
+func (m Mapping) held(buttons []byte, mappings []buttonMapping) joypad.Buttons {
+	var held joypad.Buttons
+	for _, b := range mappings {
+		if b.index >= 0 && b.index < len(buttons) && buttons[b.index] != 0 {
+			held |= b.button
+		}
+	}
+	return held
+}
+
+// Reader reads the Gameboy buttons held on the first joystick plugged in. When it's unplugged the next one plugged
+// in is used.
+type Reader struct {
+	source  Source
+	mapping Mapping
+
+	// current is the joystick being read, or None. reads counts the reads made without one.
+	current int
+	reads   int
+}
+
+// NewReader creates a reader for joysticks from source, mapped with mapping.
+func NewReader(source Source, mapping Mapping) *Reader {
+	return &Reader{source: source, mapping: mapping, current: None}
+}
+
+// Buttons returns the Gameboy buttons held on the joystick and the ones whose turbo buttons are held, or none if
+// there isn't one plugged in.
+func (r *Reader) Buttons() (held, turbo joypad.Buttons) {
+	if r.current != None && !r.source.Present(r.current) {
+		log.Printf("Joystick %d was unplugged\n", r.current+1)
+		r.current = None
+		r.reads = 0
+	}
+
+	if r.current == None {
+		// looking for joysticks is slow on some platforms, so it's only done every so often
+		if r.reads%scanInterval == 0 {
+			r.scan()
+		}
+		r.reads++
+		if r.current == None {
+			return 0, 0
+		}
+	}
+
+	buttons := r.source.Buttons(r.current)
+	return r.mapping.Buttons(r.source.Axes(r.current), buttons), r.mapping.Turbo(buttons)
+}
+
+// scan looks for a joystick that's plugged in.
+func (r *Reader) scan() {
+	for joy := 0; joy < MaxJoysticks; joy++ {
+		if r.source.Present(joy) {
+			r.current = joy
+			log.Printf("Using joystick %d, %s\n", joy+1, r.source.Name(joy))
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package joystick
+
+import (
+	"github.com/robmerrell/gmboy/system/joypad"
+	"testing"
+)
+
+// fakeJoystick is a joystick plugged into a fakeSource
+type fakeJoystick struct {
+	axes    []float32
+	buttons []byte
+}
+
+// fakeSource is a Source with joysticks that can be plugged in and unplugged by the tests
+type fakeSource map[int]*fakeJoystick
+
+func (f fakeSource) Present(joy int) bool {
+	return f[joy] != nil
+}
+
+func (f fakeSource) Name(joy int) string {
+	return "Fake joystick"
+}
+
+func (f fakeSource) Axes(joy int) []float32 {
+	return f[joy].axes
+}
+
+func (f fakeSource) Buttons(joy int) []byte {
+	return f[joy].buttons
+}
+
+func TestMappingButtons(t *testing.T) {
+	m := DefaultMapping()
+	axes := make([]float32, 8)
+	buttons := make([]byte, 10)
+
+	buttons[1], buttons[7] = 1, 1
+	if held := m.Buttons(axes, buttons); held != joypad.A|joypad.Start {
+		t.Errorf("Expected A and start to be held but was %v", held)
+	}
+
+	// buttons and axes that aren't there are ignored
+	m.Up, m.Down = 20, None
+	m.XAxis = 9
+	buttons[1], buttons[7] = 0, 0
+	axes[0] = -1
+	if held := m.Buttons(axes, buttons); held != 0 {
+		t.Errorf("Expected nothing to be held but was %v", held)
+	}
+
+	buttons[2], buttons[3] = 1, 1
+	if turbo := m.Turbo(buttons); turbo != joypad.A|joypad.B {
+		t.Errorf("Expected turbo A and B to be held but was %v", turbo)
+	}
+}
+
+func TestMappingAxes(t *testing.T) {
+	m := DefaultMapping()
+	axes := make([]float32, 8)
+
+	tests := []struct {
+		x, y     float32
+		expected joypad.Buttons
+	}{
+		{0, 0, 0},
+		{0.2, -0.2, 0},
+		{0.3, 0, joypad.Right},
+		{-0.3, 0.9, joypad.Left | joypad.Down},
+		{0, -1, joypad.Up},
+	}
+
+	for _, test := range tests {
+		axes[0], axes[1] = test.x, test.y
+		if held := m.Buttons(axes, nil); held != test.expected {
+			t.Errorf("Expected the stick at %v,%v to hold %v but was %v", test.x, test.y, test.expected, held)
+		}
+	}
+
+	// the d-pad and stick pushed opposite ways cancel out
+	axes[0], axes[1] = -1, 0
+	axes[6] = 1
+	if held := m.Buttons(axes, nil); held != 0 {
+		t.Errorf("Expected left and right to cancel out but was %v", held)
+	}
+}
+
+func TestReaderHotPlug(t *testing.T) {
+	source := fakeSource{}
+	r := NewReader(source, DefaultMapping())
+
+	if held, _ := r.Buttons(); held != 0 {
+		t.Errorf("Expected nothing to be held without a joystick but was %v", held)
+	}
+
+	// a joystick plugged in is found on the next scan
+	source[2] = &fakeJoystick{axes: make([]float32, 8), buttons: []byte{1}}
+	for i := 1; i < scanInterval; i++ {
+		if held, _ := r.Buttons(); held != 0 {
+			t.Fatalf("Expected the joystick not to be found before the next scan but was %v", held)
+		}
+	}
+	if held, _ := r.Buttons(); held != joypad.B {
+		t.Errorf("Expected B to be held once the joystick was found but was %v", held)
+	}
+
+	// unplugging it lets go of the buttons and the next joystick plugged in takes over
+	delete(source, 2)
+	source[0] = &fakeJoystick{buttons: []byte{0, 1}}
+	if held, _ := r.Buttons(); held != joypad.A {
+		t.Errorf("Expected A to be held on the other joystick but was %v", held)
+	}
+
+	delete(source, 0)
+	if held, _ := r.Buttons(); held != 0 {
+		t.Errorf("Expected nothing to be held once unplugged but was %v", held)
+	}
+}
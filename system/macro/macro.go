@@ -0,0 +1,113 @@
+// Package macro plays back sequences of button presses, each held for a number of frames, on top of the buttons
+// held on the keyboard or joystick.
+package macro
+
+import (
+	"fmt"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"strconv"
+	"strings"
+)
+
+// Step holds Buttons down for Frames frames.
+type Step struct {
+	Buttons joypad.Buttons
+	Frames  int
+}
+
+// Macro is a sequence of steps, played one after the other.
+type Macro []Step
+
+// Parse parses a macro written as steps separated by commas. Each step is the buttons held, joined with +, and the
+// number of frames they're held for, like "Down 2, Down+Right 2, Right+A 4". The buttons can be "none" to wait.
+func Parse(text string) (Macro, error) {
+	var m Macro
+	for _, step := range strings.Split(text, ",") {
+		fields := strings.Fields(step)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q should be the buttons held and for how many frames, like \"Right+A 4\"", strings.TrimSpace(step))
+		}
+
+		buttons, err := joypad.ParseButtons(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		frames, err := strconv.Atoi(fields[1])
+		if err != nil || frames < 1 {
+			return nil, fmt.Errorf("%s isn't a number of frames", fields[1])
+		}
+
+		m = append(m, Step{Buttons: buttons, Frames: frames})
+	}
+	return m, nil
+}
+
+// Frames returns how many frames the macro lasts.
+func (m Macro) Frames() int {
+	var frames int
+	for _, step := range m {
+		frames += step.Frames
+	}
+	return frames
+}
+
+// Buttons returns the buttons held on a frame of the macro, counting from 0, or false once it's finished.
+func (m Macro) Buttons(frame int) (joypad.Buttons, bool) {
+	for _, step := range m {
+		if frame < step.Frames {
+			return step.Buttons, true
+		}
+		frame -= step.Frames
+	}
+	return 0, false
+}
+
+// playing is a macro being played, which started on the frame after start
+type playing struct {
+	macro Macro
+	start uint64
+}
+
+// Player plays macros against the frame count. Any number can play at once, and the buttons they hold are combined.
+type Player struct {
+	playing []playing
+}
+
+// Play starts playing a macro on the frame after frame.
+func (p *Player) Play(m Macro, frame uint64) {
+	p.playing = append(p.playing, playing{macro: m, start: frame})
+}
+
+// Playing returns true while any macros are playing.
+func (p *Player) Playing() bool {
+	return len(p.playing) > 0
+}
+
+// Stop stops every macro.
+func (p *Player) Stop() {
+	p.playing = nil
+}
+
+// Buttons returns the buttons the macros hold on a frame. Macros are dropped once they've finished, or if the frame
+// is from before they started, like after going back in time.
+func (p *Player) Buttons(frame uint64) joypad.Buttons {
+	var held joypad.Buttons
+	playing := p.playing[:0]
+	for _, m := range p.playing {
+		if frame < m.start {
+			continue
+		}
+		if frame == m.start {
+			playing = append(playing, m)
+			continue
+		}
+
+		if buttons, ok := m.macro.Buttons(int(frame - m.start - 1)); ok {
+			held |= buttons
+			playing = append(playing, m)
+		}
+	}
+
+	p.playing = playing
+	return held
+}
@@ -0,0 +1,55 @@
+package macro
+
+import (
+	"github.com/robmerrell/gmboy/system/joypad"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse("Down 2, Down+Right 1,none 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Macro{{joypad.Down, 2}, {joypad.Down | joypad.Right, 1}, {0, 3}}
+	if len(m) != len(expected) {
+		t.Fatalf("Expected %v but was %v", expected, m)
+	}
+	for i := range m {
+		if m[i] != expected[i] {
+			t.Errorf("Expected step %d to be %v but was %v", i, expected[i], m[i])
+		}
+	}
+	if m.Frames() != 6 {
+		t.Errorf("Expected the macro to last 6 frames but was %d", m.Frames())
+	}
+
+	for _, text := range []string{"", "A", "A 0", "A two", "Jump 2", "A 2,"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Expected %q to fail to parse", text)
+		}
+	}
+}
+
+func TestPlayer(t *testing.T) {
+	var p Player
+	p.Play(Macro{{joypad.A, 2}, {0, 1}, {joypad.B, 1}}, 10)
+	p.Play(Macro{{joypad.Right, 3}}, 11)
+
+	expected := []joypad.Buttons{joypad.A, joypad.A | joypad.Right, joypad.Right, joypad.B | joypad.Right, 0}
+	for i, buttons := range expected {
+		frame := uint64(11 + i)
+		if held := p.Buttons(frame); held != buttons {
+			t.Errorf("Expected %v to be held on frame %d but was %v", buttons, frame, held)
+		}
+	}
+	if p.Playing() {
+		t.Errorf("Expected both macros to have finished")
+	}
+
+	// going back to before a macro started drops it
+	p.Play(Macro{{joypad.Start, 5}}, 20)
+	if held := p.Buttons(15); held != 0 || p.Playing() {
+		t.Errorf("Expected the macro to be dropped but %v was held", held)
+	}
+}
@@ -0,0 +1,58 @@
+package mmu
+
+const (
+	// writing the high byte of a source address here starts an OAM DMA transfer
+	dmaRegister = 0xFF46
+
+	// DMA copies 160 bytes to the sprite attribute table at FE00, one byte every 4 cycles
+	dmaLength      = 0xA0
+	dmaDestination = 0xFE00
+	dmaCyclesByte  = 4
+)
+
+// dmaTransfer tracks the progress of an OAM DMA transfer.
+type dmaTransfer struct {
+	active bool
+	source uint16
+
+	// copied is the number of bytes copied so far and cycles are the cycles left over that haven't
+	// been enough to copy the next byte.
+	copied int
+	cycles int
+}
+
+// startDMA starts a DMA transfer from the given page of memory. Writing while a transfer is in
+// progress restarts it.
+func (m *MMU) startDMA(value byte) {
+	m.memory[dmaRegister] = value
+	m.dma = dmaTransfer{active: true, source: uint16(value) << 8}
+
+	if m.debugger != nil {
+		m.debugger.RunCallbacks("dma_start", m.dma.source)
+	}
+}
+
+// DMAActive returns true while a DMA transfer is in progress.
+func (m *MMU) DMAActive() bool {
+	return m.dma.active
+}
+
+// Tick advances any DMA transfer in progress by the given number of cycles.
+func (m *MMU) Tick(cycles int) {
+	if !m.dma.active {
+		return
+	}
+
+	m.dma.cycles += cycles
+	for m.dma.cycles >= dmaCyclesByte && m.dma.copied < dmaLength {
+		offset := uint16(m.dma.copied)
+		m.memory[dmaDestination+offset] = m.PeekByte(m.dma.source + offset)
+
+		m.dma.copied++
+		m.dma.cycles -= dmaCyclesByte
+	}
+
+	if m.dma.copied == dmaLength {
+		m.dma.active = false
+	}
+}
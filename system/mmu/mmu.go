@@ -1,10 +1,10 @@
 package mmu
 
 import (
-	"encoding/binary"
 	"errors"
 	"github.com/robertkrimen/otto"
 	"github.com/robmerrell/gmboy/system/debugger"
+	"hash/crc32"
 	"io/ioutil"
 	"log"
 )
@@ -26,24 +26,99 @@ The gameboy memory map (from Pan Docs http://bgb.bircd.org/pandocs.htm)
   FFFF        Interrupt Enable Register
 */
 
-const memorySize = 0xFFFF
+const (
+	memorySize  = 0x10000
+	romBankSize = 0x4000
+
+	// the memory mapped I/O registers
+	ioStart = 0xFF00
+	ioEnd   = 0xFF80
+
+	// writing a non-zero value here unmaps the bootrom
+	bootRomDisable = 0xFF50
+
+	// the interrupt flag register, which holds the requested interrupts, and the interrupt enable register
+	interruptFlag   = 0xFF0F
+	interruptEnable = 0xFFFF
+)
+
+// The bits of each interrupt in the interrupt flag and interrupt enable registers.
+const (
+	InterruptVBlank byte = 1 << iota
+	InterruptLCDStat
+	InterruptTimer
+	InterruptSerial
+	InterruptJoypad
+)
+
+// ioRegister holds the functions a subsystem uses to handle reads and writes of an I/O register it owns.
+type ioRegister struct {
+	read  func() byte
+	write func(value byte)
+}
 
 // MMU is the memory management unit for gmboy. The gameboy hardware doesn't have an MMU
 // but we're creating one here to make accessing memory easier to deal with.
 type MMU struct {
-	memory   []byte
+	memory []byte
+
+	// rom is the cartridge ROM. Once a cartridge is loaded bank 0 is mapped in at 0000-3FFF and
+	// romBank is mapped in at 4000-7FFF. Without a cartridge that area is plain memory.
+	rom     []byte
+	romBank int
+
+	// romChecksum is the checksum of the ROM as it was loaded. patches are the bytes of the ROM changed by
+	// debugging tools, by offset. They're kept apart from the ROM so its checksum doesn't change, and they're saved
+	// with the state.
+	romChecksum uint32
+	patches     map[int]byte
+
+	// bootRom is the bootrom that was loaded, if any, and bootRomActive is set while it's mapped over the first
+	// 256 bytes of the cartridge.
+	bootRom       []byte
+	bootRomActive bool
+
+	// io holds the I/O registers that are owned by other subsystems. Registers without an owner are plain memory.
+	io [ioEnd - ioStart]ioRegister
+
+	// dma is the OAM DMA transfer, if any, that is in progress
+	dma dmaTransfer
+
 	debugger *debugger.Debugger
 }
 
 // NewMMU creates a new MMU to manage loading, accessing and changing values in memory.
 func NewMMU() *MMU {
-	return &MMU{memory: make([]byte, memorySize)}
+	m := &MMU{memory: make([]byte, memorySize), romBank: 1}
+	m.MapIORegister(dmaRegister, nil, m.startDMA)
+	m.MapIORegister(serialControl, nil, m.writeSerialControl)
+
+	return m
+}
+
+// MapIORegister hands reads and writes of an I/O register over to the subsystem that owns it, for example the
+// timer owns DIV, TIMA, TMA and TAC. Either function can be nil, in which case that access goes to memory as usual.
+func (m *MMU) MapIORegister(location uint16, read func() byte, write func(value byte)) {
+	m.io[location-ioStart] = ioRegister{read: read, write: write}
+}
+
+// RequestInterrupt sets the given interrupt's bit in the interrupt flag register.
+func (m *MMU) RequestInterrupt(interrupt byte) {
+	m.memory[interruptFlag] |= interrupt
+}
+
+// AcknowledgeInterrupt clears the given interrupt's bit in the interrupt flag register, which the CPU does as it
+// services the interrupt. It's the hardware clearing the bit rather than a write, so it doesn't fire io_write or
+// trigger watchpoints.
+func (m *MMU) AcknowledgeInterrupt(interrupt byte) {
+	m.memory[interruptFlag] &^= interrupt
 }
 
 // AttachDebugger attaches a javascript debugger to the MMU
 func (m *MMU) AttachDebugger(dbg *debugger.Debugger) {
 	log.Println("Attaching debugger to MMU")
 	m.debugger = dbg
+	m.debugger.AttachMemory(m)
 
 	// create the dumpMemory() function for the js debugger that returns the entire set of working memory
 	m.debugger.AttachFunction("dumpMemory", func(call otto.FunctionCall) otto.Value {
@@ -51,14 +126,6 @@ func (m *MMU) AttachDebugger(dbg *debugger.Debugger) {
 		return val
 	})
 
-	// create the writeByte(location, byte) function for the js debugger that writes into memory
-	m.debugger.AttachFunction("writeByte", func(call otto.FunctionCall) otto.Value {
-		location, _ := call.Argument(0).ToInteger()
-		contents, _ := call.Argument(1).ToInteger()
-
-		m.memory[location] = byte(contents)
-		return otto.Value{}
-	})
 }
 
 // LoadBootRom loads the given bootrom file into memory. When the system boots the bootrom is
@@ -74,24 +141,239 @@ func (m *MMU) LoadBootRom(romFile string) error {
 		return errors.New("The bootrom should not exceed 256 bytes in length.")
 	}
 	m.WriteBytes(romContents, 0)
+	m.bootRomActive = true
+	m.bootRom = romContents
 
 	return nil
 }
 
+// LoadRom loads a cartridge ROM and maps it into memory. The bootrom, if one is loaded, stays mapped over the
+// start of the ROM until it unmaps itself.
+func (m *MMU) LoadRom(romFile string) error {
+	romContents, err := ioutil.ReadFile(romFile)
+	if err != nil {
+		return err
+	}
+
+	if len(romContents) < romBankSize*2 {
+		return errors.New("The rom should be at least 32KB in length.")
+	}
+
+	m.rom = romContents
+	m.romBank = 1
+	m.romChecksum = crc32.ChecksumIEEE(romContents)
+	m.patches = nil
+	return nil
+}
+
+// RomChecksum returns the CRC-32 checksum of the cartridge ROM as it was loaded, before any patches.
+func (m *MMU) RomChecksum() uint32 {
+	return m.romChecksum
+}
+
+// BootRomChecksum returns the CRC-32 checksum of the bootrom, or 0 if one wasn't loaded.
+func (m *MMU) BootRomChecksum() uint32 {
+	if m.bootRom == nil {
+		return 0
+	}
+	return crc32.ChecksumIEEE(m.bootRom)
+}
+
+// Reset puts the MMU back the way it is at power on: the I/O registers are cleared, the bootrom is mapped back in
+// if there is one, the first switchable ROM bank is mapped in and any transfers stop. When clearRAM is set all of
+// the memory above the ROM is cleared like it is when the power is cut, otherwise RAM keeps its contents.
+func (m *MMU) Reset(clearRAM bool) {
+	from, to := ioStart, ioEnd
+	if clearRAM {
+		from, to = romBankSize*2, memorySize
+	}
+	for i := from; i < to; i++ {
+		m.memory[i] = 0
+	}
+	m.memory[interruptEnable] = 0
+
+	if m.bootRom != nil {
+		copy(m.memory, m.bootRom)
+		m.bootRomActive = true
+	}
+	m.romBank = 1
+	m.dma = dmaTransfer{}
+}
+
+// Bank returns the ROM bank mapped in at the given location. Everything outside of the switchable
+// ROM area is considered bank 0.
+func (m *MMU) Bank(location uint16) int {
+	if location >= romBankSize && location < romBankSize*2 {
+		return m.romBank
+	}
+	return 0
+}
+
 // ReadByte reads and returns a byte from memory at the given location.
 func (m *MMU) ReadByte(location uint16) byte {
+	value := m.PeekByte(location)
+
+	if m.debugger != nil {
+		m.debugger.CheckWatchpoint(location, debugger.WatchRead, value)
+	}
+
+	return value
+}
+
+// FetchByte reads a byte of an instruction the CPU is executing. Fetches aren't checked against read
+// watchpoints, so watching code only stops when it's read as data.
+func (m *MMU) FetchByte(location uint16) byte {
+	return m.PeekByte(location)
+}
+
+// PeekByte reads a byte from memory without any side effects like triggering watchpoints. This is for reads
+// that aren't made by the CPU, such as other subsystems checking their registers or debugging tools.
+func (m *MMU) PeekByte(location uint16) byte {
+	if m.rom != nil && location < romBankSize*2 && !(m.bootRomActive && location < 0x100) {
+		return m.readRom(location)
+	}
+
+	if location >= ioStart && location < ioEnd {
+		if read := m.io[location-ioStart].read; read != nil {
+			return read()
+		}
+	}
+
 	return m.memory[location]
 }
 
 // ReadWord reads and returns a word from memory at the given location.
 func (m *MMU) ReadWord(location uint16) uint16 {
-	return binary.LittleEndian.Uint16(m.memory[location : location+2])
+	return uint16(m.ReadByte(location)) | uint16(m.ReadByte(location+1))<<8
 }
 
 // WriteBytes write bytes into memory at the given location.
 func (m *MMU) WriteBytes(content []byte, location uint16) {
 	for _, b := range content {
-		m.memory[location] = b
+		m.writeByte(b, location)
 		location++
 	}
 }
+
+// writeByte writes a single byte into memory. Writes to the cartridge ROM don't change the ROM, but are
+// picked up by the cartridge's memory bank controller.
+func (m *MMU) writeByte(value byte, location uint16) {
+	if m.debugger != nil && (location >= ioStart && location < ioEnd || location == interruptEnable) &&
+		m.debugger.HasCallbacks("io_write") {
+		old := m.PeekByte(location)
+		defer m.debugger.RunCallbacks("io_write", map[string]interface{}{"address": location, "old": old, "new": value})
+	}
+
+	switch {
+	case m.rom != nil && location < romBankSize*2:
+		m.writeBankController(value, location)
+	case location == bootRomDisable && value != 0:
+		m.bootRomActive = false
+		m.memory[location] = value
+	case location >= ioStart && location < ioEnd && m.io[location-ioStart].write != nil:
+		m.io[location-ioStart].write(value)
+	default:
+		m.memory[location] = value
+	}
+
+	if m.debugger != nil {
+		m.debugger.CheckWatchpoint(location, debugger.WatchWrite, value)
+	}
+}
+
+// PokeByte writes a byte to memory for debugging tools. Unlike writes made by the CPU it doesn't trigger
+// watchpoints, and writes to the cartridge ROM patch the ROM instead of going to the memory bank controller
+// so code can be patched.
+func (m *MMU) PokeByte(location uint16, value byte) {
+	switch {
+	case m.rom != nil && location < romBankSize*2 && !(m.bootRomActive && location < 0x100):
+		m.patchRom(romOffset(m.romBank, location), value)
+	case location >= ioStart && location < ioEnd && m.io[location-ioStart].write != nil:
+		m.io[location-ioStart].write(value)
+	default:
+		m.memory[location] = value
+	}
+}
+
+// PeekBankByte reads a byte from a ROM bank whether or not it's mapped in. Outside of the switchable ROM area
+// the bank doesn't matter and this is the same as PeekByte.
+func (m *MMU) PeekBankByte(bank int, location uint16) byte {
+	if m.rom == nil || location < romBankSize || location >= romBankSize*2 {
+		return m.PeekByte(location)
+	}
+
+	return m.romByte(romOffset(bank, location))
+}
+
+// PokeBankByte patches a byte in a ROM bank whether or not it's mapped in. Outside of the switchable ROM area
+// the bank doesn't matter and this is the same as PokeByte.
+func (m *MMU) PokeBankByte(bank int, location uint16, value byte) {
+	if m.rom == nil || location < romBankSize || location >= romBankSize*2 {
+		m.PokeByte(location, value)
+		return
+	}
+
+	m.patchRom(romOffset(bank, location), value)
+}
+
+// readRom reads a byte from the cartridge ROM with the current bank switched in.
+func (m *MMU) readRom(location uint16) byte {
+	return m.romByte(romOffset(m.romBank, location))
+}
+
+// romByte reads the byte at an offset into the cartridge ROM, with any patch applied. Reads past the end of the
+// ROM return 0xFF.
+func (m *MMU) romByte(offset int) byte {
+	if offset >= len(m.rom) {
+		return 0xFF
+	}
+	if value, ok := m.patches[offset]; ok {
+		return value
+	}
+	return m.rom[offset]
+}
+
+// patchRom patches the byte at an offset into the cartridge ROM. Patching a byte back to its original value
+// removes the patch.
+func (m *MMU) patchRom(offset int, value byte) {
+	if offset >= len(m.rom) {
+		return
+	}
+
+	if value == m.rom[offset] {
+		delete(m.patches, offset)
+		return
+	}
+	if m.patches == nil {
+		m.patches = make(map[int]byte)
+	}
+	m.patches[offset] = value
+}
+
+// romOffset returns the offset into the cartridge ROM of a location with the given bank switched in.
+func romOffset(bank int, location uint16) int {
+	if location >= romBankSize {
+		return bank*romBankSize + int(location-romBankSize)
+	}
+	return int(location)
+}
+
+// writeBankController handles writes to the cartridge's memory bank controller. For now this only covers
+// selecting the ROM bank with a write to 2000-3FFF, which is common to MBC1, MBC3 and MBC5 cartridges.
+func (m *MMU) writeBankController(value byte, location uint16) {
+	if location < 0x2000 || location >= 0x4000 {
+		return
+	}
+
+	banks := len(m.rom) / romBankSize
+	bank := int(value) % banks
+	if bank == 0 {
+		bank = 1
+	}
+
+	previous := m.romBank
+	m.romBank = bank
+	if m.debugger != nil && bank != previous {
+		m.debugger.RunCallbacks("bank_switch", map[string]interface{}{"bank": bank, "previous": previous})
+	}
+}
@@ -1,7 +1,11 @@
 package mmu
 
 import (
+	"bytes"
+	"github.com/robmerrell/gmboy/system/debugger"
 	"github.com/robmerrell/gmboy/testhelpers"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -26,3 +30,229 @@ func TestLoadBootRom(t *testing.T) {
 	testhelpers.AssertWord(t, 0xFFFF, m.ReadWord(0))
 	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x100))
 }
+
+func TestLoadRomBankSwitching(t *testing.T) {
+	// each bank starts with its own bank number
+	rom := make([]byte, romBankSize*4)
+	for bank := 0; bank < 4; bank++ {
+		rom[bank*romBankSize] = byte(bank)
+	}
+
+	file, err := ioutil.TempFile("", "gmboy-rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(rom)
+	file.Close()
+
+	m := NewMMU()
+	if err := m.LoadRom(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x0000))
+	testhelpers.AssertByte(t, 0x01, m.ReadByte(0x4000))
+
+	m.WriteBytes([]byte{0x03}, 0x2000)
+	testhelpers.AssertByte(t, 0x03, m.ReadByte(0x4000))
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x0000))
+	if m.Bank(0x4000) != 3 {
+		t.Errorf("Expected bank 3 to be mapped in, but was %d", m.Bank(0x4000))
+	}
+
+	// bank 0 can't be mapped into the switchable area
+	m.WriteBytes([]byte{0x00}, 0x2000)
+	testhelpers.AssertByte(t, 0x01, m.ReadByte(0x4000))
+}
+
+func TestBootRomOverlaysRom(t *testing.T) {
+	file, err := ioutil.TempFile("", "gmboy-rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(make([]byte, romBankSize*2))
+	file.Close()
+
+	m := NewMMU()
+	m.LoadBootRom("./testdata/testboot.bin")
+	m.LoadRom(file.Name())
+
+	testhelpers.AssertByte(t, 0xFF, m.ReadByte(0x0000))
+
+	m.WriteBytes([]byte{0x01}, bootRomDisable)
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x0000))
+}
+
+func TestPokeBytePatchesRom(t *testing.T) {
+	file, err := ioutil.TempFile("", "gmboy-rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(make([]byte, romBankSize*4))
+	file.Close()
+
+	m := NewMMU()
+	m.LoadRom(file.Name())
+	m.WriteBytes([]byte{0x02}, 0x2000)
+
+	m.PokeByte(0x4000, 0x3C)
+	m.PokeByte(0xC000, 0x42)
+	testhelpers.AssertByte(t, 0x3C, m.ReadByte(0x4000))
+	testhelpers.AssertByte(t, 0x42, m.ReadByte(0xC000))
+
+	// the patch only applies to the bank that was mapped in
+	m.WriteBytes([]byte{0x01}, 0x2000)
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x4000))
+}
+
+func TestRomPatchesInState(t *testing.T) {
+	file, err := ioutil.TempFile("", "gmboy-rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(make([]byte, romBankSize*2))
+	file.Close()
+
+	m := NewMMU()
+	m.LoadRom(file.Name())
+	checksum := m.RomChecksum()
+
+	var unpatched bytes.Buffer
+	m.SaveState(&unpatched)
+
+	// patching the rom doesn't change its checksum, so states from before the patch still load
+	m.PokeByte(0x0150, 0x3C)
+	if m.RomChecksum() != checksum {
+		t.Error("Expected patching the rom not to change its checksum")
+	}
+
+	var patched bytes.Buffer
+	m.SaveState(&patched)
+	if err := m.LoadState(&unpatched); err != nil {
+		t.Fatal(err)
+	}
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x0150))
+
+	// the patch comes back with the state it was saved in
+	if err := m.LoadState(&patched); err != nil {
+		t.Fatal(err)
+	}
+	testhelpers.AssertByte(t, 0x3C, m.ReadByte(0x0150))
+
+	// a corrupt patch count is refused rather than allocated
+	m.PokeByte(0x0150, 0x00)
+	var corrupt bytes.Buffer
+	m.SaveState(&corrupt)
+	state := corrupt.Bytes()
+	copy(state[len(state)-4:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	if err := m.LoadState(bytes.NewReader(state)); err == nil {
+		t.Error("Expected a state with more patches than the rom has bytes to be refused")
+	}
+}
+
+func TestBankBytes(t *testing.T) {
+	rom := make([]byte, romBankSize*4)
+	rom[3*romBankSize] = 0x33
+
+	file, err := ioutil.TempFile("", "gmboy-rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(rom)
+	file.Close()
+
+	m := NewMMU()
+	m.LoadRom(file.Name())
+
+	// bank 3 isn't mapped in, but can still be read and patched
+	testhelpers.AssertByte(t, 0x33, m.PeekBankByte(3, 0x4000))
+	m.PokeBankByte(3, 0x4001, 0x3C)
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0x4001))
+
+	m.WriteBytes([]byte{0x03}, 0x2000)
+	testhelpers.AssertByte(t, 0x3C, m.ReadByte(0x4001))
+
+	// outside of the switchable bank the bank is ignored
+	m.PokeBankByte(3, 0xC000, 0x42)
+	testhelpers.AssertByte(t, 0x42, m.PeekBankByte(1, 0xC000))
+}
+
+func TestIORegisterMapping(t *testing.T) {
+	m := NewMMU()
+
+	var written byte
+	m.MapIORegister(0xFF10, func() byte { return 0x42 }, func(value byte) { written = value })
+
+	testhelpers.AssertByte(t, 0x42, m.ReadByte(0xFF10))
+	m.WriteBytes([]byte{0x12}, 0xFF10)
+	testhelpers.AssertByte(t, 0x12, written)
+
+	// registers without an owner are plain memory
+	m.WriteBytes([]byte{0x34}, 0xFF11)
+	testhelpers.AssertByte(t, 0x34, m.ReadByte(0xFF11))
+}
+
+func TestDMA(t *testing.T) {
+	m := NewMMU()
+	for i := 0; i < dmaLength; i++ {
+		m.WriteBytes([]byte{byte(i)}, 0xC000+uint16(i))
+	}
+
+	m.WriteBytes([]byte{0xC0}, dmaRegister)
+	if !m.DMAActive() {
+		t.Fatal("Expected DMA to be active after writing to the DMA register")
+	}
+
+	// after 8 cycles only the first two bytes are copied
+	m.Tick(8)
+	testhelpers.AssertByte(t, 0x01, m.ReadByte(0xFE01))
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(0xFE02))
+
+	m.Tick(dmaLength*dmaCyclesByte - 8)
+	testhelpers.AssertByte(t, 0x9F, m.ReadByte(0xFE9F))
+	if m.DMAActive() {
+		t.Error("Expected DMA to be finished after 640 cycles")
+	}
+}
+
+func TestDebuggerEvents(t *testing.T) {
+	rom := make([]byte, romBankSize*4)
+	file, err := ioutil.TempFile("", "gmboy-rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(rom)
+	file.Close()
+
+	m := NewMMU()
+	m.LoadRom(file.Name())
+
+	dbg := debugger.NewDebugger()
+	m.AttachDebugger(dbg)
+	dbg.Execute(`var events = [];
+		on('io_write', function(e) { events.push('io ' + e.address.toString(16) + ' ' + e.old + ' ' + e.new); });
+		on('bank_switch', function(e) { events.push('bank ' + e.previous + ' ' + e.bank); });
+		on('dma_start', function(source) { events.push('dma ' + source.toString(16)); });
+		on('serial_byte', function(b) { events.push('serial ' + b); });`)
+
+	m.WriteBytes([]byte{0x12}, 0xFF80)
+	m.WriteBytes([]byte{0x07}, 0xFF06)
+	m.WriteBytes([]byte{0x03}, 0x2000)
+	m.WriteBytes([]byte{0x03}, 0x2000)
+	m.WriteBytes([]byte{0xC0}, dmaRegister)
+	m.WriteBytes([]byte{0x41}, serialData)
+	m.WriteBytes([]byte{0x01}, serialControl)
+	m.WriteBytes([]byte{0x81}, serialControl)
+
+	// only setting the start bit of SC sends the byte in SB
+	expected := "io ff06 0 7,bank 1 3,dma c000,io ff46 0 192,io ff01 0 65,io ff02 0 1,serial 65,io ff02 1 129\n"
+	if output := dbg.Execute("events.join()"); output != expected {
+		t.Errorf("Expected the events %q but was %q", expected, output)
+	}
+}
@@ -0,0 +1,18 @@
+package mmu
+
+const (
+	// SB holds the byte to send over the serial port, and setting the start bit of SC sends it
+	serialData    = 0xFF01
+	serialControl = 0xFF02
+	serialStart   = 0x80
+)
+
+// writeSerialControl writes SC. Transfers aren't emulated, but the byte in SB is passed to the serial_byte
+// debugger event whenever one is started.
+func (m *MMU) writeSerialControl(value byte) {
+	m.memory[serialControl] = value
+
+	if value&serialStart != 0 && m.debugger != nil {
+		m.debugger.RunCallbacks("serial_byte", m.memory[serialData])
+	}
+}
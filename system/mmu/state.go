@@ -0,0 +1,97 @@
+package mmu
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// mmuState is the MMU state that goes into a save state. Memory covers work RAM, video RAM, OAM, HRAM, the I/O
+// registers without an owner and the cartridge RAM, which isn't banked yet. The ROM itself isn't saved, only a
+// checksum so a state can't be loaded into a different game. PatchCount patches made by debugging tools follow.
+type mmuState struct {
+	Memory        [memorySize]byte
+	RomChecksum   uint32
+	RomBank       uint16
+	BootRomActive bool
+
+	DMAActive bool
+	DMASource uint16
+	DMACopied uint16
+	DMACycles int32
+
+	PatchCount uint32
+}
+
+// romPatch is a patched byte of the ROM in a save state
+type romPatch struct {
+	Offset uint32
+	Value  byte
+}
+
+// SaveState writes memory, the banking state, any DMA transfer in progress and the ROM patches to w.
+func (m *MMU) SaveState(w io.Writer) error {
+	state := mmuState{
+		RomChecksum:   m.RomChecksum(),
+		RomBank:       uint16(m.romBank),
+		BootRomActive: m.bootRomActive,
+		DMAActive:     m.dma.active,
+		DMASource:     m.dma.source,
+		DMACopied:     uint16(m.dma.copied),
+		DMACycles:     int32(m.dma.cycles),
+		PatchCount:    uint32(len(m.patches)),
+	}
+	copy(state.Memory[:], m.memory)
+
+	// the patches are sorted so the same machine always saves the same state
+	patches := make([]romPatch, 0, len(m.patches))
+	for offset, value := range m.patches {
+		patches = append(patches, romPatch{Offset: uint32(offset), Value: value})
+	}
+	sort.Slice(patches, func(i, j int) bool { return patches[i].Offset < patches[j].Offset })
+
+	if err := binary.Write(w, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, patches)
+}
+
+// LoadState restores memory, the banking state, transfers and the ROM patches from r. It fails without changing
+// anything if the state was saved with a different ROM loaded.
+func (m *MMU) LoadState(r io.Reader) error {
+	var state mmuState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+
+	if state.RomChecksum != m.RomChecksum() {
+		return errors.New("The save state is for a different rom.")
+	}
+
+	// a rom can't have more patches than it has bytes, which keeps a corrupt count from allocating without limit
+	if int64(state.PatchCount) > int64(len(m.rom)) {
+		return errors.New("The save state has more rom patches than the rom has bytes.")
+	}
+	saved := make([]romPatch, state.PatchCount)
+	if err := binary.Read(r, binary.LittleEndian, saved); err != nil {
+		return err
+	}
+	var patches map[int]byte
+	for _, patch := range saved {
+		if int(patch.Offset) >= len(m.rom) {
+			return errors.New("The save state patches the rom past its end.")
+		}
+		if patches == nil {
+			patches = make(map[int]byte)
+		}
+		patches[int(patch.Offset)] = patch.Value
+	}
+
+	copy(m.memory, state.Memory[:])
+	m.romBank = int(state.RomBank)
+	m.bootRomActive = state.BootRomActive
+	m.dma = dmaTransfer{active: state.DMAActive, source: state.DMASource, copied: int(state.DMACopied), cycles: int(state.DMACycles)}
+	m.patches = patches
+	return nil
+}
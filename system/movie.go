@@ -0,0 +1,163 @@
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"github.com/robmerrell/gmboy/system/movie"
+	"hash/crc32"
+	"log"
+)
+
+// RecordMovie records the buttons held on every frame from here on, and writes them to file along with the state
+// the machine is in now when the system stops. Before the first instruction that's power on, otherwise the state
+// is saved into the movie.
+func (s *System) RecordMovie(file string) error {
+	s.movieFile = file
+	s.recording = true
+	return s.startMovie()
+}
+
+// startMovie starts recording a movie from the current state of the machine.
+func (s *System) startMovie() error {
+	m := &movie.Movie{
+		Model:           movie.Model,
+		MCycle:          s.mcycleStepping,
+		RomChecksum:     s.mmu.RomChecksum(),
+		BootRomChecksum: s.mmu.BootRomChecksum(),
+		StartFrame:      s.frame,
+	}
+
+	if s.cycles > 0 {
+		var buf bytes.Buffer
+		if err := s.SaveState(&buf); err != nil {
+			return err
+		}
+		m.State = buf.Bytes()
+	}
+
+	s.movie = m
+	s.inputs = nil
+	s.inputStart = s.frame
+	return nil
+}
+
+// saveMovie writes the movie being recorded to its file.
+func (s *System) saveMovie() {
+	s.movie.Frames = s.inputs
+	if err := s.movie.Save(s.movieFile); err != nil {
+		log.Println("Error saving movie:", err)
+		return
+	}
+	log.Printf("Recorded %d frames to %s\n", len(s.movie.Frames), s.movieFile)
+}
+
+// PlayMovie plays back a movie recorded by RecordMovie. The rom and bootrom have to be the ones it was recorded
+// with, and it has to be played before the system runs. When the movie ends the keyboard takes over, or a headless
+// system stops.
+func (s *System) PlayMovie(file string) error {
+	m, err := movie.Load(file)
+	if err != nil {
+		return err
+	}
+
+	if m.Model != movie.Model {
+		return fmt.Errorf("the movie was recorded on a %s, which isn't emulated", m.Model)
+	}
+	if m.RomChecksum != s.mmu.RomChecksum() {
+		return fmt.Errorf("the movie was recorded with a different rom")
+	}
+
+	if m.State != nil {
+		if err := s.LoadState(bytes.NewReader(m.State)); err != nil {
+			return err
+		}
+	} else {
+		if s.cycles > 0 {
+			return fmt.Errorf("the movie starts at power on, so it can't be played from a save state")
+		}
+		if m.BootRomChecksum != s.mmu.BootRomChecksum() {
+			return fmt.Errorf("the movie was recorded with a different bootrom")
+		}
+	}
+
+	// M-cycle stepping changes when memory accesses happen, so the movie has to be played back stepping the way it
+	// was recorded to do the same thing
+	if m.MCycle && !s.mcycleStepping {
+		log.Println("Switching M-cycle stepping on to play the movie")
+		s.EnableMCycleStepping()
+	} else if !m.MCycle && s.mcycleStepping {
+		log.Println("Switching M-cycle stepping off to play the movie")
+		s.disableMCycleStepping()
+	}
+
+	s.movie = m
+	s.inputs = nil
+	s.inputStart = s.frame
+	return nil
+}
+
+// pollInput sets the buttons held as a frame starts. While the debugger replays frames they're the ones held the
+// first time through, otherwise they come from the movie being played back or the keyboard, joystick, macros and
+// scripts, which get to change them first. Either way they're added to the log.
+func (s *System) pollInput(frame uint64) {
+	logged := frame > s.inputStart && frame-s.inputStart <= uint64(len(s.inputs))
+	if logged && s.debugger != nil && s.debugger.Replaying() {
+		s.joypad.SetButtons(s.inputs[frame-s.inputStart-1])
+		return
+	}
+
+	if s.debugger != nil {
+		s.polling = true
+		s.debugger.RunCallbacks("input_poll", frame)
+		s.polling = false
+	}
+
+	var buttons joypad.Buttons
+	if s.movie != nil && !s.recording {
+		buttons = s.movieInput(frame)
+	} else {
+		buttons = s.liveInput(frame)
+	}
+	s.joypad.SetButtons(buttons)
+
+	if frame > s.inputStart && frame-s.inputStart-1 <= uint64(len(s.inputs)) {
+		s.inputs = append(s.inputs[:frame-s.inputStart-1], buttons)
+	} else {
+		// the machine jumped somewhere the log doesn't lead to, like back to before it started
+		s.restartInputs()
+	}
+}
+
+// movieInput returns the buttons the movie being played back holds on a frame. Once the movie ends it stops
+// playing and the keyboard takes over, or a headless system stops.
+func (s *System) movieInput(frame uint64) joypad.Buttons {
+	if buttons, ok := s.movie.Buttons(frame); ok {
+		return buttons
+	}
+
+	var buf bytes.Buffer
+	s.SaveState(&buf)
+	log.Printf("Movie finished at frame %d with state checksum %08X\n", s.movie.EndFrame(), crc32.ChecksumIEEE(buf.Bytes()))
+
+	s.movie = nil
+	if s.input == nil {
+		s.Stop()
+		return s.joypad.Buttons()
+	}
+	return s.input()
+}
+
+// restartInputs starts the input log again from the current frame, and the movie being recorded if there is one.
+func (s *System) restartInputs() {
+	s.inputs = nil
+	s.inputStart = s.frame
+
+	if s.recording {
+		if err := s.startMovie(); err != nil {
+			log.Println("Error restarting the movie:", err)
+			return
+		}
+		log.Printf("Restarted recording the movie at frame %d\n", s.frame)
+	}
+}
@@ -0,0 +1,185 @@
+// Package movie reads and writes input movies, which record the buttons held on every frame so a run of a game can
+// be played back exactly.
+package movie
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"io"
+	"os"
+)
+
+// The movie file format. A header is followed by the starting save state, if there is one, and then a byte of
+// buttons for every frame.
+const (
+	magic   = "GMBM"
+	version = 1
+)
+
+// Model is the hardware a movie was recorded on. Only the original Game Boy is emulated so far.
+const Model = "DMG"
+
+// header is the start of a movie file.
+type header struct {
+	Magic   [4]byte
+	Version uint16
+	Model   [4]byte
+	MCycle  bool
+
+	// checksums of the rom and the bootrom, which is 0 if there wasn't one
+	RomChecksum     uint32
+	BootRomChecksum uint32
+
+	// StartFrame is the frame the movie starts on, and StateLength the length of the save state it starts from.
+	// Movies that start at power on don't have a state.
+	StartFrame  uint64
+	StateLength uint32
+	FrameCount  uint32
+}
+
+// Movie is a recording of the buttons held on each frame.
+type Movie struct {
+	// Model is the hardware the movie was recorded on, and MCycle is set if the CPU was stepped one M-cycle at a
+	// time, which changes when memory accesses happen
+	Model  string
+	MCycle bool
+
+	// RomChecksum and BootRomChecksum are CRC-32 checksums of the rom and bootrom the movie was recorded with. The
+	// bootrom checksum is 0 if there wasn't one.
+	RomChecksum     uint32
+	BootRomChecksum uint32
+
+	// StartFrame is the frame the movie starts on. State is the save state the movie starts from, or nil if it
+	// starts at power on.
+	StartFrame uint64
+	State      []byte
+
+	// Frames are the buttons held on each frame after the start. Frames[0] is pressed as frame StartFrame+1
+	// begins.
+	Frames []joypad.Buttons
+}
+
+// Buttons returns the buttons held on a frame, and false if the movie doesn't cover it.
+func (m *Movie) Buttons(frame uint64) (joypad.Buttons, bool) {
+	if frame <= m.StartFrame || frame-m.StartFrame > uint64(len(m.Frames)) {
+		return 0, false
+	}
+	return m.Frames[frame-m.StartFrame-1], true
+}
+
+// EndFrame returns the last frame the movie covers.
+func (m *Movie) EndFrame() uint64 {
+	return m.StartFrame + uint64(len(m.Frames))
+}
+
+// Write writes the movie to w.
+func (m *Movie) Write(w io.Writer) error {
+	h := header{
+		Version:         version,
+		MCycle:          m.MCycle,
+		RomChecksum:     m.RomChecksum,
+		BootRomChecksum: m.BootRomChecksum,
+		StartFrame:      m.StartFrame,
+		StateLength:     uint32(len(m.State)),
+		FrameCount:      uint32(len(m.Frames)),
+	}
+	copy(h.Magic[:], magic)
+	copy(h.Model[:], m.Model)
+
+	if err := binary.Write(w, binary.LittleEndian, h); err != nil {
+		return err
+	}
+	if _, err := w.Write(m.State); err != nil {
+		return err
+	}
+
+	frames := make([]byte, len(m.Frames))
+	for i, buttons := range m.Frames {
+		frames[i] = byte(buttons)
+	}
+	_, err := w.Write(frames)
+	return err
+}
+
+// Read reads a movie written by Write.
+func Read(r io.Reader) (*Movie, error) {
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if string(h.Magic[:]) != magic {
+		return nil, errors.New("not a movie")
+	}
+	if h.Version != version {
+		return nil, fmt.Errorf("movie version %d isn't supported, expected version %d", h.Version, version)
+	}
+
+	m := &Movie{
+		Model:           string(bytesBefore(h.Model[:], 0)),
+		MCycle:          h.MCycle,
+		RomChecksum:     h.RomChecksum,
+		BootRomChecksum: h.BootRomChecksum,
+		StartFrame:      h.StartFrame,
+	}
+
+	if h.StateLength > 0 {
+		m.State = make([]byte, h.StateLength)
+		if _, err := io.ReadFull(r, m.State); err != nil {
+			return nil, err
+		}
+	}
+
+	frames := make([]byte, h.FrameCount)
+	if _, err := io.ReadFull(r, frames); err != nil {
+		return nil, err
+	}
+	m.Frames = make([]joypad.Buttons, len(frames))
+	for i, buttons := range frames {
+		m.Frames[i] = joypad.Buttons(buttons)
+	}
+
+	return m, nil
+}
+
+// Load reads a movie from a file.
+func Load(file string) (*Movie, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(bufio.NewReader(f))
+}
+
+// Save writes the movie to a file.
+func (m *Movie) Save(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := m.Write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// bytesBefore returns b up to the first sep, or all of b if there isn't one.
+func bytesBefore(b []byte, sep byte) []byte {
+	for i, c := range b {
+		if c == sep {
+			return b[:i]
+		}
+	}
+	return b
+}
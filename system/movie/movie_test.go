@@ -0,0 +1,41 @@
+package movie
+
+import (
+	"bytes"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	original := &Movie{
+		Model:       Model,
+		RomChecksum: 0x12345678,
+		StartFrame:  10,
+		State:       []byte{1, 2, 3},
+		Frames:      []joypad.Buttons{0, joypad.A, joypad.A | joypad.Right},
+	}
+
+	var buf bytes.Buffer
+	if err := original.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Model != Model || m.RomChecksum != 0x12345678 || m.StartFrame != 10 || !bytes.Equal(m.State, []byte{1, 2, 3}) {
+		t.Errorf("Expected the header to round trip, but was %+v", m)
+	}
+
+	if buttons, ok := m.Buttons(13); !ok || buttons != joypad.A|joypad.Right {
+		t.Errorf("Expected A and right to be held on frame 13, but was %v", buttons)
+	}
+	if _, ok := m.Buttons(10); ok {
+		t.Error("Expected the movie not to cover the frame it starts on")
+	}
+	if _, ok := m.Buttons(14); ok {
+		t.Error("Expected the movie not to cover frames after it ends")
+	}
+}
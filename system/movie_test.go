@@ -0,0 +1,53 @@
+package system
+
+import (
+	"bytes"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMovie(t *testing.T) {
+	// movies can start at power on or from a save state a few frames in
+	for _, start := range []int{0, 2} {
+		file, err := ioutil.TempFile("", "gmboy-movie")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+
+		recorder := newStateSystem()
+		runFrames(t, recorder, start)
+		recorder.input = func() joypad.Buttons { return joypad.Buttons(recorder.frame * 37) }
+		if err := recorder.RecordMovie(file.Name()); err != nil {
+			t.Fatal(err)
+		}
+		expected := runFrames(t, recorder, 5)
+		recorder.saveMovie()
+
+		// the keyboard is ignored while the movie plays
+		player := newStateSystem()
+		player.input = func() joypad.Buttons { return joypad.Start }
+		player.EnableMCycleStepping()
+		if err := player.PlayMovie(file.Name()); err != nil {
+			t.Fatal(err)
+		}
+
+		// the movie is played back stepping the CPU the way it was recorded
+		if player.mcycleStepping {
+			t.Error("Expected M-cycle stepping to be switched off to play back a movie recorded without it")
+		}
+
+		for i, state := range runFrames(t, player, 5) {
+			if !bytes.Equal(state, expected[i]) {
+				t.Errorf("Expected frame %d of the movie started at frame %d to play back the way it was recorded", i+1, start)
+			}
+		}
+		last := joypad.Buttons(uint64(start+5) * 37)
+		if player.joypad.Buttons() != last {
+			t.Errorf("Expected the last frame of the movie to hold %v, but was %v", last, player.joypad.Buttons())
+		}
+	}
+}
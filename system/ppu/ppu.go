@@ -0,0 +1,186 @@
+package ppu
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/mmu"
+	"log"
+)
+
+// The LCD registers
+const (
+	lcdcRegister = 0xFF40
+	statRegister = 0xFF41
+	lyRegister   = 0xFF44
+	lycRegister  = 0xFF45
+)
+
+// lcdEnable is the bit in LCDC that turns the LCD on
+const lcdEnable = 0x80
+
+// STAT register bits
+const (
+	statCoincidence     = 0x04
+	statHBlankInterrupt = 0x08
+	statVBlankInterrupt = 0x10
+	statOAMInterrupt    = 0x20
+	statLYCInterrupt    = 0x40
+)
+
+// The modes the PPU moves through each scanline. The current mode is in the bottom 2 bits of STAT.
+const (
+	ModeHBlank byte = iota
+	ModeVBlank
+	ModeOAMScan
+	ModeDrawing
+)
+
+// modeInterrupts are the STAT bits that enable an interrupt when entering each mode.
+var modeInterrupts = [4]byte{statHBlankInterrupt, statVBlankInterrupt, statOAMInterrupt, 0}
+
+// Scanline timing. Every scanline takes 456 cycles, 144 of them are drawn and another 10 make up VBlank.
+const (
+	CyclesPerLine  = 456
+	CyclesPerFrame = CyclesPerLine * linesPerFrame
+	VBlankLine     = 144
+	linesPerFrame  = 154
+
+	oamScanCycles = 80
+	drawingCycles = 172
+)
+
+// PPU is the picture processing unit. So far only its timing is emulated: it moves through the modes of each
+// scanline, keeps LY and STAT up to date and requests the VBlank and STAT interrupts. Nothing is drawn yet.
+type PPU struct {
+	// cycles is how far into the current scanline we are
+	cycles int
+	ly     byte
+	mode   byte
+
+	// stat holds the writable interrupt enable bits of STAT
+	stat byte
+
+	// frames is the number of frames completed, counted each time VBlank starts
+	frames uint64
+
+	mmu      *mmu.MMU
+	debugger *debugger.Debugger
+}
+
+// NewPPU creates a new PPU and maps its registers into memory.
+func NewPPU(m *mmu.MMU) *PPU {
+	p := &PPU{mmu: m, mode: ModeOAMScan}
+
+	// LY is read only
+	m.MapIORegister(lyRegister, func() byte { return p.ly }, func(byte) {})
+	m.MapIORegister(statRegister, p.readStat, func(value byte) { p.stat = value & 0x78 })
+
+	return p
+}
+
+// Reset puts the PPU back to the start of the first scanline. The frame count keeps going.
+func (p *PPU) Reset() {
+	p.cycles = 0
+	p.ly = 0
+	p.mode = ModeOAMScan
+	p.stat = 0
+}
+
+// AttachDebugger attaches a javascript debugger to the PPU so it can fire the vblank and scanline events.
+func (p *PPU) AttachDebugger(dbg *debugger.Debugger) {
+	log.Println("Attaching debugger to PPU")
+	p.debugger = dbg
+}
+
+// LY returns the scanline currently being drawn.
+func (p *PPU) LY() byte {
+	return p.ly
+}
+
+// Mode returns the current PPU mode.
+func (p *PPU) Mode() byte {
+	return p.mode
+}
+
+// Frames returns the number of frames completed since power on.
+func (p *PPU) Frames() uint64 {
+	return p.frames
+}
+
+// LCDOn returns true while the LCD is switched on.
+func (p *PPU) LCDOn() bool {
+	return p.mmu.PeekByte(lcdcRegister)&lcdEnable != 0
+}
+
+// Tick advances the PPU by the given number of cycles.
+func (p *PPU) Tick(cycles int) {
+	// while the LCD is off the PPU sits at the start of the first line
+	if !p.LCDOn() {
+		p.cycles = 0
+		p.ly = 0
+		p.mode = ModeHBlank
+		return
+	}
+
+	p.cycles += cycles
+	for p.cycles >= CyclesPerLine {
+		p.cycles -= CyclesPerLine
+		p.nextLine()
+	}
+
+	p.updateMode()
+}
+
+// nextLine moves onto the next scanline, starting VBlank or a new frame when needed.
+func (p *PPU) nextLine() {
+	p.ly = byte((int(p.ly) + 1) % linesPerFrame)
+
+	if p.ly == VBlankLine {
+		p.frames++
+		p.mmu.RequestInterrupt(mmu.InterruptVBlank)
+	}
+
+	if p.ly == p.mmu.PeekByte(lycRegister) && p.stat&statLYCInterrupt != 0 {
+		p.mmu.RequestInterrupt(mmu.InterruptLCDStat)
+	}
+
+	if p.debugger != nil {
+		if p.debugger.HasCallbacks("scanline") {
+			p.debugger.RunCallbacks("scanline", p.ly)
+		}
+		if p.ly == VBlankLine {
+			p.debugger.RunCallbacks("vblank", p.frames)
+		}
+	}
+}
+
+// updateMode sets the mode for the current point in the scanline, requesting a STAT interrupt on
+// entering a mode that has its interrupt enabled.
+func (p *PPU) updateMode() {
+	mode := ModeHBlank
+	switch {
+	case p.ly >= VBlankLine:
+		mode = ModeVBlank
+	case p.cycles < oamScanCycles:
+		mode = ModeOAMScan
+	case p.cycles < oamScanCycles+drawingCycles:
+		mode = ModeDrawing
+	}
+
+	if mode == p.mode {
+		return
+	}
+	p.mode = mode
+
+	if p.stat&modeInterrupts[mode] != 0 {
+		p.mmu.RequestInterrupt(mmu.InterruptLCDStat)
+	}
+}
+
+// readStat builds the value of the STAT register.
+func (p *PPU) readStat() byte {
+	stat := 0x80 | p.stat | p.mode
+	if p.ly == p.mmu.PeekByte(lycRegister) {
+		stat |= statCoincidence
+	}
+	return stat
+}
@@ -0,0 +1,78 @@
+package ppu
+
+import (
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/mmu"
+	"github.com/robmerrell/gmboy/testhelpers"
+	"testing"
+)
+
+func mockPPU() (*PPU, *mmu.MMU) {
+	m := mmu.NewMMU()
+	m.WriteBytes([]byte{lcdEnable}, lcdcRegister)
+
+	return NewPPU(m), m
+}
+
+func TestScanlineTiming(t *testing.T) {
+	p, m := mockPPU()
+
+	p.Tick(4)
+	testhelpers.AssertByte(t, ModeOAMScan, p.Mode())
+
+	p.Tick(oamScanCycles)
+	testhelpers.AssertByte(t, ModeDrawing, p.Mode())
+
+	p.Tick(drawingCycles)
+	testhelpers.AssertByte(t, ModeHBlank, p.Mode())
+	testhelpers.AssertByte(t, ModeHBlank, m.ReadByte(statRegister)&0x03)
+
+	p.Tick(CyclesPerLine - oamScanCycles - drawingCycles - 4)
+	testhelpers.AssertByte(t, 1, m.ReadByte(lyRegister))
+}
+
+func TestVBlank(t *testing.T) {
+	p, m := mockPPU()
+
+	for i := 0; i < VBlankLine*CyclesPerLine; i += 4 {
+		p.Tick(4)
+	}
+
+	testhelpers.AssertByte(t, VBlankLine, p.LY())
+	testhelpers.AssertByte(t, ModeVBlank, p.Mode())
+	testhelpers.AssertByte(t, mmu.InterruptVBlank, m.ReadByte(0xFF0F)&mmu.InterruptVBlank)
+	if p.Frames() != 1 {
+		t.Errorf("Expected 1 frame to be completed, but was %d", p.Frames())
+	}
+
+	// and after the rest of the frame we're back at the top
+	for i := 0; i < (linesPerFrame-VBlankLine)*CyclesPerLine; i += 4 {
+		p.Tick(4)
+	}
+	testhelpers.AssertByte(t, 0, p.LY())
+}
+
+func TestLCDOff(t *testing.T) {
+	p, m := mockPPU()
+	p.Tick(CyclesPerLine * 2)
+
+	m.WriteBytes([]byte{0x00}, lcdcRegister)
+	p.Tick(4)
+	testhelpers.AssertByte(t, 0, p.LY())
+}
+
+func TestDebuggerEvents(t *testing.T) {
+	p, _ := mockPPU()
+
+	dbg := debugger.NewDebugger()
+	p.AttachDebugger(dbg)
+	dbg.Execute(`var lines = 0, frames = [];
+		on('scanline', function(ly) { lines++; });
+		on('vblank', function(frame) { frames.push(frame); });`)
+
+	p.Tick(CyclesPerFrame * 2)
+
+	if output := dbg.Execute("lines + ' ' + frames.join()"); output != "308 1,2\n" {
+		t.Errorf("Expected 308 scanlines and 2 frames but was %q", output)
+	}
+}
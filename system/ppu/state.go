@@ -0,0 +1,35 @@
+package ppu
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// ppuState is the PPU state that goes into a save state.
+type ppuState struct {
+	Cycles int32
+	LY     byte
+	Mode   byte
+	Stat   byte
+	Frames uint64
+}
+
+// SaveState writes where the PPU is in the frame to w.
+func (p *PPU) SaveState(w io.Writer) error {
+	return binary.Write(w, binary.LittleEndian, ppuState{Cycles: int32(p.cycles), LY: p.ly, Mode: p.mode, Stat: p.stat, Frames: p.frames})
+}
+
+// LoadState restores where the PPU is in the frame from r.
+func (p *PPU) LoadState(r io.Reader) error {
+	var state ppuState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+
+	p.cycles = int(state.Cycles)
+	p.ly = state.LY
+	p.mode = state.Mode
+	p.stat = state.Stat
+	p.frames = state.Frames
+	return nil
+}
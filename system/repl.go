@@ -0,0 +1,97 @@
+package system
+
+import (
+	"fmt"
+	"github.com/peterh/liner"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// historyFile is where the REPL's command history is kept between runs, relative to the home directory
+const historyFile = ".gmboy_history"
+
+// StartREPL starts an interactive debugger console in the terminal. Commands are run between instructions while
+// the system runs. Pressing ctrl-c at the prompt pauses execution, and quit or ctrl-d stops the system.
+// The debugger has to be started first.
+func (s *System) StartREPL() error {
+	if s.debugger == nil {
+		return fmt.Errorf("the debugger isn't running")
+	}
+
+	words := append(s.debugger.Completions(), "quit")
+
+	s.console = liner.NewLiner()
+	s.console.SetCtrlCAborts(true)
+	s.console.SetCompleter(func(line string) []string {
+		// complete the last word on the line
+		start := strings.LastIndexAny(line, " ([.;,") + 1
+		var completions []string
+		for _, word := range words {
+			if strings.HasPrefix(word, line[start:]) {
+				completions = append(completions, line[:start]+word)
+			}
+		}
+		return completions
+	})
+
+	if f, err := os.Open(historyPath()); err == nil {
+		s.console.ReadHistory(f)
+		f.Close()
+	}
+
+	fmt.Println("Type help for a list of commands.")
+	go s.repl()
+	return nil
+}
+
+// repl reads commands from the terminal until the console is closed.
+func (s *System) repl() {
+	for {
+		line, err := s.console.Prompt("(gmboy) ")
+		if err == liner.ErrPromptAborted {
+			line = "pause"
+		} else if err != nil {
+			s.Stop()
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s.console.AppendHistory(line)
+		s.saveHistory()
+
+		if line == "quit" || line == "exit" {
+			s.Stop()
+			return
+		}
+
+		var output string
+		s.runCommand(func() {
+			output = s.debugger.Execute(line)
+		})
+		fmt.Print(output)
+	}
+}
+
+// saveHistory writes the REPL's command history to the history file.
+func (s *System) saveHistory() {
+	f, err := os.Create(historyPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s.console.WriteHistory(f)
+}
+
+// historyPath returns the path to the history file.
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return historyFile
+	}
+	return filepath.Join(home, historyFile)
+}
@@ -0,0 +1,97 @@
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/robmerrell/gmboy/system/rewind"
+	"log"
+	"time"
+)
+
+// EnableRewind saves the state of the machine every interval frames so it can be run backwards, keeping as many
+// states as fit in budget bytes. Holding the rewind key runs the game backwards and the debugger can step back.
+func (s *System) EnableRewind(interval, budget int) error {
+	if interval < 1 {
+		return fmt.Errorf("the rewind interval must be at least 1 frame")
+	}
+
+	s.rewind = rewind.NewBuffer(budget)
+	s.rewindInterval = uint64(interval)
+	if s.debugger != nil {
+		s.debugger.AttachRewind(s.rewindState)
+		s.debugger.AttachTimeline(s)
+	}
+	return nil
+}
+
+// checkFrame runs as each frame starts. It sets the buttons held for the frame and saves a state for rewinding when
+// enough frames have finished since the last one.
+func (s *System) checkFrame() {
+	frame := frameAt(s.cycles)
+	if frame == s.frame {
+		return
+	}
+	s.frame = frame
+	s.pollInput(frame)
+
+	// the state is saved after the input so the frame is replayed with the same buttons
+	if s.rewind != nil && frame%s.rewindInterval == 0 {
+		var buf bytes.Buffer
+		if err := s.SaveState(&buf); err != nil {
+			log.Println("Error saving rewind state:", err)
+			return
+		}
+		s.rewind.Push(s.cycles, buf.Bytes())
+	}
+}
+
+// rewindState puts the machine back to the newest state saved for rewinding and forgets it, so the next rewind
+// goes further back.
+func (s *System) rewindState() error {
+	_, state, ok := s.rewind.Pop()
+	if !ok {
+		return fmt.Errorf("there's nothing to rewind to")
+	}
+
+	return s.LoadState(bytes.NewReader(state))
+}
+
+// Restore puts the machine back to the newest state saved for rewinding at or before the given cycle, forgetting
+// the states after it. Running forwards from there saves them again.
+func (s *System) Restore(cycles uint64) error {
+	if s.rewind == nil {
+		return fmt.Errorf("rewinding isn't on")
+	}
+
+	if oldest, ok := s.rewind.Oldest(); !ok || oldest > cycles {
+		return fmt.Errorf("there's no saved state from that far back")
+	}
+
+	for {
+		position, state, _ := s.rewind.Pop()
+		if position > cycles {
+			continue
+		}
+
+		if err := s.LoadState(bytes.NewReader(state)); err != nil {
+			return err
+		}
+		s.rewind.Push(position, state)
+		return nil
+	}
+}
+
+// rewindFrame runs the game backwards while the rewind key is held, going back a state at a time at about the
+// speed the game ran forwards.
+func (s *System) rewindFrame() {
+	if err := s.rewindState(); err != nil {
+		// keep the window responsive at the start of the buffer
+		time.Sleep(frameDuration)
+	} else {
+		time.Sleep(frameDuration * time.Duration(s.rewindInterval))
+	}
+
+	s.runCommands()
+	s.pollEvents()
+	s.rewinding = s.rewindHeld()
+}
@@ -0,0 +1,128 @@
+// Package rewind keeps a history of machine states so the emulator can run backwards.
+package rewind
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// Buffer holds machine states, newest last, within a memory budget. Only the newest state is kept whole. Every
+// older state is kept as the difference from the state after it, XORed and compressed, so states that change
+// little from one frame to the next take little room. When the budget runs out the oldest states are dropped.
+//
+// Each state is pushed along with its position, like the number of cycles run when it was saved, so a state from
+// a certain point can be found without decompressing every state.
+type Buffer struct {
+	// newest is the most recent state, uncompressed
+	newest []byte
+
+	// positions of every state, oldest first
+	positions []uint64
+
+	// deltas turn each state into the one before it, oldest first. deltas[len-1] turns newest into the state
+	// before it.
+	deltas [][]byte
+
+	// size is how many bytes are held and budget how many can be
+	size   int
+	budget int
+}
+
+// NewBuffer creates a buffer that holds at most budget bytes of states.
+func NewBuffer(budget int) *Buffer {
+	return &Buffer{budget: budget}
+}
+
+// Push adds a state as the newest one, dropping the oldest states if the buffer is over budget. The buffer keeps
+// state, so it mustn't be changed afterwards.
+func (b *Buffer) Push(position uint64, state []byte) {
+	if b.newest != nil {
+		delta := compress(xor(b.newest, state))
+		b.deltas = append(b.deltas, delta)
+		b.size += len(delta)
+		b.size -= len(b.newest)
+	}
+
+	b.newest = state
+	b.size += len(state)
+	b.positions = append(b.positions, position)
+
+	for b.size > b.budget && len(b.deltas) > 0 {
+		b.size -= len(b.deltas[0])
+		b.deltas[0] = nil
+		b.deltas = b.deltas[1:]
+		b.positions = b.positions[1:]
+	}
+}
+
+// Pop removes the newest state and returns it along with its position, making the state before it the newest.
+func (b *Buffer) Pop() (uint64, []byte, bool) {
+	if b.newest == nil {
+		return 0, nil, false
+	}
+
+	state := b.newest
+	b.size -= len(state)
+	b.newest = nil
+
+	position := b.positions[len(b.positions)-1]
+	b.positions = b.positions[:len(b.positions)-1]
+
+	if n := len(b.deltas); n > 0 {
+		delta := b.deltas[n-1]
+		b.deltas = b.deltas[:n-1]
+		b.size -= len(delta)
+
+		b.newest = xor(state, decompress(delta))
+		b.size += len(b.newest)
+	}
+
+	return position, state, true
+}
+
+// Oldest returns the position of the oldest state.
+func (b *Buffer) Oldest() (uint64, bool) {
+	if len(b.positions) == 0 {
+		return 0, false
+	}
+	return b.positions[0], true
+}
+
+// Len returns the number of states held.
+func (b *Buffer) Len() int {
+	return len(b.positions)
+}
+
+// Size returns the number of bytes the states take up.
+func (b *Buffer) Size() int {
+	return b.size
+}
+
+// xor returns a XOR b. If one is longer than the other the shorter one is treated as if it were padded with 0s,
+// so XORing the result with either gives back the other, up to the length of the result.
+func xor(a, b []byte) []byte {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+
+	result := make([]byte, len(a))
+	copy(result, a)
+	for i := range b {
+		result[i] ^= b[i]
+	}
+	return result
+}
+
+func compress(contents []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(contents)
+	w.Close()
+	return buf.Bytes()
+}
+
+func decompress(contents []byte) []byte {
+	decompressed, _ := ioutil.ReadAll(flate.NewReader(bytes.NewReader(contents)))
+	return decompressed
+}
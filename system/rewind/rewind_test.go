@@ -0,0 +1,62 @@
+package rewind
+
+import (
+	"bytes"
+	"testing"
+)
+
+// frameState returns a 64K state that differs a little from the one for the frame before it.
+func frameState(frame int) []byte {
+	state := make([]byte, 0x10000)
+	for i := 0; i <= frame; i++ {
+		state[i*7] = byte(i + 1)
+	}
+	return state
+}
+
+func TestPushPop(t *testing.T) {
+	b := NewBuffer(1 << 20)
+	for frame := 0; frame < 10; frame++ {
+		b.Push(uint64(frame), frameState(frame))
+	}
+
+	if b.Len() != 10 {
+		t.Errorf("Expected 10 states but was %d", b.Len())
+	}
+	if b.Size() > 0x10000+9*1024 {
+		t.Errorf("Expected older states to be compressed, but the buffer was %d bytes", b.Size())
+	}
+
+	for frame := 9; frame >= 0; frame-- {
+		position, state, ok := b.Pop()
+		if !ok || position != uint64(frame) || !bytes.Equal(state, frameState(frame)) {
+			t.Fatalf("Expected to pop the state for frame %d", frame)
+		}
+	}
+
+	if _, _, ok := b.Pop(); ok || b.Size() != 0 {
+		t.Errorf("Expected the buffer to be empty, but it was %d bytes", b.Size())
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBuffer(0x10000 + 200)
+	for frame := 0; frame < 100; frame++ {
+		b.Push(uint64(frame), frameState(frame))
+	}
+
+	if b.Size() > 0x10000+200 {
+		t.Errorf("Expected the buffer to stay within its budget, but was %d bytes", b.Size())
+	}
+
+	// the newest states are the ones kept
+	n := b.Len()
+	if oldest, _ := b.Oldest(); oldest != uint64(100-n) {
+		t.Errorf("Expected the oldest state to be from frame %d but was %d", 100-n, oldest)
+	}
+	for frame := 99; frame > 99-n; frame-- {
+		if _, state, _ := b.Pop(); !bytes.Equal(state, frameState(frame)) {
+			t.Fatalf("Expected to pop the state for frame %d", frame)
+		}
+	}
+}
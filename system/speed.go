@@ -0,0 +1,108 @@
+package system
+
+import (
+	"fmt"
+	"github.com/robmerrell/gmboy/system/ppu"
+	"math"
+	"time"
+)
+
+// clockSpeed is the number of cycles the hardware runs a second
+const clockSpeed = 4194304
+
+// frameDuration is how long a frame takes on hardware, which works out to 59.7275 frames a second
+const frameDuration = time.Second * ppu.CyclesPerFrame / clockSpeed
+
+// The speeds the system can run at, as multiples of the hardware's speed. Unlimited runs as fast as the host can.
+const (
+	minSpeed  = 0.25
+	Unlimited = 0
+)
+
+// frameAt returns the frame a cycle count falls in. Frames are counted from the cycles run rather than from the
+// PPU's VBlanks so they keep going while the LCD is off.
+func frameAt(cycles uint64) uint64 {
+	return cycles / ppu.CyclesPerFrame
+}
+
+// maxLag is how far behind the hardware's speed the system can fall before it gives up catching up. Without it the
+// system would run flat out after sitting at a breakpoint until it made up the time.
+const maxLag = 100 * time.Millisecond
+
+// SetSpeed sets how fast the system runs as a multiple of the hardware's speed, from 0.25 for slow motion up, or
+// Unlimited to run as fast as possible.
+func (s *System) SetSpeed(speed float64) error {
+	// written so NaN fails the comparison too
+	if speed != Unlimited && (!(speed >= minSpeed) || math.IsInf(speed, 0)) {
+		return fmt.Errorf("the speed has to be a finite number from %gx up", minSpeed)
+	}
+
+	s.speed = speed
+	s.paceStart = time.Time{}
+	return nil
+}
+
+// endFrame runs at the end of every frame when running normally. It checks the keys that are held down, draws the
+// frame and waits until it's time for the next one.
+func (s *System) endFrame() {
+	// the rewind and fast forward keys are only checked once a frame
+	if s.rewind != nil {
+		s.rewinding = s.rewindHeld()
+	}
+	if fastForward := s.fastForwardHeld(); fastForward != s.fastForward {
+		s.fastForward = fastForward
+		s.paceStart = time.Time{}
+	}
+
+	s.render()
+	s.pace()
+}
+
+// runningFast returns true when the system is running faster than the hardware
+func (s *System) runningFast() bool {
+	return s.fastForward || s.speed == Unlimited || s.speed > 1
+}
+
+// render draws the frame to the window. When running faster than the hardware frames are skipped, so no more are
+// drawn than the hardware would show.
+func (s *System) render() {
+	if s.display == nil {
+		return
+	}
+
+	now := time.Now()
+	if s.runningFast() && now.Sub(s.rendered) < frameDuration {
+		return
+	}
+	s.rendered = now
+
+	// the PPU doesn't draw pixels yet, so there's nothing on the screen to draw
+	s.display.Draw(nil)
+}
+
+// pace waits until the cycles run since pacing started would have taken as long on the hardware, at the current
+// speed. Pacing starts again whenever it has to, like after the machine goes back in time or falls too far behind.
+func (s *System) pace() {
+	if s.fastForward || s.speed == Unlimited {
+		return
+	}
+
+	now := time.Now()
+	if s.paceStart.IsZero() || s.cycles < s.paceCycles {
+		s.paceStart, s.paceCycles = now, s.cycles
+		return
+	}
+
+	elapsed := float64(s.cycles-s.paceCycles) / clockSpeed / s.speed
+	wait := s.paceStart.Add(time.Duration(elapsed * float64(time.Second))).Sub(now)
+	if wait > 0 {
+		time.Sleep(wait)
+	} else if wait < -maxLag {
+		s.paceStart, s.paceCycles = now, s.cycles
+	}
+}
+
+// fastForwardHeld returns true while the fast forward key is held down
+func (s *System) fastForwardHeld() bool {
+	return s.inputState != nil && s.inputState.FastForwardHeld()
+}
@@ -0,0 +1,47 @@
+package system
+
+import (
+	"github.com/robmerrell/gmboy/system/ppu"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPace(t *testing.T) {
+	s := newStateSystem()
+	for _, speed := range []float64{0.1, -1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := s.SetSpeed(speed); err == nil {
+			t.Errorf("Expected a speed of %g to be refused", speed)
+		}
+	}
+
+	// 8 frames at 4x should take as long as 2 do on hardware
+	s.SetSpeed(4)
+	start := time.Now()
+	for i := 0; i <= 8; i++ {
+		s.pace()
+		s.cycles += ppu.CyclesPerFrame
+	}
+
+	if elapsed := time.Since(start); elapsed < 2*frameDuration {
+		t.Errorf("Expected 8 frames at 4x to take at least %v, but took %v", 2*frameDuration, elapsed)
+	}
+}
+
+func TestPaceWithLCDOff(t *testing.T) {
+	s := newLCDOffSystem()
+
+	// 8 frames at 4x should take as long as 2 do on hardware, even though the PPU never reaches VBlank
+	s.SetSpeed(4)
+	start := time.Now()
+	for s.cycles < 9*ppu.CyclesPerFrame {
+		s.step()
+	}
+
+	if s.ppu.Frames() != 0 {
+		t.Fatalf("Expected the LCD to stay off, but %d frames were drawn", s.ppu.Frames())
+	}
+	if elapsed := time.Since(start); elapsed < 2*frameDuration {
+		t.Errorf("Expected 8 frames at 4x to take at least %v, but took %v", 2*frameDuration, elapsed)
+	}
+}
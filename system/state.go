@@ -0,0 +1,151 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateMagic starts every save state file, followed by stateVersion. The version goes up whenever the format
+// changes, and states saved with another version are refused rather than loaded wrong.
+const (
+	stateMagic   = "GMBS"
+	stateVersion = 1
+)
+
+// stateHeader comes before the state of each subsystem in a save state.
+type stateHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Cycles  uint64
+}
+
+// stateful is implemented by every subsystem that goes into a save state.
+type stateful interface {
+	SaveState(w io.Writer) error
+	LoadState(r io.Reader) error
+}
+
+// stateParts are the subsystems in a save state, in the order they're written. There's no APU yet.
+func (s *System) stateParts() []stateful {
+	return []stateful{s.cpu, s.mmu, s.ppu, s.timer, s.joypad}
+}
+
+// SaveState writes the state of the whole machine to w in a binary format that LoadState can restore.
+func (s *System) SaveState(w io.Writer) error {
+	header := stateHeader{Version: stateVersion, Cycles: s.cycles}
+	copy(header.Magic[:], stateMagic)
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	for _, part := range s.stateParts() {
+		if err := part.SaveState(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadState restores the state of the whole machine from r. If the state can't be loaded the machine is left the
+// way it was.
+func (s *System) LoadState(r io.Reader) error {
+	var header stateHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if string(header.Magic[:]) != stateMagic {
+		return fmt.Errorf("not a save state")
+	}
+	if header.Version != stateVersion {
+		return fmt.Errorf("save state version %d isn't supported, expected version %d", header.Version, stateVersion)
+	}
+
+	// keep the current state around in case loading fails part of the way through
+	var backup bytes.Buffer
+	if err := s.SaveState(&backup); err != nil {
+		return err
+	}
+
+	for _, part := range s.stateParts() {
+		if err := part.LoadState(r); err != nil {
+			s.restoreState(&backup)
+			return err
+		}
+	}
+
+	s.cycles = header.Cycles
+	s.frame = frameAt(s.cycles)
+	return nil
+}
+
+// restoreState puts back a state saved by SaveState before a load failed.
+func (s *System) restoreState(backup *bytes.Buffer) {
+	var header stateHeader
+	binary.Read(backup, binary.LittleEndian, &header)
+	for _, part := range s.stateParts() {
+		part.LoadState(backup)
+	}
+}
+
+// SaveStateFile saves the state of the machine to a file.
+func (s *System) SaveStateFile(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := s.SaveState(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadStateFile restores the state of the machine from a file saved by SaveStateFile.
+func (s *System) LoadStateFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.LoadState(bufio.NewReader(f))
+}
+
+// slotFile returns the file a numbered save state slot is kept in, next to the rom, e.g. game.ss1.
+func (s *System) slotFile(slot int) string {
+	return fmt.Sprintf("%s.ss%d", strings.TrimSuffix(s.romFile, filepath.Ext(s.romFile)), slot)
+}
+
+// saveSlot saves the state of the machine to a numbered slot.
+func (s *System) saveSlot(slot int) {
+	if err := s.SaveStateFile(s.slotFile(slot)); err != nil {
+		log.Println("Error saving state:", err)
+		return
+	}
+	log.Printf("Saved state to slot %d\n", slot)
+}
+
+// loadSlot restores the state of the machine from a numbered slot.
+func (s *System) loadSlot(slot int) {
+	if err := s.LoadStateFile(s.slotFile(slot)); err != nil {
+		log.Println("Error loading state:", err)
+		return
+	}
+
+	// the input log and any movie being recorded can't lead to the loaded state, so they start again from it
+	s.restartInputs()
+	log.Printf("Loaded state from slot %d\n", slot)
+}
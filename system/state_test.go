@@ -0,0 +1,179 @@
+package system
+
+import (
+	"bytes"
+	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"testing"
+)
+
+// stateProgram turns on the LCD and the timer, then copies memory 256 bytes at a time into C000-C0FF forever.
+var stateProgram = []byte{
+	0x3E, 0x91, // LD A,$91
+	0xE0, 0x40, // LDH ($40),A
+	0x3E, 0x05, // LD A,$05
+	0xE0, 0x07, // LDH ($07),A
+	0x21, 0x00, 0xC0, // LD HL,$C000
+	0x1A,       // LD A,(DE)
+	0x22,       // LD (HL+),A
+	0x13,       // INC DE
+	0x0C,       // INC C
+	0x20, 0xFA, // JR NZ,-6
+	0x21, 0x00, 0xC0, // LD HL,$C000
+	0x18, 0xF5, // JR -11
+}
+
+// newStateSystem creates a system without a display that runs stateProgram.
+func newStateSystem() *System {
+	s := newSystem()
+	s.mmu.WriteBytes(stateProgram, 0x0000)
+	return s
+}
+
+// newLCDOffSystem creates a system that loops forever without turning the LCD on, so the PPU never reaches VBlank.
+func newLCDOffSystem() *System {
+	s := newSystem()
+	s.mmu.WriteBytes([]byte{0x18, 0xFE}, 0x0000) // JR -2
+	return s
+}
+
+// runFrames runs the system for the given number of frames, returning the state at the end of each one.
+func runFrames(t *testing.T, s *System, frames int) [][]byte {
+	var states [][]byte
+	for len(states) < frames {
+		frame := s.frame
+		for s.frame == frame {
+			s.stepCPU()
+		}
+
+		var buf bytes.Buffer
+		if err := s.SaveState(&buf); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, buf.Bytes())
+	}
+	return states
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	original := newStateSystem()
+	runFrames(t, original, 1)
+
+	var saved bytes.Buffer
+	if err := original.SaveState(&saved); err != nil {
+		t.Fatal(err)
+	}
+	expected := runFrames(t, original, 3)
+
+	restored := newStateSystem()
+	if err := restored.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if restored.ppu.Frames() != 1 {
+		t.Errorf("Expected to restore the first frame, but was at frame %d", restored.ppu.Frames())
+	}
+
+	for i, state := range runFrames(t, restored, 3) {
+		if !bytes.Equal(state, expected[i]) {
+			t.Errorf("Expected frame %d of the restored run to match the original", i+2)
+		}
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	s := newStateSystem()
+	runFrames(t, s, 1)
+
+	var saved bytes.Buffer
+	s.SaveState(&saved)
+	contents := saved.Bytes()
+
+	// a truncated state leaves the system as it was
+	if err := s.LoadState(bytes.NewReader(contents[:len(contents)/2])); err == nil {
+		t.Error("Expected a truncated state to fail to load")
+	}
+	var after bytes.Buffer
+	s.SaveState(&after)
+	if !bytes.Equal(after.Bytes(), contents) {
+		t.Error("Expected a failed load to leave the system unchanged")
+	}
+
+	versioned := append([]byte(nil), contents...)
+	versioned[4] = stateVersion + 1
+	if err := s.LoadState(bytes.NewReader(versioned)); err == nil {
+		t.Error("Expected a state from another version to fail to load")
+	}
+}
+
+func TestRewind(t *testing.T) {
+	s := newStateSystem()
+	s.EnableRewind(1, 1<<20)
+	states := runFrames(t, s, 5)
+
+	// the newest state was saved as the last frame finished, and the one before it a frame earlier
+	for _, frame := range []int{4, 3} {
+		if err := s.rewindState(); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		s.SaveState(&buf)
+		if !bytes.Equal(buf.Bytes(), states[frame]) {
+			t.Errorf("Expected to rewind to the end of frame %d", frame+1)
+		}
+	}
+
+	// running forwards again replays the same frames
+	replayed := runFrames(t, s, 1)
+	if !bytes.Equal(replayed[0], states[4]) {
+		t.Error("Expected the rewound game to run the same way again")
+	}
+}
+
+func TestFramesWithLCDOff(t *testing.T) {
+	s := newLCDOffSystem()
+	s.EnableRewind(1, 1<<20)
+	s.input = func() joypad.Buttons { return joypad.Buttons(s.frame) }
+
+	runFrames(t, s, 3)
+	if s.ppu.Frames() != 0 {
+		t.Fatalf("Expected the LCD to stay off, but %d frames were drawn", s.ppu.Frames())
+	}
+
+	// the buttons are still read and states saved every frame
+	if len(s.inputs) != 3 || s.joypad.Buttons() != 3 {
+		t.Errorf("Expected the buttons to be read on 3 frames, but were read on %d holding %v", len(s.inputs), s.joypad.Buttons())
+	}
+	if s.rewind.Len() != 3 {
+		t.Errorf("Expected 3 rewind states, but there were %d", s.rewind.Len())
+	}
+}
+
+func TestReverseStep(t *testing.T) {
+	s := newStateSystem()
+	s.EnableRewind(1, 1<<20)
+
+	dbg := debugger.NewDebugger()
+	s.cpu.AttachDebugger(dbg)
+	s.mmu.AttachDebugger(dbg)
+	dbg.AttachStepper(s.stepCPU)
+	dbg.AttachTimeline(s)
+
+	runFrames(t, s, 2)
+	var before bytes.Buffer
+	for i := 0; i < 100; i++ {
+		before.Reset()
+		s.SaveState(&before)
+		s.stepCPU()
+	}
+
+	if err := dbg.ReverseStep(); err != nil {
+		t.Fatal(err)
+	}
+
+	var after bytes.Buffer
+	s.SaveState(&after)
+	if !bytes.Equal(after.Bytes(), before.Bytes()) {
+		t.Error("Expected reverse stepping to go back to the state before the last instruction")
+	}
+}
@@ -0,0 +1,206 @@
+// Package symbols loads the symbol files written by RGBDS and no$gmb, which name the addresses in a ROM:
+//
+//	; comments start with a semicolon
+//	00:0150 Main
+//	00:0158 Main.loop
+//	02:4000 LoadLevel
+//	00:c000 wPlayerX
+//
+// Each line is a bank and address in hex followed by the label.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a named address.
+type Symbol struct {
+	Name    string
+	Bank    int
+	Address uint16
+}
+
+// Table holds the symbols from a symbol file. Banks only matter for addresses in the switchable ROM bank area,
+// everything else is looked up by address alone.
+type Table struct {
+	byName map[string]Symbol
+
+	// symbols by bank, sorted by address
+	byBank map[int][]Symbol
+}
+
+// Load loads a symbol file.
+func Load(filename string) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// LoadForRom loads the symbol file next to a ROM, which has the same name with a .sym extension. It returns nil
+// without an error if there isn't one.
+func LoadForRom(romFile string) (*Table, error) {
+	t, err := Load(strings.TrimSuffix(romFile, filepath.Ext(romFile)) + ".sym")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// Parse parses symbols in the BB:AAAA Label format.
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{byName: make(map[string]Symbol), byBank: make(map[int][]Symbol)}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+
+		// no$gmb files can have [section] headers, which only the labels section matters in
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		sym, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		t.add(sym)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, syms := range t.byBank {
+		sort.SliceStable(syms, func(i, j int) bool { return syms[i].Address < syms[j].Address })
+	}
+
+	return t, nil
+}
+
+// parseLine parses a single BB:AAAA Label line.
+func parseLine(line string) (Symbol, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return Symbol{}, fmt.Errorf("expected BB:AAAA Label, but got %q", line)
+	}
+
+	parts := strings.SplitN(fields[0], ":", 2)
+	if len(parts) != 2 {
+		return Symbol{}, fmt.Errorf("expected BB:AAAA Label, but got %q", line)
+	}
+
+	bank, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return Symbol{}, fmt.Errorf("%s is not a valid bank", parts[0])
+	}
+
+	address, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return Symbol{}, fmt.Errorf("%s is not a valid address", parts[1])
+	}
+
+	return Symbol{Name: fields[1], Bank: int(bank), Address: uint16(address)}, nil
+}
+
+// add adds a symbol to the table. If a name is defined twice the first one wins.
+func (t *Table) add(sym Symbol) {
+	if _, exists := t.byName[sym.Name]; !exists {
+		t.byName[sym.Name] = sym
+	}
+
+	bank := bankKey(sym.Bank, sym.Address)
+	t.byBank[bank] = append(t.byBank[bank], sym)
+}
+
+// Len returns the number of symbols in the table.
+func (t *Table) Len() int {
+	return len(t.byName)
+}
+
+// Lookup returns the symbol with the given name.
+func (t *Table) Lookup(name string) (Symbol, bool) {
+	sym, ok := t.byName[name]
+	return sym, ok
+}
+
+// Name returns the label at an address, if there is one. When an address has more than one label the first one
+// in the file is returned.
+func (t *Table) Name(bank int, address uint16) (string, bool) {
+	syms := t.byBank[bankKey(bank, address)]
+	i := sort.Search(len(syms), func(i int) bool { return syms[i].Address >= address })
+	if i < len(syms) && syms[i].Address == address {
+		return syms[i].Name, true
+	}
+	return "", false
+}
+
+// Nearest returns the closest label at or before an address in the same area of memory. When the closest address
+// has more than one label the first one in the file is returned.
+func (t *Table) Nearest(bank int, address uint16) (Symbol, bool) {
+	syms := t.byBank[bankKey(bank, address)]
+	i := sort.Search(len(syms), func(i int) bool { return syms[i].Address > address })
+	if i == 0 {
+		return Symbol{}, false
+	}
+
+	// back up to the first label at the closest address
+	sym := syms[i-1]
+	for i > 1 && syms[i-2].Address == sym.Address {
+		i--
+		sym = syms[i-1]
+	}
+
+	if region(sym.Address) != region(address) {
+		return Symbol{}, false
+	}
+	return sym, true
+}
+
+// Describe names an address relative to the closest label before it in the same area of memory, like
+// "Main.loop" or "Main.loop+$3". It returns an empty string if there isn't one.
+func (t *Table) Describe(bank int, address uint16) string {
+	sym, ok := t.Nearest(bank, address)
+	if !ok {
+		return ""
+	}
+
+	if sym.Address == address {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+$%X", sym.Name, address-sym.Address)
+}
+
+// bankKey returns the bank symbols at an address are stored under. Only the switchable ROM bank area is
+// banked as far as lookups go.
+func bankKey(bank int, address uint16) int {
+	if address >= 0x4000 && address < 0x8000 {
+		return bank
+	}
+	return 0
+}
+
+// region returns which area of the memory map an address is in, so a label in one area doesn't get used to
+// describe an address in another.
+func region(address uint16) int {
+	boundaries := []uint16{0x4000, 0x8000, 0xA000, 0xC000, 0xE000, 0xFE00, 0xFF00, 0xFF80}
+	for i, boundary := range boundaries {
+		if address < boundary {
+			return i
+		}
+	}
+	return len(boundaries)
+}
@@ -0,0 +1,98 @@
+package symbols
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	table, err := Load("testdata/game.sym")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.Len() != 6 {
+		t.Errorf("Expected 6 symbols but was %d", table.Len())
+	}
+
+	sym, ok := table.Lookup("LoadLevel")
+	if !ok || sym.Bank != 2 || sym.Address != 0x4000 {
+		t.Errorf("Expected LoadLevel to be at 02:4000 but was %+v", sym)
+	}
+}
+
+func TestLoadForRom(t *testing.T) {
+	table, err := LoadForRom("testdata/game.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table == nil || table.Len() != 6 {
+		t.Errorf("Expected the symbols in game.sym to be loaded")
+	}
+
+	table, err = LoadForRom("testdata/missing.gb")
+	if table != nil || err != nil {
+		t.Errorf("Expected no symbols and no error for a rom without a symbol file but was %v, %v", table, err)
+	}
+}
+
+func TestName(t *testing.T) {
+	table, _ := Load("testdata/game.sym")
+
+	tests := []struct {
+		bank     int
+		address  uint16
+		expected string
+	}{
+		{0, 0x0150, "Main"},
+		{0, 0x0158, "Main.loop"},
+		{2, 0x4000, "LoadLevel"},
+		{3, 0x4000, "PlaySound"},
+		{1, 0x4000, ""},
+		{0, 0x0151, ""},
+		{1, 0xC000, "wPlayerX"},
+	}
+
+	for _, test := range tests {
+		if name, _ := table.Name(test.bank, test.address); name != test.expected {
+			t.Errorf("Expected %02X:%04X to be named %q but was %q", test.bank, test.address, test.expected, name)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	table, _ := Load("testdata/game.sym")
+
+	tests := []struct {
+		bank     int
+		address  uint16
+		expected string
+	}{
+		{0, 0x0150, "Main"},
+		{0, 0x0153, "Main+$3"},
+		{0, 0x015A, "Main.loop+$2"},
+		{2, 0x4010, "LoadLevel+$10"},
+		{0, 0x0100, ""},
+		{0, 0x8000, ""},
+		{0, 0xC001, "wPlayerX+$1"},
+	}
+
+	for _, test := range tests {
+		if description := table.Describe(test.bank, test.address); description != test.expected {
+			t.Errorf("Expected %02X:%04X to be described as %q but was %q", test.bank, test.address, test.expected, description)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, contents := range []string{"0150 Main", "00:XYZ Main", "00:0150", "G0:0150 Main"} {
+		if _, err := Parse(strings.NewReader(contents)); err == nil {
+			t.Errorf("Expected %q not to parse", contents)
+		}
+	}
+
+	table, err := Parse(strings.NewReader("[labels]\n00:0150 Main ; the entry point\n"))
+	if err != nil || table.Len() != 1 {
+		t.Errorf("Expected no$gmb section headers and comments to be skipped, but got %v", err)
+	}
+}
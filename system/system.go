@@ -1,12 +1,27 @@
 package system
 
 import (
+	"fmt"
+	"github.com/peterh/liner"
 	"github.com/robertkrimen/otto"
 	"github.com/robmerrell/gmboy/system/cpu"
+	"github.com/robmerrell/gmboy/system/dap"
 	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/disasm"
+	"github.com/robmerrell/gmboy/system/gdb"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"github.com/robmerrell/gmboy/system/macro"
 	"github.com/robmerrell/gmboy/system/mmu"
+	"github.com/robmerrell/gmboy/system/movie"
+	"github.com/robmerrell/gmboy/system/ppu"
+	"github.com/robmerrell/gmboy/system/rewind"
+	"github.com/robmerrell/gmboy/system/symbols"
+	"github.com/robmerrell/gmboy/system/timer"
 	"github.com/robmerrell/gmboy/system/ui"
 	"log"
+	"os"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -14,28 +29,144 @@ const (
 	displayHeight = 144
 )
 
+// clocked is implemented by every subsystem that is driven by the system clock. Tick is called after every
+// instruction with the number of cycles it took so the subsystems run in lockstep with the CPU.
+type clocked interface {
+	Tick(cycles int)
+}
+
 // System represents the Gameboy system as a whole
 type System struct {
 	cpu        *cpu.CPU
 	mmu        *mmu.MMU
+	timer      *timer.Timer
+	ppu        *ppu.PPU
+	joypad     *joypad.Joypad
 	display    *ui.Display
 	inputState *ui.InputState
 	debugger   *debugger.Debugger
-}
 
-// NewSystem creates a new Gameboy system
-func NewSystem() (*System, error) {
-	m := mmu.NewMMU()
-	c := cpu.NewCPU(m)
+	// input reads the buttons held down on the keyboard and joystick, and turbo the turbo buttons held as of the
+	// last read. They're nil when there's no window.
+	input func() joypad.Buttons
+	turbo func() joypad.Buttons
+
+	// turboFrames is how many frames the turbo buttons are pressed and then let go for
+	turboFrames uint64
+
+	// macros are the macros that can be played by name, and macroPlayer plays them
+	macros      map[string]macro.Macro
+	macroPlayer macro.Player
+
+	// scripted is set while a debugger script holds scriptButtons down instead of the keyboard and joystick, and
+	// scriptPresses plays the presses the script makes, which hold their buttons instead of them as well
+	scripted      bool
+	scriptButtons joypad.Buttons
+	scriptPresses macro.Player
+
+	// polling is set while the input_poll callbacks run, before the buttons for the frame starting have been read
+	polling bool
+
+	// inputs are the buttons held on each frame after inputStart. They're kept so the debugger can replay frames
+	// exactly and so they can be recorded to a movie.
+	inputs     []joypad.Buttons
+	inputStart uint64
+
+	// movie is the movie being played back, or recorded to movieFile when recording is set
+	movie     *movie.Movie
+	movieFile string
+	recording bool
+
+	// romFile is the loaded rom, and symbols are loaded from the .sym file next to it if there is one
+	romFile string
+	symbols *symbols.Table
+
+	// traceFile and tracer are set when tracing is on
+	traceFile *os.File
+	tracer    *cpu.Tracer
+
+	// clocked are the subsystems driven by the clock, and cycles is the total number of cycles run since power on
+	clocked []clocked
+	cycles  uint64
+
+	// rewind holds the states saved every rewindInterval frames, when rewinding is on. frame is the last frame
+	// that was checked for saving a state, and rewinding is set while the rewind key is held.
+	rewind         *rewind.Buffer
+	rewindInterval uint64
+	frame          uint64
+	rewinding      bool
+
+	// speed is how fast the system runs as a multiple of the hardware's speed, and fastForward is set while the
+	// fast forward key is held. Frames are paced against the cycles run since paceCycles at paceStart, and rendered
+	// is when a frame was last drawn.
+	speed       float64
+	fastForward bool
+	paceStart   time.Time
+	paceCycles  uint64
+	rendered    time.Time
+
+	// paused is set while the system is paused without the debugger, and advancing while it runs to the next frame
+	// to pause there
+	paused    bool
+	advancing bool
 
-	d, err := ui.NewDisplay(displayWidth, displayHeight, 1)
+	// resetRequested is set when a script resets the system, which happens once the current instruction finishes.
+	// powerCycleRequested is set when the reset is a power cycle.
+	resetRequested      bool
+	powerCycleRequested bool
+
+	// mcycleStepping is set when the CPU ticks the clock itself on every M-cycle
+	mcycleStepping bool
+
+	// stop is set to 1 to end Run. It is accessed atomically so Stop can be called from other goroutines.
+	stop int32
+
+	// commands are run by Run between instructions. Other goroutines use them to get at the system safely.
+	commands chan func()
+
+	// console is the terminal REPL, when it's running
+	console *liner.State
+}
+
+// NewSystem creates a new Gameboy system with a window scale times the size of the screen, drawn in the shades
+// of palette, and keys bound by bindings.
+func NewSystem(scale int, palette ui.Palette, bindings ui.Bindings) (*System, error) {
+	d, err := ui.NewDisplay(displayWidth, displayHeight, scale, palette)
 	if err != nil {
 		return &System{}, err
 	}
 
-	i := ui.NewInput(d)
+	i := ui.NewInput(d, bindings)
+
+	s := newSystem()
+	s.display = d
+	s.inputState = i
+	s.input = i.Buttons
+	s.turbo = i.TurboButtons
+	s.speed = 1
+	i.AttachStateSlots(s.saveSlot, s.loadSlot)
+	i.AttachControls(s)
+	return s, nil
+}
 
-	return &System{cpu: c, mmu: m, display: d, inputState: i}, nil
+// NewHeadlessSystem creates a Gameboy system without a window or keyboard, for playing movies back without
+// anyone watching. It runs as fast as it can unless the speed is set.
+func NewHeadlessSystem() *System {
+	return newSystem()
+}
+
+// newSystem creates the hardware of a Gameboy system.
+func newSystem() *System {
+	m := mmu.NewMMU()
+	c := cpu.NewCPU(m)
+	t := timer.NewTimer(m)
+	p := ppu.NewPPU(m)
+	j := joypad.NewJoypad(m)
+
+	// the MMU is clocked for OAM DMA transfers
+	s := &System{cpu: c, mmu: m, timer: t, ppu: p, joypad: j, clocked: []clocked{t, p, m}, commands: make(chan func())}
+	s.SetTurboRate(DefaultTurboRate)
+	return s
 }
 
 // PerformBootstrap runs the given bootstrap rom on startup. I'm unclear on copyright issues with this, so
@@ -49,44 +180,228 @@ func (s *System) PerformBootstrap(romFile string) error {
 	return nil
 }
 
-// LoadRom loads the given rom file into memory
-func (s *System) LoadRom(romFile string) {
+// LoadRom loads the given rom file into memory, along with the symbol file next to it if there is one.
+func (s *System) LoadRom(romFile string) error {
+	if err := s.mmu.LoadRom(romFile); err != nil {
+		return err
+	}
+	s.romFile = romFile
+
+	table, err := symbols.LoadForRom(romFile)
+	if err != nil {
+		return err
+	}
+	if table == nil {
+		return nil
+	}
+
+	log.Printf("Loaded %d symbols\n", table.Len())
+	s.symbols = table
+	if s.debugger != nil {
+		s.debugger.AttachSymbols(table)
+	}
+	return nil
 }
 
-// Run runs the system
+// Run runs the system until the window is closed or Stop is called
 func (s *System) Run() {
-	for {
-		if s.debugger.BreakpointActive {
+	if s.debugger != nil {
+		s.debugger.RunCallbacks("reset", map[string]interface{}{"powerCycle": true})
+	}
+
+	for !s.stopped() {
+		if s.debugger != nil && s.debugger.BreakpointActive {
 			s.stepWithBreakpoint()
+		} else if s.paused {
+			s.waitPaused()
 		} else {
 			s.step()
 		}
 	}
+
+	s.shutdown()
+}
+
+// Stop ends Run. It is safe to call from any goroutine.
+func (s *System) Stop() {
+	atomic.StoreInt32(&s.stop, 1)
+}
+
+// stopped returns true once the system should stop running
+func (s *System) stopped() bool {
+	return atomic.LoadInt32(&s.stop) == 1 || (s.display != nil && s.display.ShouldClose())
+}
+
+// shutdown cleans up after Run finishes
+func (s *System) shutdown() {
+	if s.tracer != nil {
+		if err := s.tracer.Flush(); err != nil {
+			log.Println("Error writing trace:", err)
+		}
+		s.traceFile.Close()
+	}
+
+	if s.console != nil {
+		s.console.Close()
+	}
+
+	if s.recording {
+		s.saveMovie()
+	}
+
+	if s.display != nil {
+		s.display.Stop()
+	}
 }
 
-// step executes an instruction
+// step executes an instruction, or runs backwards while the rewind key is held
 func (s *System) step() {
-	s.cpu.Step()
-	s.display.PollOSEvents()
+	if s.rewinding {
+		s.rewindFrame()
+		return
+	}
+
+	frame, vblanks := frameAt(s.cycles), s.ppu.Frames()
+	s.stepCPU()
+	s.runCommands()
+	s.pollEvents()
+
+	if frameAt(s.cycles) != frame {
+		s.endFrame()
+	}
+
+	// frame advance stops when VBlank starts, or at the end of the frame while the LCD is off and there's no VBlank
+	if s.advancing && (s.ppu.Frames() != vblanks || !s.ppu.LCDOn() && frameAt(s.cycles) != frame) {
+		s.advancing = false
+		s.paused = true
+	}
+}
+
+// pollEvents handles the window's events, when there is a window
+func (s *System) pollEvents() {
+	if s.display != nil {
+		s.display.PollOSEvents()
+	}
+}
+
+// rewindHeld returns true while the rewind key is held down
+func (s *System) rewindHeld() bool {
+	return s.inputState != nil && s.inputState.RewindHeld()
+}
+
+// runCommands runs any commands waiting to run
+func (s *System) runCommands() {
+	for {
+		select {
+		case cmd := <-s.commands:
+			cmd()
+			s.resetIfRequested()
+		default:
+			return
+		}
+	}
+}
+
+// runCommand runs fn between instructions and waits for it to finish. It must not be called from the goroutine
+// running the system.
+func (s *System) runCommand(fn func()) {
+	done := make(chan bool)
+	s.commands <- func() {
+		fn()
+		done <- true
+	}
+	<-done
+}
+
+// stepCPU executes an instruction and advances the clock by however long it took, unless the CPU already
+// did so itself one M-cycle at a time.
+func (s *System) stepCPU() {
+	cycles := s.cpu.Step()
+	if !s.mcycleStepping {
+		s.tick(cycles)
+	}
+	s.checkFrame()
+	s.resetIfRequested()
+}
+
+// EnableMCycleStepping makes the CPU advance the clock after every memory access and internal delay instead of
+// once per instruction. This is slower, but the rest of the system sees memory accesses on the M-cycle they
+// happen on, which some test ROMs depend on.
+func (s *System) EnableMCycleStepping() {
+	s.mcycleStepping = true
+	s.cpu.SetCycleHook(s.tick)
+}
+
+// disableMCycleStepping goes back to advancing the clock once per instruction.
+func (s *System) disableMCycleStepping() {
+	s.mcycleStepping = false
+	s.cpu.SetCycleHook(nil)
+}
+
+// tick advances the clock and all of the clocked subsystems by the given number of cycles
+func (s *System) tick(cycles int) {
+	s.cycles += uint64(cycles)
+
+	for _, c := range s.clocked {
+		c.Tick(cycles)
+	}
+}
+
+// Cycles returns the number of cycles run since power on
+func (s *System) Cycles() uint64 {
+	return s.cycles
 }
 
 // stepWithDebugger executes an instruction and waits for input from the debugger
 func (s *System) stepWithBreakpoint() {
 	cont := false
-	for !cont {
+	for !cont && !s.stopped() {
 		select {
 		case <-s.debugger.Step:
-			s.cpu.Step()
+			s.stepCPU()
 		case <-s.debugger.Cont:
-			s.debugger.BreakpointActive = false
+			s.debugger.Resume()
 			cont = true
 		default:
-			s.display.PollOSEvents()
+			s.runCommands()
+			s.pollEvents()
 		}
 	}
 }
 
-// StartDebugger creates a new debugger and then attaches it to all of the relevant subsystems.
+// debugMemory lets debugging tools read memory without triggering watchpoints
+type debugMemory struct {
+	*mmu.MMU
+}
+
+func (d debugMemory) ReadByte(location uint16) byte {
+	return d.PeekByte(location)
+}
+
+// StartTrace starts writing a gameboy-doctor compatible trace of every instruction executed between from and
+// to (inclusive) to file. If bank isn't -1 only instructions in that ROM bank are traced. When labels is set and
+// the rom's symbols have been loaded each line ends with the label the instruction is in.
+func (s *System) StartTrace(file string, from, to uint16, bank int, labels bool) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	tracer := cpu.NewTracer(f)
+	tracer.LimitRange(from, to)
+	tracer.LimitBank(bank)
+	if labels && s.symbols != nil {
+		tracer.AttachSymbols(s.symbols)
+	}
+	s.cpu.AttachTracer(tracer)
+
+	s.traceFile = f
+	s.tracer = tracer
+	return nil
+}
+
+// StartDebugger creates a new debugger and then attaches it to all of the relevant subsystems. If file isn't empty
+// it's loaded into the javascript debugger.
 func (s *System) StartDebugger(file string) error {
 	dbg := debugger.NewDebugger()
 
@@ -97,15 +412,107 @@ func (s *System) StartDebugger(file string) error {
 		return otto.Value{}
 	})
 
+	// create the disassemble(address, count) function for the js debugger that decodes count instructions
+	// starting at address
+	dbg.AttachFunction("disassemble", func(call otto.FunctionCall) otto.Value {
+		address, _ := call.Argument(0).ToInteger()
+		count, _ := call.Argument(1).ToInteger()
+		if count <= 0 {
+			count = 1
+		}
+
+		var insts []map[string]interface{}
+		location := uint16(address)
+		for i := int64(0); i < count; i++ {
+			inst := disasm.DecodeWithSymbols(debugMemory{s.mmu}, s.disasmSymbols(), location)
+			insts = append(insts, map[string]interface{}{
+				"bank":    inst.Bank,
+				"address": inst.Address,
+				"bytes":   inst.Bytes,
+				"text":    inst.Text,
+				"label":   inst.Label,
+				"listing": inst.String(),
+			})
+			location += inst.Len()
+		}
+
+		val, _ := call.Otto.ToValue(insts)
+		return val
+	})
+
+	dbg.AttachStepper(s.stepCPU)
+	dbg.AttachClock(s.Cycles, ppu.CyclesPerFrame)
+	s.attachControls(dbg)
+	s.attachInput(dbg)
+	if s.rewind != nil {
+		dbg.AttachRewind(s.rewindState)
+		dbg.AttachTimeline(s)
+	}
+	if s.symbols != nil {
+		dbg.AttachSymbols(s.symbols)
+	}
 	s.cpu.AttachDebugger(dbg)
 	s.mmu.AttachDebugger(dbg)
-	s.inputState.AttachDebugger(dbg)
+	s.ppu.AttachDebugger(dbg)
+	if s.inputState != nil {
+		s.inputState.AttachDebugger(dbg)
+	}
 
-	err := dbg.LoadSourceFile(file)
-	if err != nil {
-		return err
+	if file != "" {
+		if err := dbg.LoadSourceFile(file); err != nil {
+			return err
+		}
 	}
 	s.debugger = dbg
 
 	return nil
 }
+
+// StartGDBServer lets gdb and other GDB remote protocol clients debug the system over TCP at address, e.g. ":2345".
+// The debugger has to be started first.
+func (s *System) StartGDBServer(address string) error {
+	if s.debugger == nil {
+		return fmt.Errorf("the debugger isn't running")
+	}
+
+	return gdb.NewServer(s.debugger, s.runCommand).Listen(address)
+}
+
+// StartDAPServer lets editors debug the system over the Debug Adapter Protocol. address is either "stdio", in which
+// case the system stops when the editor disconnects, or a TCP address like ":4711". The debugger has to be started
+// first.
+func (s *System) StartDAPServer(address string) error {
+	if s.debugger == nil {
+		return fmt.Errorf("the debugger isn't running")
+	}
+
+	server := dap.NewServer(s.debugger, s.runCommand, s.Stop)
+	if address != "stdio" {
+		return server.Listen(address)
+	}
+
+	go func() {
+		server.Serve(os.Stdin, os.Stdout)
+		s.Stop()
+	}()
+	return nil
+}
+
+// disasmSymbols returns the symbols to decode instructions with, or nil if none are loaded.
+func (s *System) disasmSymbols() disasm.Symbols {
+	if s.symbols == nil {
+		return nil
+	}
+	return s.symbols
+}
+
+// AddBreakpoint adds a breakpoint written like "0150", "02:4000", "Main.loop", "0150 if A == $3F" or
+// "if LY == 144". The debugger has to be started first, and the rom loaded for labels to be found.
+func (s *System) AddBreakpoint(spec string) error {
+	if s.debugger == nil {
+		return fmt.Errorf("the debugger isn't running")
+	}
+
+	_, err := s.debugger.AddBreakpointSpec(spec)
+	return err
+}
@@ -0,0 +1,33 @@
+package timer
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// timerState is the timer state that goes into a save state.
+type timerState struct {
+	Counter uint16
+	TIMA    byte
+	TMA     byte
+	TAC     byte
+}
+
+// SaveState writes the timer's counter and registers to w.
+func (t *Timer) SaveState(w io.Writer) error {
+	return binary.Write(w, binary.LittleEndian, timerState{Counter: t.counter, TIMA: t.tima, TMA: t.tma, TAC: t.tac})
+}
+
+// LoadState restores the timer's counter and registers from r.
+func (t *Timer) LoadState(r io.Reader) error {
+	var state timerState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+
+	t.counter = state.Counter
+	t.tima = state.TIMA
+	t.tma = state.TMA
+	t.tac = state.TAC
+	return nil
+}
@@ -0,0 +1,86 @@
+package timer
+
+import (
+	"github.com/robmerrell/gmboy/system/mmu"
+)
+
+// The timer registers
+const (
+	divRegister  = 0xFF04
+	timaRegister = 0xFF05
+	tmaRegister  = 0xFF06
+	tacRegister  = 0xFF07
+)
+
+// timerEnable is the bit in TAC that turns TIMA on
+const timerEnable = 0x04
+
+// TIMA counts up every time a bit of the internal counter falls from 1 to 0. The bottom two bits of TAC select the bit:
+//
+//	00: bit 9 (4096 Hz)
+//	01: bit 3 (262144 Hz)
+//	10: bit 5 (65536 Hz)
+//	11: bit 7 (16384 Hz)
+var timerBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
+// Timer emulates the divider and timer registers. The divider (DIV) is the top byte of a 16-bit counter that
+// goes up every cycle. The timer (TIMA) counts up at a rate set by TAC and when it overflows it's reloaded from
+// TMA and requests the timer interrupt.
+type Timer struct {
+	counter uint16
+	tima    byte
+	tma     byte
+	tac     byte
+
+	mmu *mmu.MMU
+}
+
+// NewTimer creates a timer and maps its registers into memory.
+func NewTimer(m *mmu.MMU) *Timer {
+	t := &Timer{mmu: m}
+
+	// writing anything to DIV resets it
+	m.MapIORegister(divRegister, func() byte { return byte(t.counter >> 8) }, func(byte) { t.counter = 0 })
+	m.MapIORegister(timaRegister, func() byte { return t.tima }, func(value byte) { t.tima = value })
+	m.MapIORegister(tmaRegister, func() byte { return t.tma }, func(value byte) { t.tma = value })
+
+	// only the bottom 3 bits of TAC are used, the rest read as 1
+	m.MapIORegister(tacRegister, func() byte { return t.tac | 0xF8 }, func(value byte) { t.tac = value & 0x07 })
+
+	return t
+}
+
+// Reset stops the timer and clears its registers.
+func (t *Timer) Reset() {
+	t.counter = 0
+	t.tima = 0
+	t.tma = 0
+	t.tac = 0
+}
+
+// Tick advances the timer by the given number of cycles.
+func (t *Timer) Tick(cycles int) {
+	// the selected bits are all at least bit 3, so stepping 4 cycles at a time can't skip past a falling edge
+	for i := 0; i < cycles; i += 4 {
+		before := t.counter
+		t.counter += 4
+
+		if t.tac&timerEnable == 0 {
+			continue
+		}
+
+		bit := timerBits[t.tac&0x03]
+		if before&bit != 0 && t.counter&bit == 0 {
+			t.incrementTima()
+		}
+	}
+}
+
+// incrementTima increments TIMA, reloading it and requesting an interrupt when it overflows.
+func (t *Timer) incrementTima() {
+	t.tima++
+	if t.tima == 0 {
+		t.tima = t.tma
+		t.mmu.RequestInterrupt(mmu.InterruptTimer)
+	}
+}
@@ -0,0 +1,47 @@
+package timer
+
+import (
+	"github.com/robmerrell/gmboy/system/mmu"
+	"github.com/robmerrell/gmboy/testhelpers"
+	"testing"
+)
+
+func TestDivider(t *testing.T) {
+	m := mmu.NewMMU()
+	timer := NewTimer(m)
+
+	timer.Tick(256)
+	testhelpers.AssertByte(t, 0x01, m.ReadByte(divRegister))
+
+	// writing to DIV resets it
+	m.WriteBytes([]byte{0x55}, divRegister)
+	testhelpers.AssertByte(t, 0x00, m.ReadByte(divRegister))
+}
+
+func TestTimaRate(t *testing.T) {
+	m := mmu.NewMMU()
+	timer := NewTimer(m)
+
+	// enabled and counting every 16 cycles
+	m.WriteBytes([]byte{0x05}, tacRegister)
+	timer.Tick(64)
+	testhelpers.AssertByte(t, 0x04, m.ReadByte(timaRegister))
+
+	// disabled
+	m.WriteBytes([]byte{0x01}, tacRegister)
+	timer.Tick(64)
+	testhelpers.AssertByte(t, 0x04, m.ReadByte(timaRegister))
+}
+
+func TestTimaOverflow(t *testing.T) {
+	m := mmu.NewMMU()
+	timer := NewTimer(m)
+
+	m.WriteBytes([]byte{0xFF}, timaRegister)
+	m.WriteBytes([]byte{0x20}, tmaRegister)
+	m.WriteBytes([]byte{0x05}, tacRegister)
+	timer.Tick(16)
+
+	testhelpers.AssertByte(t, 0x20, m.ReadByte(timaRegister))
+	testhelpers.AssertByte(t, mmu.InterruptTimer, m.ReadByte(0xFF0F)&mmu.InterruptTimer)
+}
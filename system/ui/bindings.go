@@ -0,0 +1,193 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/robmerrell/gmboy/system/joystick"
+	"strconv"
+	"strings"
+)
+
+// keyNames are the names keys are bound with, besides the letters, digits and F1-F12. Names aren't case sensitive.
+var keyNames = map[string]glfw.Key{
+	"up": glfw.KeyUp, "down": glfw.KeyDown, "left": glfw.KeyLeft, "right": glfw.KeyRight,
+	"enter": glfw.KeyEnter, "tab": glfw.KeyTab, "space": glfw.KeySpace, "backspace": glfw.KeyBackspace,
+	"escape": glfw.KeyEscape, "insert": glfw.KeyInsert, "delete": glfw.KeyDelete,
+	"pageup": glfw.KeyPageUp, "pagedown": glfw.KeyPageDown, "home": glfw.KeyHome, "end": glfw.KeyEnd,
+	"leftshift": glfw.KeyLeftShift, "rightshift": glfw.KeyRightShift,
+	"leftctrl": glfw.KeyLeftControl, "rightctrl": glfw.KeyRightControl,
+	"leftalt": glfw.KeyLeftAlt, "rightalt": glfw.KeyRightAlt,
+	"comma": glfw.KeyComma, "period": glfw.KeyPeriod, "slash": glfw.KeySlash, "minus": glfw.KeyMinus,
+}
+
+// modifierNames are the names of the modifier keys that can be held with a hotkey, like "Ctrl+R".
+var modifierNames = map[string]glfw.ModifierKey{
+	"shift": glfw.ModShift, "ctrl": glfw.ModControl, "alt": glfw.ModAlt, "super": glfw.ModSuper,
+}
+
+// Hotkey is a key pressed with the modifier keys held.
+type Hotkey struct {
+	Key  glfw.Key
+	Mods glfw.ModifierKey
+}
+
+// matches returns true if key was pressed with exactly the hotkey's modifiers held.
+func (h Hotkey) matches(key glfw.Key, mods glfw.ModifierKey) bool {
+	return key == h.Key && mods == h.Mods
+}
+
+// Bindings are the keys bound to the buttons and hotkeys.
+type Bindings struct {
+	Up, Down, Left, Right, A, B, Select, Start glfw.Key
+
+	// TurboA and TurboB are held to fire A and B over and over
+	TurboA, TurboB glfw.Key
+
+	// the hotkeys pressed to control the system and the debugger
+	Next, Continue, Pause, FrameAdvance, Reset, PowerCycle Hotkey
+
+	// the keys held down to fast forward and rewind
+	FastForward, Rewind glfw.Key
+
+	// SaveState and LoadState are the modifiers held with a number key 1-9 to save or load that slot
+	SaveState, LoadState glfw.ModifierKey
+
+	// Joystick maps a joystick's buttons and axes onto the buttons
+	Joystick joystick.Mapping
+
+	// Macros are the hotkeys that play the macro with the same name
+	Macros map[string]Hotkey
+}
+
+// DefaultBindings returns the keys bound when nothing else is configured.
+func DefaultBindings() Bindings {
+	return Bindings{
+		Up: glfw.KeyUp, Down: glfw.KeyDown, Left: glfw.KeyLeft, Right: glfw.KeyRight,
+		A: glfw.KeyX, B: glfw.KeyZ, Select: glfw.KeyRightShift, Start: glfw.KeyEnter,
+		TurboA: glfw.KeyS, TurboB: glfw.KeyA,
+
+		Next:         Hotkey{Key: glfw.KeyN},
+		Continue:     Hotkey{Key: glfw.KeyK},
+		Pause:        Hotkey{Key: glfw.KeyP},
+		FrameAdvance: Hotkey{Key: glfw.KeyM},
+		Reset:        Hotkey{Key: glfw.KeyR, Mods: glfw.ModControl},
+		PowerCycle:   Hotkey{Key: glfw.KeyR, Mods: glfw.ModControl | glfw.ModShift},
+
+		FastForward: glfw.KeyTab,
+		Rewind:      glfw.KeyBackspace,
+		SaveState:   glfw.ModShift,
+
+		Joystick: joystick.DefaultMapping(),
+	}
+}
+
+// BindButton binds a Gameboy button, like "a" or "start", or a turbo button, "turbo_a" or "turbo_b", to a key,
+// like "X" or "Enter".
+func (b *Bindings) BindButton(button, key string) error {
+	buttons := map[string]*glfw.Key{
+		"up": &b.Up, "down": &b.Down, "left": &b.Left, "right": &b.Right,
+		"a": &b.A, "b": &b.B, "select": &b.Select, "start": &b.Start,
+		"turbo_a": &b.TurboA, "turbo_b": &b.TurboB,
+	}
+
+	binding, ok := buttons[strings.ToLower(button)]
+	if !ok {
+		return fmt.Errorf("%s isn't a button", button)
+	}
+	return parseKey(key, binding)
+}
+
+// BindHotkey binds a hotkey, like "reset", to keys, like "Ctrl+R". fast_forward and rewind are bound to a single
+// key, and save_state and load_state to the modifiers held with the slot number, like "Shift", or "" for none.
+func (b *Bindings) BindHotkey(hotkey, keys string) error {
+	hotkeys := map[string]*Hotkey{
+		"next": &b.Next, "continue": &b.Continue, "pause": &b.Pause, "frame_advance": &b.FrameAdvance,
+		"reset": &b.Reset, "power_cycle": &b.PowerCycle,
+	}
+	if binding, ok := hotkeys[hotkey]; ok {
+		return parseHotkey(keys, binding)
+	}
+
+	switch hotkey {
+	case "fast_forward":
+		return parseKey(keys, &b.FastForward)
+	case "rewind":
+		return parseKey(keys, &b.Rewind)
+	case "save_state":
+		return parseModifiers(keys, &b.SaveState)
+	case "load_state":
+		return parseModifiers(keys, &b.LoadState)
+	}
+	return fmt.Errorf("%s isn't a hotkey", hotkey)
+}
+
+// BindMacro binds the macro called name to keys, like "Ctrl+1".
+func (b *Bindings) BindMacro(name, keys string) error {
+	var hotkey Hotkey
+	if err := parseHotkey(keys, &hotkey); err != nil {
+		return err
+	}
+
+	if b.Macros == nil {
+		b.Macros = make(map[string]Hotkey)
+	}
+	b.Macros[name] = hotkey
+	return nil
+}
+
+// parseKey parses the name of a single key.
+func parseKey(name string, key *glfw.Key) error {
+	lower := strings.ToLower(name)
+	if k, ok := keyNames[lower]; ok {
+		*key = k
+		return nil
+	}
+
+	if len(lower) == 1 && lower[0] >= 'a' && lower[0] <= 'z' {
+		*key = glfw.KeyA + glfw.Key(lower[0]-'a')
+		return nil
+	}
+	if len(lower) == 1 && lower[0] >= '0' && lower[0] <= '9' {
+		*key = glfw.Key0 + glfw.Key(lower[0]-'0')
+		return nil
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(lower, "f")); err == nil && lower[0] == 'f' && n >= 1 && n <= 12 {
+		*key = glfw.KeyF1 + glfw.Key(n-1)
+		return nil
+	}
+
+	return fmt.Errorf("%q isn't a key", name)
+}
+
+// parseHotkey parses a key with any modifiers held, like "Ctrl+Shift+R".
+func parseHotkey(keys string, hotkey *Hotkey) error {
+	parts := strings.Split(keys, "+")
+
+	var parsed Hotkey
+	if err := parseModifiers(strings.Join(parts[:len(parts)-1], "+"), &parsed.Mods); err != nil {
+		return err
+	}
+	if err := parseKey(parts[len(parts)-1], &parsed.Key); err != nil {
+		return err
+	}
+
+	*hotkey = parsed
+	return nil
+}
+
+// parseModifiers parses modifier keys joined with +, like "Ctrl+Shift". An empty string is no modifiers.
+func parseModifiers(names string, mods *glfw.ModifierKey) error {
+	var parsed glfw.ModifierKey
+	if names != "" {
+		for _, name := range strings.Split(names, "+") {
+			mod, ok := modifierNames[strings.ToLower(name)]
+			if !ok {
+				return fmt.Errorf("%q isn't a modifier key", name)
+			}
+			parsed |= mod
+		}
+	}
+
+	*mods = parsed
+	return nil
+}
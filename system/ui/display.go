@@ -7,11 +7,13 @@ import (
 
 // Display holds everything needed to manage windows and draw to the screen
 type Display struct {
-	window *glfw.Window
+	window  *glfw.Window
+	palette Palette
 }
 
-// NewDisplay creates a new window and initializes OpenGL
-func NewDisplay(width, height, pixelScale int) (*Display, error) {
+// NewDisplay creates a new window and initializes OpenGL. Each pixel is drawn pixelScale pixels wide and high in
+// the shades of palette.
+func NewDisplay(width, height, pixelScale int, palette Palette) (*Display, error) {
 	err := glfw.Init()
 	if err != nil {
 		return nil, err
@@ -33,7 +35,8 @@ func NewDisplay(width, height, pixelScale int) (*Display, error) {
 	window.MakeContextCurrent()
 
 	gl.Disable(gl.DEPTH_TEST)
-	gl.ClearColor(0.0, 0.215, 0.361, 1.0)
+	r, g, b := palette[0].gl()
+	gl.ClearColor(r, g, b, 1.0)
 
 	gl.MatrixMode(gl.PROJECTION)
 	gl.LoadIdentity()
@@ -43,7 +46,7 @@ func NewDisplay(width, height, pixelScale int) (*Display, error) {
 	gl.LoadIdentity()
 
 	// just remove this once I'm ready to really start drawing
-	return &Display{window: window}, nil
+	return &Display{window: window, palette: palette}, nil
 }
 
 // Stop terminates drawing to the window
@@ -51,19 +54,25 @@ func (d *Display) Stop() {
 	glfw.Terminate()
 }
 
+// ShouldClose reports whether the user has asked to close the window
+func (d *Display) ShouldClose() bool {
+	return d.window.ShouldClose()
+}
+
 // PollOSEvents polls for events on the system, so things don't hang and window events can be processed
 func (d *Display) PollOSEvents() {
 	glfw.PollEvents()
 }
 
-// Draw draws the screenstate to the screen
+// Draw draws the screenstate to the screen. Each pixel is a shade from 0, the lightest, to 3, the darkest.
 func (d *Display) Draw(screenState [][]byte) {
 	gl.Clear(gl.COLOR_BUFFER_BIT)
-	gl.Color3f(1, 1, 1)
 
 	for y := range screenState {
 		for x := range screenState[y] {
-			if screenState[y][x] == 1 {
+			// the lightest shade is the background that was cleared to
+			if shade := screenState[y][x] & 0x03; shade != 0 {
+				gl.Color3f(d.palette[shade].gl())
 				gl.Recti(int32(x), int32(y), int32(x+1), int32(y+1))
 			}
 		}
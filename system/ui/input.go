@@ -3,6 +3,9 @@ package ui
 import (
 	"github.com/go-gl/glfw/v3.1/glfw"
 	"github.com/robmerrell/gmboy/system/debugger"
+	"github.com/robmerrell/gmboy/system/joypad"
+	"github.com/robmerrell/gmboy/system/joystick"
+	"log"
 )
 
 // InputState is the current state of all buttons. Since everything can be considered
@@ -18,48 +21,174 @@ type InputState struct {
 	Select bool
 	Start  bool
 
+	// turbo buttons, which fire A and B over and over while they're held
+	TurboA bool
+	TurboB bool
+
 	// window where we want to watch for input events
 	window *glfw.Window
 
+	// bindings are the keys bound to the buttons and hotkeys, and joystick reads the buttons held on a joystick
+	bindings Bindings
+	joystick *joystick.Reader
+
 	// debugger
 	debugger *debugger.Debugger
+
+	// saveSlot and loadSlot are called by the save state hotkeys with the slot number
+	saveSlot func(slot int)
+	loadSlot func(slot int)
+
+	// controls are run by the pause, frame advance and reset hotkeys
+	controls Controls
+}
+
+// Controls are the parts of the system the hotkeys control.
+type Controls interface {
+	TogglePause()
+	FrameAdvance()
+	Reset()
+	PowerCycle()
+	PlayMacro(name string) error
 }
 
 // NewInput creates a new input state for the game controls. A display is expected so that we know which window to look for keypresses in.
-func NewInput(display *Display) *InputState {
-	return &InputState{window: display.window}
+// A joystick can be used as well as the keyboard, and can be plugged in at any time.
+func NewInput(display *Display, bindings Bindings) *InputState {
+	i := &InputState{window: display.window, bindings: bindings, joystick: joystick.NewReader(GLFWJoysticks{}, bindings.Joystick)}
+	i.window.SetKeyCallback(i.handleKey)
+	return i
 }
 
 // AttachDebugger attaches a javascript debugger to the InputState and sets up hotkeys
 // for the debugger.
 func (i *InputState) AttachDebugger(dbg *debugger.Debugger) {
 	i.debugger = dbg
+}
+
+// AttachStateSlots sets up the save state hotkeys: the number keys 1-9 load a slot and holding shift saves it, unless
+// other modifiers are bound.
+func (i *InputState) AttachStateSlots(save, load func(slot int)) {
+	i.saveSlot = save
+	i.loadSlot = load
+}
+
+// AttachControls sets up the hotkeys that control the system. By default P pauses and resumes, M advances a frame,
+// ctrl+R resets and ctrl+shift+R power cycles.
+func (i *InputState) AttachControls(controls Controls) {
+	i.controls = controls
+}
+
+// handleKey handles the hotkeys.
+func (i *InputState) handleKey(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action != glfw.Release {
+		return
+	}
 
-	i.window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
-		// set the N key to "next" the debugger
-		if key == glfw.KeyN && action == glfw.Release {
-			i.debugger.Next()
+	b := i.bindings
+	if key >= glfw.Key1 && key <= glfw.Key9 && i.saveSlot != nil {
+		slot := int(key-glfw.Key1) + 1
+		switch mods {
+		case b.SaveState:
+			i.saveSlot(slot)
+			return
+		case b.LoadState:
+			i.loadSlot(slot)
+			return
 		}
+	}
 
-		// set the K key to "continue" the debugger
-		if key == glfw.KeyK && action == glfw.Release {
-			i.debugger.Continue()
+	if i.controls != nil {
+		switch {
+		case b.Pause.matches(key, mods):
+			i.controls.TogglePause()
+			return
+		case b.FrameAdvance.matches(key, mods):
+			i.controls.FrameAdvance()
+			return
+		case b.Reset.matches(key, mods):
+			i.controls.Reset()
+			return
+		case b.PowerCycle.matches(key, mods):
+			i.controls.PowerCycle()
+			return
 		}
 
-		// set the M key to advance one frame
-		if key == glfw.KeyM && action == glfw.Release {
+		for name, hotkey := range b.Macros {
+			if hotkey.matches(key, mods) {
+				if err := i.controls.PlayMacro(name); err != nil {
+					log.Println(err)
+				}
+				return
+			}
 		}
-	})
+	}
+
+	if i.debugger == nil {
+		return
+	}
+
+	// "next" and "continue" the debugger, N and K by default
+	if b.Next.matches(key, mods) {
+		i.debugger.Next()
+	}
+	if b.Continue.matches(key, mods) {
+		i.debugger.Continue()
+	}
+}
+
+// RewindHeld returns true while the rewind key, backspace by default, is held down.
+func (i *InputState) RewindHeld() bool {
+	return i.window.GetKey(i.bindings.Rewind) == glfw.Press
+}
+
+// FastForwardHeld returns true while the fast forward key, tab by default, is held down.
+func (i *InputState) FastForwardHeld() bool {
+	return i.window.GetKey(i.bindings.FastForward) == glfw.Press
 }
 
-// updateState updates the input state of a controller.
+// updateState updates the input state of a controller from the keyboard and joystick.
 func (i *InputState) updateState() {
-	i.Up = i.window.GetKey(glfw.KeyUp) == glfw.Press
-	i.Down = i.window.GetKey(glfw.KeyDown) == glfw.Press
-	i.Left = i.window.GetKey(glfw.KeyLeft) == glfw.Press
-	i.Right = i.window.GetKey(glfw.KeyRight) == glfw.Press
-	i.A = i.window.GetKey(glfw.KeyX) == glfw.Press
-	i.B = i.window.GetKey(glfw.KeyZ) == glfw.Press
-	i.Select = i.window.GetKey(glfw.KeyRightShift) == glfw.Press
-	i.Start = i.window.GetKey(glfw.KeyEnter) == glfw.Press
+	held, turbo := i.joystick.Buttons()
+	i.Up = i.window.GetKey(i.bindings.Up) == glfw.Press || held&joypad.Up != 0
+	i.Down = i.window.GetKey(i.bindings.Down) == glfw.Press || held&joypad.Down != 0
+	i.Left = i.window.GetKey(i.bindings.Left) == glfw.Press || held&joypad.Left != 0
+	i.Right = i.window.GetKey(i.bindings.Right) == glfw.Press || held&joypad.Right != 0
+	i.A = i.window.GetKey(i.bindings.A) == glfw.Press || held&joypad.A != 0
+	i.B = i.window.GetKey(i.bindings.B) == glfw.Press || held&joypad.B != 0
+	i.Select = i.window.GetKey(i.bindings.Select) == glfw.Press || held&joypad.Select != 0
+	i.Start = i.window.GetKey(i.bindings.Start) == glfw.Press || held&joypad.Start != 0
+	i.TurboA = i.window.GetKey(i.bindings.TurboA) == glfw.Press || turbo&joypad.A != 0
+	i.TurboB = i.window.GetKey(i.bindings.TurboB) == glfw.Press || turbo&joypad.B != 0
+}
+
+// Buttons reads the keyboard and joystick and returns the buttons held down on either.
+func (i *InputState) Buttons() joypad.Buttons {
+	i.updateState()
+
+	var buttons joypad.Buttons
+	for _, control := range []struct {
+		held   bool
+		button joypad.Buttons
+	}{
+		{i.Right, joypad.Right}, {i.Left, joypad.Left}, {i.Up, joypad.Up}, {i.Down, joypad.Down},
+		{i.A, joypad.A}, {i.B, joypad.B}, {i.Select, joypad.Select}, {i.Start, joypad.Start},
+	} {
+		if control.held {
+			buttons |= control.button
+		}
+	}
+	return buttons
+}
+
+// TurboButtons returns the buttons whose turbo buttons were held when the controls were last read by Buttons.
+func (i *InputState) TurboButtons() joypad.Buttons {
+	var buttons joypad.Buttons
+	if i.TurboA {
+		buttons |= joypad.A
+	}
+	if i.TurboB {
+		buttons |= joypad.B
+	}
+	return buttons
 }
@@ -0,0 +1,29 @@
+package ui
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// GLFWJoysticks reads the joysticks plugged in through GLFW. GLFW has to be initialized, which creating a display
+// does.
+type GLFWJoysticks struct{}
+
+// Present returns true if the joystick is plugged in.
+func (GLFWJoysticks) Present(joy int) bool {
+	return glfw.JoystickPresent(glfw.Joystick1 + glfw.Joystick(joy))
+}
+
+// Name returns the joystick's name.
+func (GLFWJoysticks) Name(joy int) string {
+	return glfw.GetJoystickName(glfw.Joystick1 + glfw.Joystick(joy))
+}
+
+// Axes returns the position of each of the joystick's axes, from -1 to 1.
+func (GLFWJoysticks) Axes(joy int) []float32 {
+	return glfw.GetJoystickAxes(glfw.Joystick1 + glfw.Joystick(joy))
+}
+
+// Buttons returns whether each of the joystick's buttons is held.
+func (GLFWJoysticks) Buttons(joy int) []byte {
+	return glfw.GetJoystickButtons(glfw.Joystick1 + glfw.Joystick(joy))
+}
@@ -0,0 +1,53 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Color is a color on the screen.
+type Color struct {
+	R, G, B byte
+}
+
+// Palette is the four shades the screen is drawn in, from lightest to darkest.
+type Palette [4]Color
+
+// palettes are the palettes that can be picked by name
+var palettes = map[string]Palette{
+	"grey":   {{0xFF, 0xFF, 0xFF}, {0xAA, 0xAA, 0xAA}, {0x55, 0x55, 0x55}, {0x00, 0x00, 0x00}},
+	"green":  {{0xE0, 0xF8, 0xD0}, {0x88, 0xC0, 0x70}, {0x34, 0x68, 0x56}, {0x08, 0x18, 0x20}},
+	"pocket": {{0xE3, 0xE6, 0xC9}, {0xC3, 0xC4, 0xA5}, {0x8E, 0x8B, 0x61}, {0x6C, 0x6C, 0x4E}},
+}
+
+// DefaultPalette is the palette used when no other is picked.
+const DefaultPalette = "grey"
+
+// ParsePalette parses a palette: either the name of one, grey, green or pocket, or four hex colors from lightest to
+// darkest separated by commas, like "#E0F8D0,#88C070,#346856,#081820".
+func ParsePalette(spec string) (Palette, error) {
+	if palette, ok := palettes[strings.ToLower(spec)]; ok {
+		return palette, nil
+	}
+
+	colors := strings.Split(spec, ",")
+	if len(colors) != 4 {
+		return Palette{}, fmt.Errorf("%q isn't a palette, expected grey, green, pocket or four colors", spec)
+	}
+
+	var palette Palette
+	for i, color := range colors {
+		rgb, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(color), "#"), 16, 24)
+		if err != nil {
+			return Palette{}, fmt.Errorf("%q isn't a color like #E0F8D0", color)
+		}
+		palette[i] = Color{byte(rgb >> 16), byte(rgb >> 8), byte(rgb)}
+	}
+	return palette, nil
+}
+
+// gl returns the color as the floats OpenGL expects.
+func (c Color) gl() (float32, float32, float32) {
+	return float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255
+}